@@ -9,4 +9,11 @@ require (
 	github.com/jackc/pgx/v4 v4.10.1
 	github.com/onsi/ginkgo v1.14.2
 	github.com/onsi/gomega v1.10.4
+	github.com/prometheus/client_golang v1.9.0
+	go.opentelemetry.io/otel v0.16.0
+	go.opentelemetry.io/otel/exporters/otlp v0.16.0
+	go.opentelemetry.io/otel/exporters/stdout v0.16.0
+	go.opentelemetry.io/otel/sdk v0.16.0
+	google.golang.org/grpc v1.34.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,152 @@
+package apiclient
+
+import (
+	"bytes"
+	"container/list"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// CacheConfig configures the in-memory cache of accounts fetched with `Fetch`.
+//
+// Cached accounts are never used without asking Account API: requests are sent with `If-None-Match` and `If-Modified-Since` headers,
+// and the cached account is used when Account API answers that it was not modified (304 status code).
+// The least recently used accounts are removed when the cache is full.
+type CacheConfig struct {
+	MaxEntries int // Number of cached responses, 1000 when not set
+}
+
+func (c *CacheConfig) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return 1000
+	}
+	return c.MaxEntries
+}
+
+// cachedResponse is a response with `ETag` or `Last-Modified` header
+type cachedResponse struct {
+	key    string
+	header http.Header
+	body   []byte
+}
+
+// responseCache is a LRU cache of responses by request URL
+type responseCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // values are *cachedResponse
+	recent  *list.List               // most recently used first
+}
+
+func newResponseCache(config *CacheConfig) *responseCache {
+	return &responseCache{
+		maxEntries: config.maxEntries(),
+		entries:    map[string]*list.Element{},
+		recent:     list.New(),
+	}
+}
+
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.recent.MoveToFront(element)
+	return element.Value.(*cachedResponse)
+}
+
+func (c *responseCache) add(entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[entry.key] = c.recent.PushFront(entry)
+	if c.recent.Len() > c.maxEntries {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+func (c *responseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.recent.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// cachingTransport revalidates cached responses of GET requests, and answers with the cached response when it was not modified
+type cachingTransport struct {
+	base  http.RoundTripper
+	cache *responseCache
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+	if req.Method != http.MethodGet {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			// the account was changed or deleted, its cached response will not be used again
+			withoutQuery := *req.URL
+			withoutQuery.RawQuery = ""
+			t.cache.remove(withoutQuery.String())
+		}
+		return resp, err
+	}
+
+	cached := t.cache.get(key)
+	conditionalReq := req
+	if cached != nil {
+		conditionalReq = req.Clone(req.Context())
+		if etag := cached.header.Get("ETag"); etag != "" {
+			conditionalReq.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.header.Get("Last-Modified"); lastModified != "" {
+			conditionalReq.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	resp, err := t.base.RoundTrip(conditionalReq)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		discardResponse(resp)
+		return cached.response(req), nil
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.cache.add(&cachedResponse{key: key, header: resp.Header.Clone(), body: body})
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	case resp.StatusCode == http.StatusNotFound:
+		t.cache.remove(key)
+	}
+	return resp, nil
+}
+
+// response is the cached response returned instead of 304 status code
+func (r *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,124 @@
+package apiclient_test
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with cache", func() {
+	type accountResponse struct {
+		Data apiclient.AccountResource `json:"data"`
+	}
+
+	var (
+		server              *ghttp.Server
+		accountsPath        string
+		accountClientConfig apiclient.AccountClientConfig
+		accountClient       *apiclient.AccountClient
+		accountID           string
+		found               func(accountID string, version int) http.HandlerFunc
+		notModified         http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		accountsPath = "/v1/accounts"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = accountsPath
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+			Cache:   &apiclient.CacheConfig{MaxEntries: 2},
+		}
+		accountClient = apiclient.NewAccountClient(&accountClientConfig)
+		accountID = libtest.GenerateID()
+		found = func(accountID string, version int) http.HandlerFunc {
+			return ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", path.Join(accountsPath, accountID)),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, accountResponse{Data: apiclient.AccountResource{ID: accountID, Version: version}},
+					http.Header{"ETag": []string{`"` + strconv.Itoa(version) + `"`}, "Last-Modified": []string{"Mon, 19 Oct 2020 10:00:00 GMT"}}),
+			)
+		}
+		notModified = ghttp.CombineHandlers(
+			ghttp.VerifyHeaderKV("If-None-Match", `"0"`),
+			ghttp.VerifyHeaderKV("If-Modified-Since", "Mon, 19 Oct 2020 10:00:00 GMT"),
+			ghttp.RespondWith(http.StatusNotModified, nil),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when the cached account was not modified", func() {
+
+		It("should return the cached account", func() {
+			server.AppendHandlers(found(accountID, 0), notModified)
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			account, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(account.ID).Should(Equal(accountID))
+			Ω(account.Version).Should(Equal(0))
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("when the cached account was modified", func() {
+
+		It("should return and cache the new version", func() {
+			server.AppendHandlers(
+				found(accountID, 0),
+				ghttp.CombineHandlers(ghttp.VerifyHeaderKV("If-None-Match", `"0"`), found(accountID, 1)),
+				ghttp.CombineHandlers(ghttp.VerifyHeaderKV("If-None-Match", `"1"`), ghttp.RespondWith(http.StatusNotModified, nil)),
+			)
+			for _, version := range []int{0, 1, 1} {
+				account, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(account.Version).Should(Equal(version))
+			}
+		})
+	})
+
+	Context("when the account was deleted", func() {
+
+		It("should not revalidate it", func() {
+			server.AppendHandlers(
+				found(accountID, 0),
+				ghttp.CombineHandlers(ghttp.VerifyRequest("DELETE", path.Join(accountsPath, accountID), "version=0"), ghttp.RespondWith(http.StatusNoContent, nil)),
+				ghttp.RespondWith(http.StatusNotFound, nil),
+			)
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			deleted, err := accountClient.Delete(accountID, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(deleted).Should(BeTrue())
+			_, err = accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			Ω(server.ReceivedRequests()[2].Header.Get("If-None-Match")).Should(BeEmpty())
+		})
+	})
+
+	Context("when the cache is full", func() {
+
+		It("should remove the least recently used account", func() {
+			otherIDs := []string{libtest.GenerateID(), libtest.GenerateID()}
+			server.AppendHandlers(found(accountID, 0), found(otherIDs[0], 0), notModified, found(otherIDs[1], 0), notModified, found(otherIDs[0], 0))
+			for _, id := range []string{accountID, otherIDs[0], accountID, otherIDs[1], accountID, otherIDs[0]} {
+				_, err := accountClient.Fetch(id)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(server.ReceivedRequests()[5].Header.Get("If-None-Match")).Should(BeEmpty())
+		})
+	})
+})
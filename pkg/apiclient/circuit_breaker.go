@@ -0,0 +1,211 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is a state of the circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed - requests are sent, failures are counted
+	CircuitClosed CircuitState = iota
+	// CircuitOpen - requests fail with `ErrCircuitOpen` without being sent
+	CircuitOpen
+	// CircuitHalfOpen - a limited number of trial requests is sent to check if Account API recovered
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerConfig configures the circuit breaker, which stops sending requests when Account API is failing.
+// Connection errors, timeouts and responses with 5xx status code are failures, requests cancelled by the caller are not counted.
+//
+// The circuit opens after `ConsecutiveFailures` failures in a row, or when `FailureRate` of requests in the `Window` failed.
+// After `OpenDuration` it lets `HalfOpenRequests` trial requests through, it closes when all of them succeed and opens again otherwise.
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int           // Failures in a row opening the circuit, 5 when not set
+	FailureRate         float64       // Fraction of failed requests opening the circuit, e.g. 0.5, not checked when not set
+	MinRequests         int           // Requests in the window needed before the failure rate is checked, 10 when not set
+	Window              time.Duration // Period requests are counted in for the failure rate, 1 minute when not set
+	OpenDuration        time.Duration // How long requests are rejected before trial requests are sent, 30 seconds when not set
+	HalfOpenRequests    int           // Number of trial requests, 1 when not set
+
+	OnStateChange func(from CircuitState, to CircuitState) // Called when the state changes, e.g. to report health
+}
+
+// circuitBreaker rejects requests with `ErrCircuitOpen` while the circuit is open
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu                  sync.Mutex
+	state               CircuitState
+	openedAt            time.Time
+	consecutiveFailures int
+	windowStart         time.Time
+	requests            int // in the window
+	failures            int // in the window
+	trials              int // trial requests sent in half-open state
+	trialSuccesses      int
+}
+
+func newCircuitBreaker(config *CircuitBreakerConfig) *circuitBreaker {
+	cb := &circuitBreaker{config: *config}
+	if cb.config.ConsecutiveFailures <= 0 {
+		cb.config.ConsecutiveFailures = 5
+	}
+	if cb.config.MinRequests <= 0 {
+		cb.config.MinRequests = 10
+	}
+	if cb.config.Window <= 0 {
+		cb.config.Window = time.Minute
+	}
+	if cb.config.OpenDuration <= 0 {
+		cb.config.OpenDuration = 30 * time.Second
+	}
+	if cb.config.HalfOpenRequests <= 0 {
+		cb.config.HalfOpenRequests = 1
+	}
+	return cb
+}
+
+// currentState returns the state, an open circuit becomes half-open after `OpenDuration`
+func (cb *circuitBreaker) currentState() CircuitState {
+	cb.mu.Lock()
+	state, changed := cb.refresh(time.Now())
+	cb.mu.Unlock()
+	cb.notify(changed, CircuitOpen, state)
+	return state
+}
+
+// allow checks if the request can be sent, it has to be followed by `done` when it returns true
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	state, changed := cb.refresh(time.Now())
+	allowed := true
+	switch state {
+	case CircuitOpen:
+		allowed = false
+	case CircuitHalfOpen:
+		allowed = cb.trials < cb.config.HalfOpenRequests
+		if allowed {
+			cb.trials++
+		}
+	}
+	cb.mu.Unlock()
+	cb.notify(changed, CircuitOpen, state)
+	return allowed
+}
+
+// done records result of the request
+func (cb *circuitBreaker) done(failed bool) {
+	cb.mu.Lock()
+	now := time.Now()
+	from := cb.state
+	switch cb.state {
+	case CircuitHalfOpen:
+		if failed {
+			cb.open(now)
+		} else if cb.trialSuccesses++; cb.trialSuccesses >= cb.config.HalfOpenRequests {
+			cb.close(now)
+		}
+	case CircuitClosed:
+		if now.Sub(cb.windowStart) > cb.config.Window {
+			cb.windowStart, cb.requests, cb.failures = now, 0, 0
+		}
+		cb.requests++
+		if !failed {
+			cb.consecutiveFailures = 0
+			break
+		}
+		cb.failures++
+		cb.consecutiveFailures++
+		rateExceeded := cb.config.FailureRate > 0 && cb.requests >= cb.config.MinRequests &&
+			float64(cb.failures)/float64(cb.requests) >= cb.config.FailureRate
+		if cb.consecutiveFailures >= cb.config.ConsecutiveFailures || rateExceeded {
+			cb.open(now)
+		}
+	}
+	to := cb.state
+	cb.mu.Unlock()
+	cb.notify(from != to, from, to)
+}
+
+// cancel records a request cancelled by the caller, which is neither a success nor a failure,
+// in half-open state another trial request can be sent in its place
+func (cb *circuitBreaker) cancel() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitHalfOpen && cb.trials > cb.trialSuccesses {
+		cb.trials--
+	}
+}
+
+// refresh moves an open circuit to half-open after `OpenDuration`, it has to be called with the lock held
+func (cb *circuitBreaker) refresh(now time.Time) (CircuitState, bool) {
+	if cb.state == CircuitOpen && now.Sub(cb.openedAt) >= cb.config.OpenDuration {
+		cb.state = CircuitHalfOpen
+		cb.trials, cb.trialSuccesses = 0, 0
+		return cb.state, true
+	}
+	return cb.state, false
+}
+
+func (cb *circuitBreaker) open(now time.Time) {
+	cb.state = CircuitOpen
+	cb.openedAt = now
+}
+
+func (cb *circuitBreaker) close(now time.Time) {
+	cb.state = CircuitClosed
+	cb.consecutiveFailures = 0
+	cb.windowStart, cb.requests, cb.failures = now, 0, 0
+}
+
+// notify calls the callback without the lock, so the callback can read the state
+func (cb *circuitBreaker) notify(changed bool, from CircuitState, to CircuitState) {
+	if changed && cb.config.OnStateChange != nil {
+		cb.config.OnStateChange(from, to)
+	}
+}
+
+// circuitBreakerTransport sends requests through the circuit breaker
+type circuitBreakerTransport struct {
+	base    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, fmt.Errorf("%v %w", t.breaker.config.OpenDuration, ErrCircuitOpen)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil && cancelledByCaller(req) {
+		// it says nothing about Account API
+		t.breaker.cancel()
+		return resp, err
+	}
+	t.breaker.done(err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
+
+// cancelledByCaller checks if the request was cancelled with its context,
+// requests which timed out are not cancelled by the caller, they are failures of Account API
+func cancelledByCaller(req *http.Request) bool {
+	return errors.Is(req.Context().Err(), context.Canceled)
+}
@@ -0,0 +1,205 @@
+package apiclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with circuit breaker", func() {
+	var (
+		server         *ghttp.Server
+		accountClient  *apiclient.AccountClient
+		breakerConfig  *apiclient.CircuitBreakerConfig
+		accountID      string
+		mu             sync.Mutex
+		stateChanges   []apiclient.CircuitState
+		failing        http.HandlerFunc
+		notFound       http.HandlerFunc
+		recordedStates = func() []apiclient.CircuitState {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]apiclient.CircuitState(nil), stateChanges...)
+		}
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/accounts"
+		stateChanges = nil
+		breakerConfig = &apiclient.CircuitBreakerConfig{
+			ConsecutiveFailures: 2,
+			OpenDuration:        50 * time.Millisecond,
+			OnStateChange: func(from apiclient.CircuitState, to apiclient.CircuitState) {
+				mu.Lock()
+				defer mu.Unlock()
+				stateChanges = append(stateChanges, to)
+			},
+		}
+		accountClient = apiclient.NewAccountClient(&apiclient.AccountClientConfig{
+			URL:            serverURL.String(),
+			Timeout:        time.Second,
+			CircuitBreaker: breakerConfig,
+		})
+		accountID = libtest.GenerateID()
+		failing = ghttp.RespondWith(http.StatusInternalServerError, nil)
+		notFound = ghttp.RespondWith(http.StatusNotFound, nil)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when Account API keeps failing", func() {
+
+		BeforeEach(func() {
+			server.AppendHandlers(failing, failing)
+			for i := 0; i < 2; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).Should(MatchError(apiclient.ErrInternal))
+			}
+		})
+
+		It("should open the circuit and stop sending requests", func() {
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitOpen))
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrCircuitOpen))
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+			Ω(recordedStates()).Should(Equal([]apiclient.CircuitState{apiclient.CircuitOpen}))
+		})
+
+		It("should close the circuit after a successful trial request", func() {
+			time.Sleep(breakerConfig.OpenDuration)
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitHalfOpen))
+			server.AppendHandlers(notFound)
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitClosed))
+			Ω(recordedStates()).Should(Equal([]apiclient.CircuitState{apiclient.CircuitOpen, apiclient.CircuitHalfOpen, apiclient.CircuitClosed}))
+		})
+
+		It("should open the circuit again after a failed trial request", func() {
+			time.Sleep(breakerConfig.OpenDuration)
+			server.AppendHandlers(failing)
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrInternal))
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitOpen))
+			_, err = accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrCircuitOpen))
+		})
+	})
+
+	Context("when Account API hangs", func() {
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			hanging := func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			}
+			server.AppendHandlers(hanging, hanging, hanging)
+			accountClient = apiclient.NewAccountClient(&apiclient.AccountClientConfig{
+				URL:            server.URL() + "/v1/accounts",
+				Timeout:        50 * time.Millisecond,
+				CircuitBreaker: breakerConfig,
+			})
+		})
+
+		AfterEach(func() {
+			close(release)
+		})
+
+		It("should count timed out requests as failures and open the circuit", func() {
+			for i := 0; i < 2; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).Should(HaveOccurred())
+			}
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitOpen))
+		})
+
+		It("should count requests timed out by the caller context as failures", func() {
+			for i := 0; i < 2; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				_, err := accountClient.FetchWithContext(ctx, accountID)
+				cancel()
+				Ω(err).Should(HaveOccurred())
+			}
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitOpen))
+		})
+
+		It("should not count requests cancelled by the caller", func() {
+			for i := 0; i < 3; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				_, err := accountClient.FetchWithContext(ctx, accountID)
+				Ω(err).Should(HaveOccurred())
+			}
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitClosed))
+			Ω(recordedStates()).Should(BeEmpty())
+		})
+	})
+
+	Context("when a trial request is cancelled by the caller", func() {
+
+		It("should keep the circuit half-open and send another trial request", func() {
+			server.AppendHandlers(failing, failing)
+			for i := 0; i < 2; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).Should(MatchError(apiclient.ErrInternal))
+			}
+			time.Sleep(breakerConfig.OpenDuration)
+
+			release := make(chan struct{})
+			defer close(release)
+			server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			}, notFound)
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			_, err := accountClient.FetchWithContext(ctx, accountID)
+			Ω(err).Should(HaveOccurred())
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitHalfOpen))
+
+			_, err = accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitClosed))
+		})
+	})
+
+	Context("when Account API responds with client errors", func() {
+
+		It("should keep the circuit closed", func() {
+			server.AppendHandlers(notFound, notFound, notFound)
+			for i := 0; i < 3; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			}
+			Ω(accountClient.CircuitState()).Should(Equal(apiclient.CircuitClosed))
+			Ω(recordedStates()).Should(BeEmpty())
+		})
+	})
+
+	Context("when circuit breaker is not configured", func() {
+
+		It("should report closed circuit", func() {
+			client := apiclient.NewAccountClient(&apiclient.AccountClientConfig{URL: server.URL()})
+			Ω(client.CircuitState()).Should(Equal(apiclient.CircuitClosed))
+		})
+	})
+})
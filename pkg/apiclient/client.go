@@ -1,53 +1,237 @@
 package apiclient
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path"
 	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AccountClient struct {
 	config     *AccountClientConfig
 	httpClient *http.Client
+	// streamClient has no timeout for requests streaming large bodies, their context limits them
+	streamClient *http.Client
+	configErr    error           // problem with the configuration, returned by every operation
+	breaker      *circuitBreaker // nil when the circuit breaker is not configured
+	tracer       trace.Tracer
+	propagator   propagation.TextMapPropagator
 }
 
 // NewAccountClient creates a new instance of AccountClient
 func NewAccountClient(config *AccountClientConfig) *AccountClient {
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(config.ProxyURL),
+	var configErr error
+	transport := config.Transport
+	if transport == nil {
+		httpTransport := &http.Transport{
+			Proxy: http.ProxyURL(config.ProxyURL),
+		}
+		if config.TLS != nil {
+			httpTransport.TLSClientConfig, configErr = config.TLS.clientTLSConfig()
+		}
+		transport = httpTransport
+	}
+
+	// user middleware sees requests before they are authorized and signed
+	middleware := append([]Middleware(nil), config.Middleware...)
+	if config.Cache != nil {
+		// cached responses are used when Account API answers, so they count as successes of the circuit breaker
+		cache := newResponseCache(config.Cache)
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return &cachingTransport{base: next, cache: cache}
+		})
+	}
+	var breaker *circuitBreaker
+	if config.CircuitBreaker != nil {
+		// a request is a failure only after all its retries failed
+		breaker = newCircuitBreaker(config.CircuitBreaker)
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return &circuitBreakerTransport{base: next, breaker: breaker}
+		})
+	}
+	if config.Retry != nil {
+		// retried requests are authorized and signed again
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return &retryTransport{base: next, config: config.Retry, now: time.Now}
+		})
+	}
+	if config.Hedging != nil {
+		// hedged requests wait for the rate limiter, and they can be sent to another endpoint
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return &hedgingTransport{base: next, config: config.Hedging}
+		})
+	}
+	if config.RateLimit != nil {
+		// every attempt waits, and requests are authorized and signed after waiting
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return newLimitingTransport(next, config.RateLimit)
+		})
+	}
+	if config.Endpoints != nil {
+		// every endpoint gets requests authorized and signed for itself
+		balancer, err := newEndpointBalancer(config.Endpoints)
+		if err != nil && configErr == nil {
+			configErr = err
+		}
+		if balancer != nil {
+			middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+				return &endpointTransport{base: next, balancer: balancer}
+			})
+		}
+	}
+	if config.OAuth2 != nil {
+		// token endpoint is called with the same transport, but without the token itself
+		tokenClient := http.Client{
+			Transport: transport,
+			Timeout:   config.Timeout,
+		}
+		source := newClientCredentialsTokenSource(config.OAuth2, &tokenClient)
+		// requests retried after 401 go through the rest of the chain again, so they are signed again
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return &oauth2Transport{base: next, source: source}
+		})
+	}
+	if config.RequestSigning != nil {
+		signer := &httpsig.Signer{
+			KeyID:  config.RequestSigning.KeyID,
+			Secret: config.RequestSigning.Secret,
+		}
+		middleware = append(middleware, func(next http.RoundTripper) http.RoundTripper {
+			return &signingTransport{base: next, signer: signer}
+		})
 	}
 
 	httpClient := http.Client{
-		Transport: transport,
+		Transport: chain(&observingTransport{base: transport}, middleware...),
 		Timeout:   config.Timeout,
 	}
+	streamClient := http.Client{
+		Transport: httpClient.Transport,
+	}
+
+	tracerProvider := config.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = trace.NewNoopTracerProvider()
+	}
+	propagator := config.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
 
 	return &AccountClient{
-		config:     config,
-		httpClient: &httpClient,
+		config:       config,
+		httpClient:   &httpClient,
+		streamClient: &streamClient,
+		configErr:    configErr,
+		breaker:      breaker,
+		tracer:       tracerProvider.Tracer(instrumentationName),
+		propagator:   propagator,
 	}
 
 }
 
+// do sends the request to Account API and handles failures common to all operations
+func (client *AccountClient) do(req *http.Request) (*http.Response, error) {
+	return client.send(client.httpClient, req)
+}
+
+// stream is like `do`, but the request is not limited by `AccountClientConfig.Timeout`, only by its context
+func (client *AccountClient) stream(req *http.Request) (*http.Response, error) {
+	return client.send(client.streamClient, req)
+}
+
+func (client *AccountClient) send(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	if client.configErr != nil {
+		return nil, fmt.Errorf("wrong client config %v %w", client.configErr, ErrWrongConfig)
+	}
+	// W3C trace-context headers link Account API spans with the operation span
+	client.propagator.Inject(req.Context(), req.Header)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrCircuitOpen) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("response error %v %w", err, ErrConnection)
+	}
+	trace.SpanFromContext(req.Context()).SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+	if resp.StatusCode == http.StatusUnauthorized { // 401
+		discardResponse(resp)
+		return nil, fmt.Errorf("response status code %v %w", resp.StatusCode, ErrUnauthorized)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests { // 429
+		discardResponse(resp)
+		return nil, fmt.Errorf("response status code %v, retry after %v %w", resp.StatusCode, resp.Header.Get("Retry-After"), ErrRateLimited)
+	}
+	return resp, nil
+}
+
 //
 // CONFIG
 //
 
 // AccountClientConfig is a configuration used to create `AccountClient`
 type AccountClientConfig struct {
-	URL      string        // Account API url address
+	URL      string        // Account API url address, not used when `Endpoints` are set
 	ProxyURL *url.URL      // Proxy to use when connecting to Account API
 	Timeout  time.Duration // HTTP connection timeout
+	OAuth2   *OAuth2Config // OAuth2 client-credentials grant, when set every request carries an access token
+
+	RequestSigning *RequestSigningConfig // HMAC request signing, when set every request is signed with the shared secret
+	TLS            *TLSConfig            // TLS options: CA bundle, client certificate for mutual TLS, minimum version and server name
+
+	Transport  http.RoundTripper // Base transport used to send requests, when set `ProxyURL` and `TLS` are not used
+	Middleware []Middleware      // Wraps the transport in the listed order, e.g. `UserAgent`, `RequestID` or `Headers`
+
+	TracerProvider trace.TracerProvider          // OpenTelemetry tracing, when set every operation creates a span
+	Propagator     propagation.TextMapPropagator // Sends trace context to Account API, W3C trace-context when not set
+
+	Observer Observer // Notified when an operation finishes, e.g. to collect metrics, see `promobserver` package
+
+	Retry     *RetryConfig     // Retries requests rejected because of rate limits, honouring `Retry-After` header, requests are not retried when not set
+	RateLimit *RateLimitConfig // Limits requests per second and concurrent requests of the client, not limited when not set
+
+	CircuitBreaker *CircuitBreakerConfig // Stops sending requests while Account API is failing, requests are always sent when not set
+
+	Cache     *CacheConfig     // Keeps fetched accounts and revalidates them with conditional requests, nothing is cached when not set
+	Hedging   *HedgingConfig   // Sends reads again when they are not answered in time, reads are sent once when not set
+	Endpoints *EndpointsConfig // Many Account API url addresses with load balancing and failover, replaces `URL` when set
+
+	BatchSize int // Accounts sent in one `CreateBatch` request, at most the Accounts API limit (1000 by default), 100 when not set
+}
+
+func (c *AccountClientConfig) batchSize() int {
+	if c.BatchSize <= 0 {
+		return 100
+	}
+	return c.BatchSize
+}
+
+// CircuitState returns state of the circuit breaker, e.g. for health reporting. It is always closed when the circuit breaker is not configured.
+func (client *AccountClient) CircuitState() CircuitState {
+	if client.breaker == nil {
+		return CircuitClosed
+	}
+	return client.breaker.currentState()
 }
 
-// getURL is a helper function that takes `AccountClientConfig.URL` and adds a subpath and query parameters
+// getURL is a helper function that takes `AccountClientConfig.URL` and adds a subpath and query parameters.
+// With `Endpoints` the first endpoint is used, and requests are sent to the selected endpoint by the transport.
 func (c *AccountClientConfig) getURL(subpath string, query url.Values) (string, error) {
-	serverURL, err := url.Parse(c.URL)
+	rawURL := c.URL
+	if c.Endpoints != nil && len(c.Endpoints.URLs) > 0 {
+		rawURL = c.Endpoints.URLs[0]
+	}
+	serverURL, err := url.Parse(rawURL)
 	if err != nil {
-		return "", fmt.Errorf("Wrong server URL %v: %v", c.URL, err)
+		return "", fmt.Errorf("Wrong server URL %v: %v", rawURL, err)
 	}
 	endpointURL := url.URL{
 		Scheme:   serverURL.Scheme,
@@ -84,6 +268,40 @@ var (
 
 	// ErrWrongConfig is returned when `AccountClientConfig` contains not valid configuration
 	ErrWrongConfig = errors.New("Wrong config")
+
+	// ErrUnauthorized is returned when the client is not authorized to call Account API
+	// That includes: token endpoint rejected client credentials, and Account API rejected the request with 401 status code
+	ErrUnauthorized = errors.New("Not authorized to call API server: please check your credentials")
+
+	// ErrCircuitOpen is returned without sending the request when the circuit breaker is open because Account API was failing,
+	// see `CircuitBreakerConfig`
+	ErrCircuitOpen = errors.New("API server is failing: requests are not sent until it recovers")
+
+	// ErrRateLimited is returned when Account API rejected the request with 429 status code because the client sent too many requests,
+	// when `AccountClientConfig.Retry` is set it is returned after all attempts were rejected
+	ErrRateLimited = errors.New("Too many requests to API server: please retry later")
+
+	// ErrBatchFailed is returned when Account API did not create accounts of an atomic batch request because some of them cannot be created,
+	//  - `AccountClient.CreateBatch` returns the `ErrBatchFailed` with results saying why accounts were not created
+	ErrBatchFailed = errors.New("Accounts of the batch were not created")
+
+	// ErrImportFailed is returned when Account API did not import accounts,
+	//  - `AccountClient.Import` returns the `ErrImportFailed` when the file is malformed,
+	//    or with the summary when accounts already exist and `ImportFail` policy was used
+	ErrImportFailed = errors.New("Accounts were not imported")
+
+	// ErrNoReport is returned when the import report does not exist, e.g. it was removed after 7 days
+	//  - `AccountClient.ImportReport` returns the `ErrNoReport` when the report cannot be downloaded
+	ErrNoReport = errors.New("Import report does not exist")
+
+	// ErrNoJob is returned when the job does not exist, e.g. it was removed 7 days after it finished
+	//  - `AccountClient.GetJob`, `AccountClient.CancelJob` and `AccountClient.JobResult` return the `ErrNoJob` when the job cannot be found
+	ErrNoJob = errors.New("Job does not exist")
+
+	// ErrJobFailed is returned when the job did not succeed,
+	//  - `AccountClient.WaitJob` returns the `ErrJobFailed` with the job when it failed or was cancelled
+	//  - `AccountClient.JobResult` returns the `ErrJobFailed` when the job has no result
+	ErrJobFailed = errors.New("Job did not succeed")
 )
 
 //
@@ -92,12 +310,22 @@ var (
 
 // AccountListFilter is a collection of filters used by `AccountClient.List` operation
 type AccountListFilter struct {
-	AccountNumber []string
-	BankID        []string
-	BankIDCode    []string
-	Country       []string
-	CustomerID    []string
-	IBAN          []string
+	AccountNumber         []string
+	BankID                []string
+	BankIDCode            []string
+	Country               []string
+	CustomerID            []string
+	IBAN                  []string
+	Status                []string  // e.g. `confirmed`
+	AccountClassification []string  // `personal` or `business`
+	BaseCurrency          []string  // e.g. `GBP`
+	OrganisationID        []string  // UUIDs of organisations owning accounts
+	JointAccount          *bool     // accounts without `joint_account` attribute are not joint accounts, not filtered when nil
+	CreatedFrom           time.Time // accounts created at or after the time, not filtered when zero
+	CreatedTo             time.Time // accounts created before the time, not filtered when zero
+	ModifiedFrom          time.Time // accounts modified at or after the time, not filtered when zero
+	ModifiedTo            time.Time // accounts modified before the time, not filtered when zero
+	Name                  string    // case-insensitive prefix of any of account names, not filtered when empty
 }
 
 // AccountPage is an argument used for `AccountClient.List` operation
@@ -106,6 +334,26 @@ type AccountPage struct {
 	PageNumber int               // Which page should be requested first, starts with 0
 	PageSize   int               // Number of Accounts on each page
 	Filter     AccountListFilter // Filters used to filter results
+	Fields     []string          // Attributes returned for every account, e.g. `country`, all when empty. `private_identification` requires `pii:read` scope
+	Sort       []Sort            // Order of Accounts, by the first field then the next ones, by `id` when empty
+}
+
+// SortField is an Account attribute used to sort `AccountClient.List` results
+type SortField string
+
+const (
+	SortByID         SortField = "id"
+	SortByCreatedOn  SortField = "created_on"
+	SortByModifiedOn SortField = "modified_on" // not modified accounts are last
+	SortByCountry    SortField = "country"
+	SortByName       SortField = "name" // the first name, case-insensitive
+	SortByStatus     SortField = "status"
+)
+
+// Sort orders Accounts by the field, ascending unless `Descending` is set
+type Sort struct {
+	Field      SortField
+	Descending bool
 }
 
 // FirstPage is a helper to request first page with default size
@@ -122,10 +370,11 @@ var FirstPage = AccountPage{PageNumber: 0, PageSize: 100}
 //     // if no err then `data` contains `[]AccountResource`
 //   }
 type AccountPageResult struct {
+	ctx       context.Context
 	currPage  AccountPage
 	currData  []AccountResource
 	lastError error
-	loadData  func(page AccountPage) ([]AccountResource, error)
+	loadData  func(ctx context.Context, page AccountPage) ([]AccountResource, error)
 }
 
 // Next send request to Account API and fetches data for the next page.
@@ -142,7 +391,7 @@ func (c *AccountPageResult) Next() bool {
 		c.currPage.PageNumber++
 		c.currData = nil
 	}
-	nextData, err := c.loadData(c.currPage)
+	nextData, err := c.loadData(c.ctx, c.currPage)
 	if err != nil {
 		c.lastError = err
 		if errors.Is(err, ErrNoAccount) {
@@ -160,8 +409,9 @@ func (c *AccountPageResult) Next() bool {
 // `Data()` will aslo retturn error if called before first `Next()`, or after `Next()` returned `false`.
 //
 // Returns errors:
-//   - ErrWrongConfig when Server URL is malformed
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
 //   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
 //   - ErrNoAccount when there is no more accounts, after `Next()` returned `false`
 //   - ErrInternal when other, not handled issues appear
 func (c *AccountPageResult) Data() ([]AccountResource, error) {
@@ -0,0 +1,216 @@
+package apiclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadBalancing selects the endpoint every request is sent to
+type LoadBalancing int
+
+const (
+	// RoundRobin sends requests to healthy endpoints in turn
+	RoundRobin LoadBalancing = iota
+	// LeastLatency sends requests to the healthy endpoint with the lowest average response time,
+	// endpoints without measured response time are tried first
+	LeastLatency
+)
+
+// EndpointsConfig configures Account API served from many endpoints, e.g. in different regions.
+//
+// Endpoints failing `EjectAfter` times in a row (connection errors, timeouts and 5xx status codes) are not used for `EjectDuration`,
+// after that they get requests again, and they are ejected after the first failure until a request succeeds.
+// When all endpoints are ejected requests are sent to the one which was ejected first.
+//
+// Failed reads and deletes are sent again to the next endpoint, creates are sent to one endpoint only
+// because the server could have created the account.
+type EndpointsConfig struct {
+	URLs          []string      // Account API url addresses with the same path, e.g. `https://eu.example.com/v1/accounts`
+	LoadBalancing LoadBalancing // RoundRobin when not set
+	EjectAfter    int           // Failures in a row ejecting the endpoint, 3 when not set
+	EjectDuration time.Duration // How long the ejected endpoint is not used, 30 seconds when not set
+}
+
+// endpoint is an Account API url address with its health
+type endpoint struct {
+	index int
+	url   *url.URL
+
+	// guarded by endpointBalancer.mu
+	consecutiveFailures int
+	ejectedUntil        time.Time
+	latency             time.Duration // moving average of response time, 0 when not measured yet
+}
+
+// endpointBalancer selects endpoints and tracks their health
+type endpointBalancer struct {
+	config    EndpointsConfig
+	endpoints []*endpoint
+
+	mu   sync.Mutex
+	next int // next endpoint checked by round robin
+}
+
+func newEndpointBalancer(config *EndpointsConfig) (*endpointBalancer, error) {
+	if len(config.URLs) == 0 {
+		return nil, fmt.Errorf("no endpoint URLs")
+	}
+	b := &endpointBalancer{config: *config}
+	if b.config.EjectAfter <= 0 {
+		b.config.EjectAfter = 3
+	}
+	if b.config.EjectDuration <= 0 {
+		b.config.EjectDuration = 30 * time.Second
+	}
+	for i, rawURL := range config.URLs {
+		endpointURL, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("wrong endpoint URL %v: %v", rawURL, err)
+		}
+		if endpointURL.Scheme == "" || endpointURL.Host == "" {
+			return nil, fmt.Errorf("endpoint URL %v has no scheme or host", rawURL)
+		}
+		b.endpoints = append(b.endpoints, &endpoint{index: i, url: endpointURL})
+	}
+	return b, nil
+}
+
+// pick returns the endpoint for the next attempt, skipping endpoints already tried by the request.
+// It returns nil when there is no healthy endpoint left to fail over to.
+func (b *endpointBalancer) pick(tried []bool, now time.Time) *endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var picked *endpoint
+	for i := range b.endpoints {
+		// round robin starts with the next endpoint, least latency checks all of them
+		ep := b.endpoints[(b.next+i)%len(b.endpoints)]
+		if tried[ep.index] || now.Before(ep.ejectedUntil) {
+			continue
+		}
+		if b.config.LoadBalancing != LeastLatency {
+			picked = ep
+			break
+		}
+		if picked == nil || ep.latency < picked.latency {
+			picked = ep
+		}
+	}
+	if picked == nil && !anyTried(tried) {
+		// all endpoints are ejected, the one ejected first is the most likely to recover
+		for _, ep := range b.endpoints {
+			if picked == nil || ep.ejectedUntil.Before(picked.ejectedUntil) {
+				picked = ep
+			}
+		}
+	}
+	if picked != nil {
+		b.next = (picked.index + 1) % len(b.endpoints)
+	}
+	return picked
+}
+
+// done records result of the request sent to the endpoint
+func (b *endpointBalancer) done(ep *endpoint, failed bool, latency time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		ep.consecutiveFailures = 0
+		if ep.latency == 0 {
+			ep.latency = latency
+		} else {
+			ep.latency = (4*ep.latency + latency) / 5
+		}
+		return
+	}
+	ep.consecutiveFailures++
+	if ep.consecutiveFailures >= b.config.EjectAfter {
+		ep.ejectedUntil = now.Add(b.config.EjectDuration)
+	}
+}
+
+func anyTried(tried []bool) bool {
+	for _, t := range tried {
+		if t {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointTransport sends requests built for the first endpoint to the endpoint selected by the balancer
+type endpointTransport struct {
+	base     http.RoundTripper
+	balancer *endpointBalancer
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make([]bool, len(t.balancer.endpoints))
+	ep := t.balancer.pick(tried, time.Now())
+	body := req.Body
+	for {
+		tried[ep.index] = true
+		attemptReq := req.Clone(req.Context())
+		attemptReq.Body = body
+		attemptReq.URL = t.endpointURL(req.URL, ep)
+		attemptReq.Host = ""
+
+		start := time.Now()
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil && cancelledByCaller(req) {
+			// it says nothing about the endpoint
+			return resp, err
+		}
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		t.balancer.done(ep, failed, time.Since(start), time.Now())
+		// a timed out request cannot be sent to the next endpoint
+		if !failed || !idempotent(req) || req.Context().Err() != nil {
+			return resp, err
+		}
+		// the request body has been already consumed and cannot be sent again
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		if ep = t.balancer.pick(tried, time.Now()); ep == nil {
+			return resp, err
+		}
+		if req.GetBody != nil {
+			var bodyErr error
+			if body, bodyErr = req.GetBody(); bodyErr != nil {
+				return resp, err
+			}
+		}
+		if resp != nil {
+			discardResponse(resp)
+		}
+	}
+}
+
+// endpointURL replaces scheme, host and path prefix of the first endpoint with the selected one.
+// Resources next to accounts, e.g. `/v1/account-imports`, replace the parent path of the endpoint.
+func (t *endpointTransport) endpointURL(reqURL *url.URL, ep *endpoint) *url.URL {
+	prefix, epPath := t.balancer.endpoints[0].url.Path, ep.url.Path
+	if reqURL.Path != prefix && !strings.HasPrefix(reqURL.Path, strings.TrimSuffix(prefix, "/")+"/") {
+		prefix, epPath = path.Dir(strings.TrimSuffix(prefix, "/")), path.Dir(strings.TrimSuffix(epPath, "/"))
+	}
+	endpointURL := *reqURL
+	endpointURL.Scheme = ep.url.Scheme
+	endpointURL.Host = ep.url.Host
+	endpointURL.Path = path.Join(epPath, strings.TrimPrefix(reqURL.Path, prefix))
+	endpointURL.RawPath = ""
+	return &endpointURL
+}
+
+// idempotent checks if the request can be sent to another endpoint after it failed,
+// deleting an account with its version has the same effect when repeated
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+		return true
+	}
+	return false
+}
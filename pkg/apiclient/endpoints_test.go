@@ -0,0 +1,234 @@
+package apiclient_test
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with many endpoints", func() {
+	type accountResponse struct {
+		Data apiclient.AccountResource `json:"data"`
+	}
+
+	var (
+		euServer        *ghttp.Server
+		usServer        *ghttp.Server
+		endpointsConfig *apiclient.EndpointsConfig
+		accountClient   *apiclient.AccountClient
+		accountID       string
+		found           func(serverPath string) http.HandlerFunc
+		failing         http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		euServer = ghttp.NewServer()
+		usServer = ghttp.NewServer()
+		// endpoints can be served under different paths, e.g. behind a gateway
+		endpointsConfig = &apiclient.EndpointsConfig{
+			URLs:          []string{euServer.URL() + "/v1/accounts", usServer.URL() + "/us/v1/accounts"},
+			EjectAfter:    2,
+			EjectDuration: time.Minute,
+		}
+		accountClient = apiclient.NewAccountClient(&apiclient.AccountClientConfig{
+			Timeout:   time.Second,
+			Endpoints: endpointsConfig,
+		})
+		accountID = libtest.GenerateID()
+		found = func(serverPath string) http.HandlerFunc {
+			return ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", path.Join(serverPath, accountID)),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, accountResponse{Data: apiclient.AccountResource{ID: accountID}}),
+			)
+		}
+		failing = ghttp.RespondWith(http.StatusInternalServerError, nil)
+	})
+
+	AfterEach(func() {
+		euServer.Close()
+		usServer.Close()
+	})
+
+	Context("with round robin load balancing", func() {
+
+		It("should send requests to endpoints in turn", func() {
+			euServer.AppendHandlers(found("/v1/accounts"), found("/v1/accounts"))
+			usServer.AppendHandlers(found("/us/v1/accounts"), found("/us/v1/accounts"))
+			for i := 0; i < 4; i++ {
+				account, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(account.ID).Should(Equal(accountID))
+			}
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(2))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("with resources next to accounts", func() {
+
+		It("should send requests under the parent path of the endpoint", func() {
+			report := "/v1/account-imports/import-1/rejections"
+			euServer.AppendHandlers(ghttp.CombineHandlers(ghttp.VerifyRequest("GET", report), ghttp.RespondWith(http.StatusOK, "")))
+			usServer.AppendHandlers(ghttp.CombineHandlers(ghttp.VerifyRequest("GET", "/us"+report), ghttp.RespondWith(http.StatusOK, "")))
+			for i := 0; i < 2; i++ {
+				body, err := accountClient.ImportReport(context.Background(), &apiclient.ImportSummary{ID: "import-1", Report: report})
+				Ω(err).ShouldNot(HaveOccurred())
+				body.Close()
+			}
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(1))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when an endpoint fails", func() {
+
+		It("should send reads to the next endpoint", func() {
+			euServer.AppendHandlers(failing)
+			usServer.AppendHandlers(found("/us/v1/accounts"))
+			account, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(account.ID).Should(Equal(accountID))
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(1))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(1))
+		})
+
+		It("should send reads to the next endpoint when it is unreachable", func() {
+			euServer.Close()
+			usServer.AppendHandlers(found("/us/v1/accounts"))
+			account, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(account.ID).Should(Equal(accountID))
+		})
+
+		It("should not send creates to the next endpoint", func() {
+			euServer.AppendHandlers(failing)
+			_, err := accountClient.Create(accountID, libtest.GenerateOrganisationID(), libtest.GenerateAccountAttributes())
+			Ω(err).Should(MatchError(apiclient.ErrInternal))
+			Ω(usServer.ReceivedRequests()).Should(BeEmpty())
+		})
+
+		It("should return the last failure when all endpoints fail", func() {
+			euServer.AppendHandlers(failing)
+			usServer.AppendHandlers(failing)
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrInternal))
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(1))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when an endpoint keeps failing", func() {
+
+		It("should eject it", func() {
+			// round robin starts with eu for the first and the third request
+			euServer.AppendHandlers(failing, failing)
+			usServer.AppendHandlers(found("/us/v1/accounts"), found("/us/v1/accounts"), found("/us/v1/accounts"),
+				found("/us/v1/accounts"), found("/us/v1/accounts"))
+			for i := 0; i < 5; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(2))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(5))
+		})
+	})
+
+	Context("when an endpoint hangs", func() {
+		var (
+			release chan struct{}
+			hanging http.HandlerFunc
+		)
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			hanging = func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			}
+			accountClient = apiclient.NewAccountClient(&apiclient.AccountClientConfig{
+				Timeout:   50 * time.Millisecond,
+				Endpoints: endpointsConfig,
+			})
+		})
+
+		AfterEach(func() {
+			close(release)
+		})
+
+		It("should count timed out requests as failures and eject it", func() {
+			// round robin starts with eu for the first and the third request
+			euServer.AppendHandlers(hanging, hanging)
+			usServer.AppendHandlers(found("/us/v1/accounts"), found("/us/v1/accounts"), found("/us/v1/accounts"))
+			errs := []error{}
+			for i := 0; i < 5; i++ {
+				_, err := accountClient.Fetch(accountID)
+				errs = append(errs, err)
+			}
+			Ω(errs[0]).Should(HaveOccurred())
+			Ω(errs[1]).ShouldNot(HaveOccurred())
+			Ω(errs[2]).Should(HaveOccurred())
+			Ω(errs[3:]).Should(Equal([]error{nil, nil}))
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(2))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(3))
+		})
+
+		It("should not count requests cancelled by the caller", func() {
+			euServer.AppendHandlers(hanging, hanging, found("/v1/accounts"))
+			usServer.AppendHandlers(found("/us/v1/accounts"), found("/us/v1/accounts"))
+			for i := 0; i < 5; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				_, err := accountClient.FetchWithContext(ctx, accountID)
+				cancel()
+				if i%2 == 0 && i < 4 {
+					Ω(err).Should(HaveOccurred())
+				} else {
+					Ω(err).ShouldNot(HaveOccurred())
+				}
+			}
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(3))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("with least latency load balancing", func() {
+
+		It("should prefer the faster endpoint", func() {
+			endpointsConfig.LoadBalancing = apiclient.LeastLatency
+			accountClient = apiclient.NewAccountClient(&apiclient.AccountClientConfig{Timeout: time.Second, Endpoints: endpointsConfig})
+			slow := func(handler http.HandlerFunc) http.HandlerFunc {
+				return func(w http.ResponseWriter, req *http.Request) {
+					time.Sleep(50 * time.Millisecond)
+					handler(w, req)
+				}
+			}
+			euServer.AppendHandlers(slow(found("/v1/accounts")))
+			usServer.AppendHandlers(found("/us/v1/accounts"), found("/us/v1/accounts"), found("/us/v1/accounts"))
+			for i := 0; i < 4; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(euServer.ReceivedRequests()).Should(HaveLen(1))
+			Ω(usServer.ReceivedRequests()).Should(HaveLen(3))
+		})
+	})
+
+	Context("when endpoint URL is wrong", func() {
+
+		It("should return ErrWrongConfig", func() {
+			endpointsConfig.URLs = []string{"not an url"}
+			accountClient = apiclient.NewAccountClient(&apiclient.AccountClientConfig{Endpoints: endpointsConfig})
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrWrongConfig))
+		})
+	})
+})
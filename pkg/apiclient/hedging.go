@@ -0,0 +1,108 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// HedgingConfig configures hedged reads: when `Fetch` or a `List` page is not answered within `Delay`,
+// the same request is sent again, the first response is used and the other requests are cancelled.
+//
+// Hedged requests wait for the rate limiter and concurrency slots like all other requests,
+// and they are counted in `Observation.Attempt` and `Observation.Hedged`.
+type HedgingConfig struct {
+	Delay     time.Duration // Wait for a response before the next request is sent, e.g. p95 latency, 100ms when not set
+	MaxHedged int           // Requests sent in addition to the first one, 1 when not set
+}
+
+func (c *HedgingConfig) delay() time.Duration {
+	if c.Delay <= 0 {
+		return 100 * time.Millisecond
+	}
+	return c.Delay
+}
+
+func (c *HedgingConfig) maxHedged() int {
+	if c.MaxHedged <= 0 {
+		return 1
+	}
+	return c.MaxHedged
+}
+
+// hedgedOperations are operations whose requests are hedged: short reads, which have the same effect when sent many times.
+// Other reads are not hedged, they stream large responses, e.g. `Export` and `JobResult`, or they poll, e.g. `WaitJob`.
+var hedgedOperations = map[string]bool{"Fetch": true, "List": true}
+
+// hedgingTransport sends requests of `hedgedOperations` again when they are not answered in time
+type hedgingTransport struct {
+	base   http.RoundTripper
+	config *HedgingConfig
+}
+
+type hedgedResult struct {
+	attempt int
+	resp    *http.Response
+	err     error
+}
+
+func (t *hedgingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op, ok := req.Context().Value(operationKey{}).(*operation)
+	if !ok || !hedgedOperations[op.name] || req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+	results := make(chan hedgedResult, t.config.maxHedged()+1)
+	var cancels []context.CancelFunc
+	send := func() {
+		ctx, cancel := context.WithCancel(req.Context())
+		attempt := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := t.base.RoundTrip(req.Clone(ctx))
+			results <- hedgedResult{attempt: attempt, resp: resp, err: err}
+		}()
+	}
+	send()
+	inFlight := 1
+	timer := time.NewTimer(t.config.delay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) <= t.config.maxHedged() {
+				op.addHedged()
+				send()
+				inFlight++
+				timer.Reset(t.config.delay())
+			}
+		case result := <-results:
+			inFlight--
+			if result.err != nil {
+				cancels[result.attempt]()
+				// a failed request is not an answer while other requests can still succeed
+				if inFlight > 0 {
+					continue
+				}
+				return nil, result.err
+			}
+			for attempt, cancel := range cancels {
+				if attempt != result.attempt {
+					cancel()
+				}
+			}
+			// the request context is cancelled when the body is closed, so the body can be read until then
+			result.resp.Body = &releasingBody{ReadCloser: result.resp.Body, release: cancels[result.attempt]}
+			go discardHedged(results, inFlight)
+			return result.resp, nil
+		}
+	}
+}
+
+// discardHedged waits for cancelled requests which lost the race, and discards responses which arrived anyway
+func discardHedged(results <-chan hedgedResult, inFlight int) {
+	for ; inFlight > 0; inFlight-- {
+		if result := <-results; result.err == nil {
+			discardResponse(result.resp)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package apiclient_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with hedging", func() {
+	type accountResponse struct {
+		Data apiclient.AccountResource `json:"data"`
+	}
+
+	var (
+		server              *ghttp.Server
+		accountClientConfig apiclient.AccountClientConfig
+		accountClient       *apiclient.AccountClient
+		accountID           string
+		observations        chan apiclient.Observation
+		found               http.HandlerFunc
+		slow                func(handler http.HandlerFunc) http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/accounts"
+		observations = make(chan apiclient.Observation, 1)
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: 2 * time.Second,
+			Hedging: &apiclient.HedgingConfig{Delay: 20 * time.Millisecond},
+			Observer: apiclient.ObserverFunc(func(observation apiclient.Observation) {
+				observations <- observation
+			}),
+		}
+		accountClient = apiclient.NewAccountClient(&accountClientConfig)
+		accountID = libtest.GenerateID()
+		found = ghttp.RespondWithJSONEncoded(http.StatusOK, accountResponse{Data: apiclient.AccountResource{ID: accountID}})
+		slow = func(handler http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, req *http.Request) {
+				time.Sleep(300 * time.Millisecond)
+				handler(w, req)
+			}
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when Account API answers in time", func() {
+
+		It("should send one request", func() {
+			server.AppendHandlers(found)
+			account, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(account.ID).Should(Equal(accountID))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			observation := <-observations
+			Ω(observation.Attempt).Should(Equal(1))
+			Ω(observation.Hedged).Should(Equal(0))
+		})
+	})
+
+	Context("when Account API answers slowly", func() {
+
+		It("should use the answer of the hedged request", func() {
+			server.AppendHandlers(slow(found), found)
+			start := time.Now()
+			account, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(account.ID).Should(Equal(accountID))
+			Ω(time.Since(start)).Should(BeNumerically("<", 300*time.Millisecond))
+			observation := <-observations
+			Ω(observation.Attempt).Should(Equal(2))
+			Ω(observation.Hedged).Should(Equal(1))
+			Ω(observation.StatusCode).Should(Equal(http.StatusOK))
+		})
+
+		It("should hedge list pages", func() {
+			server.AppendHandlers(slow(found), ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"data": []apiclient.AccountResource{{ID: accountID}},
+			}))
+			accounts := accountClient.List(apiclient.FirstPage)
+			Ω(accounts.Next()).Should(BeTrue())
+			data, err := accounts.Data()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(data).Should(HaveLen(1))
+			Ω((<-observations).Hedged).Should(Equal(1))
+		})
+
+		It("should not hedge creates", func() {
+			server.AppendHandlers(slow(ghttp.RespondWith(http.StatusInternalServerError, nil)))
+			_, err := accountClient.Create(accountID, libtest.GenerateOrganisationID(), libtest.GenerateAccountAttributes())
+			Ω(err).Should(MatchError(apiclient.ErrInternal))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			Ω((<-observations).Hedged).Should(Equal(0))
+		})
+
+		It("should not hedge exports", func() {
+			server.AppendHandlers(slow(ghttp.RespondWith(http.StatusOK, "{}\n", http.Header{"Content-Type": []string{"application/x-ndjson"}})))
+			output := &bytes.Buffer{}
+			err := accountClient.Export(context.Background(), apiclient.AccountListFilter{}, apiclient.ExportNDJSON, output)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			Ω((<-observations).Hedged).Should(Equal(0))
+		})
+
+		It("should not hedge job polling", func() {
+			server.AppendHandlers(slow(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"data": apiclient.Job{ID: accountID, Status: apiclient.JobSucceeded},
+			})))
+			job, err := accountClient.WaitJob(context.Background(), accountID, 10*time.Millisecond)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(job.Status).Should(Equal(apiclient.JobSucceeded))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			Ω((<-observations).Hedged).Should(Equal(0))
+		})
+
+		It("should wait for a free concurrency slot", func() {
+			accountClientConfig.RateLimit = &apiclient.RateLimitConfig{MaxConcurrent: 1}
+			accountClient = apiclient.NewAccountClient(&accountClientConfig)
+			server.AppendHandlers(slow(found))
+			account, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(account.ID).Should(Equal(accountID))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			observation := <-observations
+			Ω(observation.Attempt).Should(Equal(1))
+			Ω(observation.Hedged).Should(Equal(1))
+		})
+	})
+})
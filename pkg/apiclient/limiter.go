@@ -0,0 +1,120 @@
+package apiclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig limits requests sent by one `AccountClient`, shared by all its operations.
+// Requests over the limits wait, or fail when the operation context is cancelled while waiting.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 // Average number of requests sent every second, not limited when not set
+	Burst             int     // Number of requests which can be sent at once after a period without requests, 1 when not set
+	MaxConcurrent     int     // Maximum number of requests waiting for a response at the same time, not limited when not set
+}
+
+// limitingTransport waits for a token of the rate limiter and for a free concurrency slot before the request is sent
+type limitingTransport struct {
+	base   http.RoundTripper
+	bucket *tokenBucket  // nil when requests per second are not limited
+	slots  chan struct{} // nil when concurrent requests are not limited
+}
+
+func newLimitingTransport(base http.RoundTripper, config *RateLimitConfig) *limitingTransport {
+	t := &limitingTransport{base: base}
+	if config.RequestsPerSecond > 0 {
+		burst := config.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		t.bucket = &tokenBucket{
+			rate:    config.RequestsPerSecond,
+			burst:   float64(burst),
+			tokens:  float64(burst),
+			updated: time.Now(),
+		}
+	}
+	if config.MaxConcurrent > 0 {
+		t.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	return t
+}
+
+func (t *limitingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.bucket != nil {
+		if err := t.bucket.wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	if t.slots == nil {
+		return t.base.RoundTrip(req)
+	}
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+	// the request is in flight until its response is read
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { <-t.slots }}
+	return resp, nil
+}
+
+// releasingBody calls `release` once, when the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// tokenBucket is refilled with `rate` tokens every second up to `burst` tokens, every request takes one token
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64 // negative when requests are waiting for tokens
+	updated time.Time
+}
+
+// wait takes a token, waiting until it is available. Tokens are reserved in order of calls,
+// and a token of a call cancelled while waiting is returned to the bucket.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.updated).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.updated = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,114 @@
+package apiclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with rate limit", func() {
+	var (
+		server              *ghttp.Server
+		accountClientConfig apiclient.AccountClientConfig
+		accountID           string
+		notFound            http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/accounts"
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+		}
+		accountID = libtest.GenerateID()
+		notFound = ghttp.RespondWith(http.StatusNotFound, nil)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	fetchConcurrently := func(client *apiclient.AccountClient, n int) {
+		wg := sync.WaitGroup{}
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := client.Fetch(accountID)
+				Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			}()
+		}
+		wg.Wait()
+	}
+
+	Context("when requests per second are limited", func() {
+
+		It("should send requests over the burst after waiting", func() {
+			accountClientConfig.RateLimit = &apiclient.RateLimitConfig{RequestsPerSecond: 20, Burst: 2}
+			accountClient := apiclient.NewAccountClient(&accountClientConfig)
+			server.AllowUnhandledRequests = true
+			server.UnhandledRequestStatusCode = http.StatusNotFound
+
+			start := time.Now()
+			fetchConcurrently(accountClient, 6)
+			// 2 requests are sent at once, the other 4 wait 50ms each
+			Ω(time.Since(start)).Should(BeNumerically(">=", 180*time.Millisecond))
+			Ω(server.ReceivedRequests()).Should(HaveLen(6))
+		})
+
+		It("should stop waiting when the context is cancelled", func() {
+			accountClientConfig.RateLimit = &apiclient.RateLimitConfig{RequestsPerSecond: 0.1}
+			accountClient := apiclient.NewAccountClient(&accountClientConfig)
+			server.AppendHandlers(notFound)
+
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err = accountClient.FetchWithContext(ctx, accountID)
+			Ω(err).Should(HaveOccurred())
+			Ω(time.Since(start)).Should(BeNumerically("<", 500*time.Millisecond))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when concurrent requests are limited", func() {
+
+		It("should not send more requests at the same time", func() {
+			accountClientConfig.RateLimit = &apiclient.RateLimitConfig{MaxConcurrent: 2}
+			accountClient := apiclient.NewAccountClient(&accountClientConfig)
+			mu := sync.Mutex{}
+			inFlight, maxInFlight := 0, 0
+			server.RouteToHandler("GET", "/v1/accounts/"+accountID, func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				w.WriteHeader(http.StatusNotFound)
+			})
+
+			fetchConcurrently(accountClient, 8)
+			Ω(server.ReceivedRequests()).Should(HaveLen(8))
+			Ω(maxInFlight).Should(Equal(2))
+		})
+	})
+})
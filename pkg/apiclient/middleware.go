@@ -0,0 +1,68 @@
+package apiclient
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Middleware wraps the transport used to send requests to Account API,
+// e.g. to add headers, log requests or collect metrics.
+//
+// Middleware must not modify the request it receives, it should send a copy instead (see `http.Request.Clone`).
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to allow the use of ordinary functions as `http.RoundTripper`
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req)
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// chain wraps the transport with middleware, the first middleware sees the request first
+func chain(transport http.RoundTripper, middleware ...Middleware) http.RoundTripper {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		transport = middleware[i](transport)
+	}
+	return transport
+}
+
+// UserAgent sets `User-Agent` header of every request
+func UserAgent(userAgent string) Middleware {
+	return Headers(http.Header{"User-Agent": []string{userAgent}})
+}
+
+// RequestIDHeader is a header used to correlate requests between client and Account API
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID sets a new random `X-Request-ID` header on every request that does not have one yet
+func RequestID() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(RequestIDHeader) != "" {
+				return next.RoundTrip(req)
+			}
+			requestID, err := uuid.NewRandom()
+			if err != nil {
+				return nil, err
+			}
+			withID := req.Clone(req.Context())
+			withID.Header.Set(RequestIDHeader, requestID.String())
+			return next.RoundTrip(withID)
+		})
+	}
+}
+
+// Headers sets headers on every request, existing values of the same headers are replaced
+func Headers(headers http.Header) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			withHeaders := req.Clone(req.Context())
+			for name, values := range headers {
+				withHeaders.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+			return next.RoundTrip(withHeaders)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package apiclient_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with middleware", func() {
+	var (
+		server        *ghttp.Server
+		fetchPath     string
+		clientConfig  apiclient.AccountClientConfig
+		accountClient *apiclient.AccountClient
+
+		accountID    string
+		responseData interface{}
+		calls        []string
+
+		// recordCall is a middleware that remembers the order it was called in
+		recordCall = func(name string) apiclient.Middleware {
+			return func(next http.RoundTripper) http.RoundTripper {
+				return apiclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					calls = append(calls, name+" before")
+					resp, err := next.RoundTrip(req)
+					calls = append(calls, name+" after")
+					return resp, err
+				})
+			}
+		}
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		fetchPath = "/v1/accounts"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = fetchPath
+		clientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+		}
+
+		accountID = libtest.GenerateID()
+		responseData = map[string]interface{}{
+			"data": apiclient.AccountResource{
+				Type:           "accounts",
+				ID:             accountID,
+				OrganisationID: libtest.GenerateOrganisationID(),
+				Attributes:     libtest.GenerateAccountAttributes(),
+			},
+		}
+		calls = nil
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when many middleware are configured", func() {
+
+		BeforeEach(func() {
+			clientConfig.Middleware = []apiclient.Middleware{recordCall("first"), recordCall("second")}
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, responseData))
+		})
+
+		It("should call them in order", func() {
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(calls).Should(Equal([]string{"first before", "second before", "second after", "first after"}))
+		})
+	})
+
+	Context("when built-in middleware are configured", func() {
+
+		BeforeEach(func() {
+			clientConfig.Middleware = []apiclient.Middleware{
+				apiclient.UserAgent("importer/1.0"),
+				apiclient.RequestID(),
+				apiclient.Headers(http.Header{"X-Team": []string{"onboarding"}}),
+			}
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyHeaderKV("User-Agent", "importer/1.0"),
+					ghttp.VerifyHeaderKV("X-Team", "onboarding"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, responseData),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyHeaderKV("User-Agent", "importer/1.0"),
+					ghttp.VerifyHeaderKV("X-Team", "onboarding"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, responseData),
+				),
+			)
+		})
+
+		It("should set headers on every request", func() {
+			for i := 0; i < 2; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+			firstID := server.ReceivedRequests()[0].Header.Get(apiclient.RequestIDHeader)
+			secondID := server.ReceivedRequests()[1].Header.Get(apiclient.RequestIDHeader)
+			Ω(firstID).Should(HaveLen(36))
+			Ω(secondID).Should(HaveLen(36))
+			Ω(firstID).ShouldNot(Equal(secondID))
+		})
+	})
+
+	Context("when request ID is already set by another middleware", func() {
+
+		BeforeEach(func() {
+			clientConfig.Middleware = []apiclient.Middleware{
+				apiclient.Headers(http.Header{apiclient.RequestIDHeader: []string{"batch-42"}}),
+				apiclient.RequestID(),
+			}
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyHeaderKV(apiclient.RequestIDHeader, "batch-42"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, responseData),
+			))
+		})
+
+		It("should keep it", func() {
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	Context("when base transport is configured", func() {
+		var (
+			transportRequests []*http.Request
+		)
+
+		BeforeEach(func() {
+			transportRequests = nil
+			clientConfig.Transport = apiclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				transportRequests = append(transportRequests, req)
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+					Request:    req,
+				}, nil
+			})
+			clientConfig.Middleware = []apiclient.Middleware{apiclient.UserAgent("importer/1.0")}
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+		})
+
+		It("should send requests through it", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			Ω(accountData).Should(BeNil())
+			Ω(server.ReceivedRequests()).Should(HaveLen(0))
+			Ω(transportRequests).Should(HaveLen(1))
+			Ω(transportRequests[0].Header.Get("User-Agent")).Should(Equal("importer/1.0"))
+		})
+	})
+})
@@ -0,0 +1,192 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTokenExpiryDelta is how long before its expiry a cached token is considered expired
+const defaultTokenExpiryDelta = 10 * time.Second
+
+// OAuth2Config is a configuration of the OAuth2 client-credentials grant used to authorize requests to Account API
+type OAuth2Config struct {
+	TokenURL     string        // Token endpoint url address
+	ClientID     string        // Client identifier issued by the authorization server
+	ClientSecret string        // Client secret issued by the authorization server
+	Scopes       []string      // Scopes requested with every token, optional
+	ExpiryDelta  time.Duration // How long before its expiry the token is refreshed, 10 seconds when not set
+}
+
+// Token is an access token issued by the token endpoint
+type Token struct {
+	AccessToken string
+	TokenType   string
+	Expiry      time.Time // zero value means the token never expires
+}
+
+// valid reports whether the token can still be used `delta` before its expiry
+func (t *Token) valid(now time.Time, delta time.Duration) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || now.Add(delta).Before(t.Expiry)
+}
+
+// clientCredentialsTokenSource runs the client-credentials grant and caches the token until shortly before its expiry.
+//
+// Only one goroutine at the time requests a new token, others wait for it and reuse the result.
+type clientCredentialsTokenSource struct {
+	config     *OAuth2Config
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token *Token
+}
+
+func newClientCredentialsTokenSource(config *OAuth2Config, httpClient *http.Client) *clientCredentialsTokenSource {
+	return &clientCredentialsTokenSource{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+// Token returns cached token, or requests a new one when there is no valid token cached
+func (s *clientCredentialsTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delta := s.config.ExpiryDelta
+	if delta <= 0 {
+		delta = defaultTokenExpiryDelta
+	}
+	if s.token.valid(time.Now(), delta) {
+		return s.token, nil
+	}
+	token, err := s.requestToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// invalidate removes the token from the cache, unless it has been already replaced by another goroutine
+func (s *clientCredentialsTokenSource) invalidate(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token = nil
+	}
+}
+
+func (s *clientCredentialsTokenSource) requestToken(ctx context.Context) (*Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request token: wrong token url %v %w", err, ErrWrongConfig)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+
+	requestedAt := time.Now()
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request token: response error %v %w", err, ErrConnection)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to request token: response status code %v %w", resp.StatusCode, ErrUnauthorized)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request token: response body error %v %w", err, ErrConnection)
+	}
+	var jsonResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.Unmarshal(body, &jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request token: json parse issue %v %w", err, ErrUnauthorized)
+	}
+	if jsonResponse.AccessToken == "" {
+		return nil, fmt.Errorf("Failed to request token: response without access_token %w", ErrUnauthorized)
+	}
+	token := &Token{
+		AccessToken: jsonResponse.AccessToken,
+		TokenType:   jsonResponse.TokenType,
+	}
+	if token.TokenType == "" {
+		token.TokenType = "Bearer"
+	}
+	if jsonResponse.ExpiresIn > 0 {
+		token.Expiry = requestedAt.Add(time.Duration(jsonResponse.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// oauth2Transport adds the access token to every request.
+//
+// When Account API responds with 401 the token is dropped from the cache and the request is retried once with a new token.
+type oauth2Transport struct {
+	base   http.RoundTripper
+	source *clientCredentialsTokenSource
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(authorizeRequest(req, token, req.Body))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// the request body has been already consumed and cannot be sent again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	t.source.invalidate(token)
+	token, err = t.source.Token(req.Context())
+	if err != nil {
+		discardResponse(resp)
+		return nil, err
+	}
+	var body io.ReadCloser
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			discardResponse(resp)
+			return nil, err
+		}
+	}
+	discardResponse(resp)
+	return t.base.RoundTrip(authorizeRequest(req, token, body))
+}
+
+// authorizeRequest returns a copy of the request with the Authorization header set, the original request is not modified
+func authorizeRequest(req *http.Request, token *Token, body io.ReadCloser) *http.Request {
+	authorized := req.Clone(req.Context())
+	authorized.Body = body
+	authorized.Header.Set("Authorization", token.TokenType+" "+token.AccessToken)
+	return authorized
+}
+
+// discardResponse reads the rest of the body, so the connection can be reused, and closes it
+func discardResponse(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,230 @@
+package apiclient_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with OAuth2 client-credentials", func() {
+	var (
+		// API server and token endpoint
+		server      *ghttp.Server
+		tokenServer *ghttp.Server
+		fetchPath   string
+		// API client
+		clientConfig  apiclient.AccountClientConfig
+		accountClient *apiclient.AccountClient
+
+		accountID    string
+		responseData interface{}
+		// token endpoint state
+		tokensIssued    int32
+		tokensExpiresIn int
+		tokenHandler    = func(rw http.ResponseWriter, r *http.Request) {
+			issued := atomic.AddInt32(&tokensIssued, 1)
+			fmt.Fprintf(rw, `{"access_token": "token-%v", "token_type": "Bearer", "expires_in": %v}`, issued, tokensExpiresIn)
+		}
+		apiHandler = func(token string, statusCode int) http.HandlerFunc {
+			return ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", path.Join(fetchPath, accountID)),
+				ghttp.VerifyHeaderKV("Authorization", "Bearer "+token),
+				ghttp.RespondWithJSONEncodedPtr(&statusCode, &responseData),
+			)
+		}
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		tokenServer = ghttp.NewServer()
+		fetchPath = "/v1/accounts"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = fetchPath
+		clientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+			OAuth2: &apiclient.OAuth2Config{
+				TokenURL:     tokenServer.URL() + "/oauth2/token",
+				ClientID:     "account-client",
+				ClientSecret: "secret",
+				Scopes:       []string{"accounts:read", "accounts:write"},
+			},
+		}
+		accountClient = apiclient.NewAccountClient(&clientConfig)
+
+		accountID = libtest.GenerateID()
+		responseData = map[string]interface{}{
+			"data": apiclient.AccountResource{
+				Type:           "accounts",
+				ID:             accountID,
+				OrganisationID: libtest.GenerateOrganisationID(),
+				Attributes:     libtest.GenerateAccountAttributes(),
+			},
+		}
+		atomic.StoreInt32(&tokensIssued, 0)
+		tokensExpiresIn = 3600
+		tokenServer.RouteToHandler("POST", "/oauth2/token", ghttp.CombineHandlers(
+			ghttp.VerifyBasicAuth("account-client", "secret"),
+			ghttp.VerifyContentType("application/x-www-form-urlencoded"),
+			ghttp.VerifyForm(url.Values{
+				"grant_type": []string{"client_credentials"},
+				"scope":      []string{"accounts:read accounts:write"},
+			}),
+			tokenHandler,
+		))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		tokenServer.Close()
+	})
+
+	Context("when token is valid", func() {
+
+		BeforeEach(func() {
+			server.AppendHandlers(apiHandler("token-1", http.StatusOK), apiHandler("token-1", http.StatusOK))
+		})
+
+		It("should request the token once and reuse it", func() {
+			for i := 0; i < 2; i++ {
+				accountData, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(accountData.ID).Should(Equal(accountID))
+			}
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+			Ω(tokenServer.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when token is about to expire", func() {
+
+		BeforeEach(func() {
+			// shorter than default expiry delta, so the token is never reused
+			tokensExpiresIn = 5
+			server.AppendHandlers(apiHandler("token-1", http.StatusOK), apiHandler("token-2", http.StatusOK))
+		})
+
+		It("should refresh the token before the next request", func() {
+			for i := 0; i < 2; i++ {
+				_, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+			Ω(tokenServer.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("when Server API rejects the token with 401", func() {
+
+		It("should retry once with a new token", func() {
+			server.AppendHandlers(apiHandler("token-1", http.StatusUnauthorized), apiHandler("token-2", http.StatusOK))
+
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(accountData.ID).Should(Equal(accountID))
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+			Ω(tokenServer.ReceivedRequests()).Should(HaveLen(2))
+		})
+
+		It("should return ErrUnauthorized when the new token is rejected too", func() {
+			server.AppendHandlers(apiHandler("token-1", http.StatusUnauthorized), apiHandler("token-2", http.StatusUnauthorized))
+
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrUnauthorized))
+			Ω(accountData).Should(BeNil())
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+			Ω(tokenServer.ReceivedRequests()).Should(HaveLen(2))
+		})
+
+		It("should resend the request body", func() {
+			accountAttributes := responseData.(map[string]interface{})["data"].(apiclient.AccountResource).Attributes
+			organisationID := responseData.(map[string]interface{})["data"].(apiclient.AccountResource).OrganisationID
+			createdStatusCode := http.StatusCreated
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyHeaderKV("Authorization", "Bearer token-1"),
+					ghttp.RespondWith(http.StatusUnauthorized, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyHeaderKV("Authorization", "Bearer token-2"),
+					ghttp.VerifyJSONRepresenting(map[string]interface{}{
+						"data": map[string]interface{}{
+							"type":            "accounts",
+							"id":              accountID,
+							"organisation_id": organisationID,
+							"attributes":      accountAttributes,
+						},
+					}),
+					ghttp.RespondWithJSONEncodedPtr(&createdStatusCode, &responseData),
+				),
+			)
+
+			accountData, err := accountClient.Create(accountID, organisationID, accountAttributes)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(accountData.ID).Should(Equal(accountID))
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("when token endpoint rejects client credentials", func() {
+
+		BeforeEach(func() {
+			tokenServer.RouteToHandler("POST", "/oauth2/token", ghttp.RespondWith(http.StatusUnauthorized, `{"error": "invalid_client"}`))
+		})
+
+		It("should return ErrUnauthorized without calling Server API", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrUnauthorized))
+			Ω(accountData).Should(BeNil())
+			Ω(server.ReceivedRequests()).Should(HaveLen(0))
+		})
+	})
+
+	Context("when token endpoint is down", func() {
+
+		BeforeEach(func() {
+			tokenServer.Close()
+		})
+
+		It("should return ErrConnection without calling Server API", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrConnection))
+			Ω(accountData).Should(BeNil())
+			Ω(server.ReceivedRequests()).Should(HaveLen(0))
+		})
+	})
+
+	Context("when many goroutines use the client at the same time", func() {
+
+		BeforeEach(func() {
+			server.RouteToHandler("GET", path.Join(fetchPath, accountID), apiHandler("token-1", http.StatusOK))
+		})
+
+		It("should request the token only once", func() {
+			wg := sync.WaitGroup{}
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := accountClient.Fetch(accountID)
+					Ω(err).ShouldNot(HaveOccurred())
+				}()
+			}
+			wg.Wait()
+			Ω(server.ReceivedRequests()).Should(HaveLen(20))
+			Ω(tokenServer.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+})
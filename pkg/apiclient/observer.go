@@ -0,0 +1,186 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Observer is notified when an operation finishes, e.g. to collect metrics of calls to Account API.
+// It is called synchronously, so it should not block.
+type Observer interface {
+	ObserveOperation(observation Observation)
+}
+
+// ObserverFunc is an adapter to allow the use of ordinary functions as `Observer`
+type ObserverFunc func(observation Observation)
+
+// ObserveOperation calls f(observation)
+func (f ObserverFunc) ObserveOperation(observation Observation) {
+	f(observation)
+}
+
+// Observation describes a finished operation, a `List` operation is observed separately for every page, and `CreateBatch` for every request
+type Observation struct {
+	Operation     string        // Create, CreateBatch, Fetch, Delete or List
+	Attempt       int           // number of requests sent to Account API, more than 1 when the request was retried or hedged
+	Hedged        int           // number of hedged requests sent because Account API did not answer in time, see `HedgingConfig`
+	StatusCode    int           // status code of the last response, 0 when there was no response
+	Duration      time.Duration // time of the whole operation, including retries
+	BytesSent     int64         // request bodies sent in all attempts
+	BytesReceived int64         // response bodies received in all attempts
+	ErrorClass    string        // name of the error returned by the operation, e.g. `ErrConnection`, empty when there was no error
+	Err           error         // error returned by the operation
+}
+
+// errorClasses are checked in order, the first one matching the error names its class
+var errorClasses = []struct {
+	err  error
+	name string
+}{
+	{ErrWrongConfig, "ErrWrongConfig"},
+	{ErrCircuitOpen, "ErrCircuitOpen"},
+	{ErrConnection, "ErrConnection"},
+	{ErrUnauthorized, "ErrUnauthorized"},
+	{ErrRateLimited, "ErrRateLimited"},
+	{ErrNoAccount, "ErrNoAccount"},
+	{ErrAccountExist, "ErrAccountExist"},
+	{ErrWrongVersion, "ErrWrongVersion"},
+	{ErrBatchFailed, "ErrBatchFailed"},
+	{ErrImportFailed, "ErrImportFailed"},
+	{ErrNoReport, "ErrNoReport"},
+	{ErrNoJob, "ErrNoJob"},
+	{ErrJobFailed, "ErrJobFailed"},
+	{ErrInternal, "ErrInternal"},
+}
+
+// errorClass returns name of the error returned by an operation, errors not wrapping any of the client errors are internal
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, class := range errorClasses {
+		if errors.Is(err, class.err) {
+			return class.name
+		}
+	}
+	return "ErrInternal"
+}
+
+type operationKey struct{}
+
+// operation collects details of requests sent on behalf of a single operation
+type operation struct {
+	client *AccountClient
+	name   string
+	span   trace.Span
+	start  time.Time
+
+	mu            sync.Mutex
+	attempts      int
+	hedged        int
+	statusCode    int
+	bytesSent     int64
+	bytesReceived int64
+}
+
+// startOperation starts tracing the operation, requests sent with the returned context are recorded in the operation
+func (client *AccountClient) startOperation(ctx context.Context, name string, attributes ...label.KeyValue) (context.Context, *operation) {
+	ctx, span := client.startSpan(ctx, name, attributes...)
+	op := &operation{
+		client: client,
+		name:   name,
+		span:   span,
+		start:  time.Now(),
+	}
+	return context.WithValue(ctx, operationKey{}, op), op
+}
+
+// end ends the operation span and notifies the observer
+func (op *operation) end(err error) {
+	endSpan(op.span, err)
+	if op.client.config.Observer == nil {
+		return
+	}
+	op.mu.Lock()
+	observation := Observation{
+		Operation:     op.name,
+		Attempt:       op.attempts,
+		Hedged:        op.hedged,
+		StatusCode:    op.statusCode,
+		Duration:      time.Since(op.start),
+		BytesSent:     op.bytesSent,
+		BytesReceived: op.bytesReceived,
+		ErrorClass:    errorClass(err),
+		Err:           err,
+	}
+	op.mu.Unlock()
+	op.client.config.Observer.ObserveOperation(observation)
+}
+
+func (op *operation) addAttempt(bytesSent int64) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.attempts++
+	op.statusCode = 0
+	if bytesSent > 0 {
+		op.bytesSent += bytesSent
+	}
+}
+
+func (op *operation) addHedged() {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.hedged++
+}
+
+func (op *operation) setStatusCode(statusCode int) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.statusCode = statusCode
+}
+
+func (op *operation) addBytesReceived(n int64) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.bytesReceived += n
+}
+
+// observingTransport records every request sent to Account API in the operation of the request context.
+// It wraps the base transport, so it sees every attempt after it passed through middleware.
+type observingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *observingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op, ok := req.Context().Value(operationKey{}).(*operation)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	op.addAttempt(req.ContentLength)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	op.setStatusCode(resp.StatusCode)
+	resp.Body = &countingBody{ReadCloser: resp.Body, op: op}
+	return resp, nil
+}
+
+// countingBody counts bytes of the response body read by the operation
+type countingBody struct {
+	io.ReadCloser
+	op *operation
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.op.addBytesReceived(int64(n))
+	return n, err
+}
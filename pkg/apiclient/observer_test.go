@@ -0,0 +1,130 @@
+package apiclient_test
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient/promobserver"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("The AccountClient with observer", func() {
+	var (
+		server        *ghttp.Server
+		clientConfig  apiclient.AccountClientConfig
+		accountClient *apiclient.AccountClient
+
+		accountID      string
+		organisationID string
+		attributes     *apiclient.AccountAttributes
+		responseData   interface{}
+		observations   []apiclient.Observation
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/accounts"
+		observations = nil
+		clientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+			Observer: apiclient.ObserverFunc(func(observation apiclient.Observation) {
+				observations = append(observations, observation)
+			}),
+		}
+		accountClient = apiclient.NewAccountClient(&clientConfig)
+
+		accountID = libtest.GenerateID()
+		organisationID = libtest.GenerateOrganisationID()
+		attributes = libtest.GenerateAccountAttributes()
+		responseData = map[string]interface{}{
+			"data": apiclient.AccountResource{
+				Type:           "accounts",
+				ID:             accountID,
+				OrganisationID: organisationID,
+				Attributes:     attributes,
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when operation succeeds", func() {
+
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusCreated, responseData))
+		})
+
+		It("should observe it", func() {
+			_, err := accountClient.Create(accountID, organisationID, attributes)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(observations).Should(HaveLen(1))
+			observation := observations[0]
+			Ω(observation.Operation).Should(Equal("Create"))
+			Ω(observation.Attempt).Should(Equal(1))
+			Ω(observation.StatusCode).Should(Equal(http.StatusCreated))
+			Ω(observation.Duration).Should(BeNumerically(">", 0))
+			Ω(observation.BytesSent).Should(BeNumerically("==", server.ReceivedRequests()[0].ContentLength))
+			Ω(observation.BytesReceived).Should(BeNumerically(">", 0))
+			Ω(observation.ErrorClass).Should(BeEmpty())
+			Ω(observation.Err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	Context("when Account API does not respond", func() {
+
+		BeforeEach(func() {
+			server.Close()
+		})
+
+		It("should observe the error class", func() {
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrConnection))
+
+			Ω(observations).Should(HaveLen(1))
+			Ω(observations[0].Operation).Should(Equal("Fetch"))
+			Ω(observations[0].Attempt).Should(Equal(1))
+			Ω(observations[0].StatusCode).Should(Equal(0))
+			Ω(observations[0].ErrorClass).Should(Equal("ErrConnection"))
+			Ω(observations[0].Err).Should(Equal(err))
+		})
+	})
+
+	Context("when Prometheus observer is configured", func() {
+		var (
+			observer *promobserver.Observer
+		)
+
+		BeforeEach(func() {
+			observer = promobserver.New(promobserver.Options{Namespace: "test"})
+			clientConfig.Observer = observer
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+			server.AppendHandlers(
+				ghttp.RespondWithJSONEncoded(http.StatusOK, responseData),
+				ghttp.RespondWith(http.StatusNotFound, nil),
+			)
+		})
+
+		It("should count operations by status code and error class", func() {
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+
+			Ω(testutil.CollectAndCount(observer, "test_account_client_operations_total")).Should(Equal(2))
+			Ω(testutil.CollectAndCount(observer, "test_account_client_requests_total")).Should(Equal(1))
+			Ω(testutil.CollectAndCount(observer, "test_account_client_operation_duration_seconds")).Should(Equal(1))
+		})
+	})
+})
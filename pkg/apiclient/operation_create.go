@@ -2,10 +2,13 @@ package apiclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+
+	"go.opentelemetry.io/otel/label"
 )
 
 // Create operation requests a new Account creation in underlying Accounts API.
@@ -13,11 +16,24 @@ import (
 // If successful then returns Account Information returned by Accounts API.
 //
 // Returns errors:
-//   - ErrWrongConfig when Server URL is malformed
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
 //   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
 //   - ErrAccountExist when account with requeted accountID already exists
 //   - ErrInternal when other, not handled issues appear
 func (client *AccountClient) Create(accountID string, organisationID string, accountAttributes *AccountAttributes) (*AccountResource, error) {
+	return client.CreateWithContext(context.Background(), accountID, organisationID, accountAttributes)
+}
+
+// CreateWithContext is like `Create`, but the request is sent with the context: it can be cancelled, and it is traced as a child of the context span.
+func (client *AccountClient) CreateWithContext(ctx context.Context, accountID string, organisationID string, accountAttributes *AccountAttributes) (*AccountResource, error) {
+	ctx, op := client.startOperation(ctx, "Create", label.String("account.id", accountID), label.String("account.organisation_id", organisationID))
+	account, err := client.create(ctx, accountID, organisationID, accountAttributes)
+	op.end(err)
+	return account, err
+}
+
+func (client *AccountClient) create(ctx context.Context, accountID string, organisationID string, accountAttributes *AccountAttributes) (*AccountResource, error) {
 	// get Server URL
 	createURL, err := client.config.getURL("", nil)
 	if err != nil {
@@ -34,10 +50,16 @@ func (client *AccountClient) Create(accountID string, organisationID string, acc
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create an account: failed to create request data %v %w", err, ErrInternal)
 	}
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, "POST", createURL, bytes.NewBuffer(strData))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create an account: unknow error %v %w", err, ErrInternal)
+	}
+	req.Header.Set("Content-Type", "application/json")
 	// SEND request
-	resp, err := client.httpClient.Post(createURL, "application/json", bytes.NewBuffer(strData))
+	resp, err := client.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create an account: response error %v %w", err, ErrConnection)
+		return nil, fmt.Errorf("Failed to create an account: %w", err)
 	}
 	defer resp.Body.Close()
 	// check Response Status Codes
@@ -0,0 +1,120 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel/label"
+)
+
+// BatchMode decides what happens to other accounts of a batch request when some of them cannot be created
+type BatchMode string
+
+const (
+	// BatchAtomic - accounts of a batch request are created only when all of them can be created
+	BatchAtomic BatchMode = "atomic"
+	// BatchPartial - all valid accounts of a batch request are created
+	BatchPartial BatchMode = "partial"
+)
+
+// CreateBatch operation requests creation of many Accounts in underlying Accounts API.
+// Accounts are sent in batch requests of `AccountClientConfig.BatchSize` accounts, one request at the time.
+//
+// It returns a result for every account sent, `AccountBatchResult.Index` is the position of the account in `accounts`.
+// Accounts which were not created have `AccountBatchResult.Error`, e.g. when they already exist.
+// With `BatchAtomic` mode every request is atomic, not the whole operation: when a request fails the next ones are not sent.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, TLS certificates cannot be loaded, or `BatchSize` is over the Accounts API limit
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrBatchFailed when accounts of an atomic request were not created, results say why
+//   - ErrInternal when other, not handled issues appear
+func (client *AccountClient) CreateBatch(accounts []NewAccount, mode BatchMode) ([]AccountBatchResult, error) {
+	return client.CreateBatchWithContext(context.Background(), accounts, mode)
+}
+
+// CreateBatchWithContext is like `CreateBatch`, but requests are sent with the context: they can be cancelled, and they are traced as children of the context span.
+func (client *AccountClient) CreateBatchWithContext(ctx context.Context, accounts []NewAccount, mode BatchMode) ([]AccountBatchResult, error) {
+	batchSize := client.config.batchSize()
+	results := make([]AccountBatchResult, 0, len(accounts))
+	for offset := 0; offset < len(accounts); offset += batchSize {
+		end := offset + batchSize
+		if end > len(accounts) {
+			end = len(accounts)
+		}
+		batchCtx, op := client.startOperation(ctx, "CreateBatch", label.Int("account.batch.offset", offset), label.Int("account.batch.size", end-offset))
+		batchResults, err := client.createBatch(batchCtx, accounts[offset:end], mode)
+		op.end(err)
+		for _, result := range batchResults {
+			result.Index += offset
+			results = append(results, result)
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (client *AccountClient) createBatch(ctx context.Context, accounts []NewAccount, mode BatchMode) ([]AccountBatchResult, error) {
+	// get Server URL
+	batchURL, err := client.config.getURL("batch", url.Values{"mode": []string{string(mode)}})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create accounts: wrong API url %v %w", err, ErrWrongConfig)
+	}
+	// prepare Request Data
+	data := CreateAccountBatchRequestData{Data: make([]NewAccount, len(accounts))}
+	for i, account := range accounts {
+		if account.Type == "" {
+			account.Type = "accounts"
+		}
+		data.Data[i] = account
+	}
+	strData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create accounts: failed to create request data %v %w", err, ErrInternal)
+	}
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, "POST", batchURL, bytes.NewBuffer(strData))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create accounts: unknow error %v %w", err, ErrInternal)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// SEND request
+	resp, err := client.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create accounts: %w", err)
+	}
+	defer resp.Body.Close()
+	// check Response Status Codes
+	if resp.StatusCode == http.StatusRequestEntityTooLarge { // 413
+		return nil, fmt.Errorf("Failed to create accounts: batch of %v accounts is too large %w", len(accounts), ErrWrongConfig)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusUnprocessableEntity {
+		return nil, fmt.Errorf("Failed to create accounts: response status code %v %w", resp.StatusCode, ErrInternal)
+	}
+	// parse Response Body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create accounts: response body error %v %w", err, ErrInternal)
+	}
+	var jsonResponse struct {
+		Data []AccountBatchResult `json:"data"`
+	}
+	if err = json.Unmarshal(body, &jsonResponse); err != nil {
+		return nil, fmt.Errorf("Failed to create accounts: response json parse issue %v %w", err, ErrInternal)
+	}
+	if len(jsonResponse.Data) != len(accounts) {
+		return nil, fmt.Errorf("Failed to create accounts: response has %v results for %v accounts %w", len(jsonResponse.Data), len(accounts), ErrInternal)
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity { // 422 - nothing created in atomic mode
+		return jsonResponse.Data, fmt.Errorf("Failed to create accounts: batch was not created %w", ErrBatchFailed)
+	}
+	return jsonResponse.Data, nil
+}
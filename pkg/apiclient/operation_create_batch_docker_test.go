@@ -0,0 +1,63 @@
+package apiclient_test
+
+import (
+	"net/http"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		accountClient *apiclient.AccountClient
+	)
+
+	BeforeEach(func() {
+		accountClient = apiclient.NewAccountClient(&DefaultTestConfig)
+	})
+
+	Describe("CreateBatch operation", func() {
+		var (
+			existing *libtest.DBAccount
+			accounts []apiclient.NewAccount
+		)
+
+		BeforeEach(func() {
+			existing = libtest.DBCreateAccounts(1)[0]
+			organisationID := libtest.GenerateOrganisationID()
+			accounts = []apiclient.NewAccount{
+				{ID: libtest.GenerateID(), OrganisationID: organisationID, Attributes: libtest.GenerateAccountAttributes()},
+				{ID: existing.ID.String(), OrganisationID: organisationID, Attributes: libtest.GenerateAccountAttributes()},
+			}
+		})
+
+		Context("when an account already exists in atomic mode", func() {
+
+			It("should not create any account", func() {
+				results, err := accountClient.CreateBatch(accounts, apiclient.BatchAtomic)
+				Ω(err).Should(MatchError(apiclient.ErrBatchFailed))
+				Ω(results[0].Status).Should(Equal(http.StatusFailedDependency))
+				Ω(results[1].Status).Should(Equal(http.StatusConflict))
+
+				_, err = accountClient.Fetch(accounts[0].ID)
+				Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			})
+		})
+
+		Context("when an account already exists in partial mode", func() {
+
+			It("should create other accounts", func() {
+				results, err := accountClient.CreateBatch(accounts, apiclient.BatchPartial)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(results[0].Status).Should(Equal(http.StatusCreated))
+				Ω(results[1].Status).Should(Equal(http.StatusConflict))
+
+				account, err := accountClient.Fetch(accounts[0].ID)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(account.Attributes.Country).Should(Equal(accounts[0].Attributes.Country))
+			})
+		})
+	})
+})
@@ -0,0 +1,155 @@
+package apiclient_test
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		server              *ghttp.Server
+		batchPath           string
+		accountClientConfig apiclient.AccountClientConfig
+		accountClient       *apiclient.AccountClient
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		batchPath = "/v1/accounts/batch"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/accounts"
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:       serverURL.String(),
+			Timeout:   time.Second,
+			BatchSize: 2,
+		}
+		accountClient = apiclient.NewAccountClient(&accountClientConfig)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("CreateBatch operation", func() {
+		type batchResponse struct {
+			Status string                         `json:"status"`
+			Data   []apiclient.AccountBatchResult `json:"data"`
+		}
+
+		var (
+			accounts []apiclient.NewAccount
+			created  func(accounts []apiclient.NewAccount, offset int) batchResponse
+		)
+
+		BeforeEach(func() {
+			organisationID := libtest.GenerateOrganisationID()
+			accounts = nil
+			for i := 0; i < 5; i++ {
+				accounts = append(accounts, apiclient.NewAccount{
+					ID:             libtest.GenerateID(),
+					OrganisationID: organisationID,
+					Attributes:     libtest.GenerateAccountAttributes(),
+				})
+			}
+			created = func(accounts []apiclient.NewAccount, offset int) batchResponse {
+				response := batchResponse{Status: "success"}
+				for i, account := range accounts[offset:] {
+					response.Data = append(response.Data, apiclient.AccountBatchResult{
+						Index:  i,
+						ID:     account.ID,
+						Status: http.StatusCreated,
+						Data:   &apiclient.AccountResource{Type: "account", ID: account.ID, OrganisationID: account.OrganisationID, Attributes: account.Attributes},
+					})
+				}
+				return response
+			}
+		})
+
+		Context("when there are more accounts than the batch size", func() {
+
+			It("should send them in many requests", func() {
+				for offset := 0; offset < len(accounts); offset += 2 {
+					end := offset + 2
+					if end > len(accounts) {
+						end = len(accounts)
+					}
+					request := apiclient.CreateAccountBatchRequestData{Data: append([]apiclient.NewAccount(nil), accounts[offset:end]...)}
+					for i := range request.Data {
+						request.Data[i].Type = "accounts"
+					}
+					server.AppendHandlers(ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", batchPath, "mode=atomic"),
+						ghttp.VerifyJSONRepresenting(request),
+						ghttp.RespondWithJSONEncoded(http.StatusCreated, created(accounts[:end], offset)),
+					))
+				}
+
+				results, err := accountClient.CreateBatch(accounts, apiclient.BatchAtomic)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(server.ReceivedRequests()).Should(HaveLen(3))
+				Ω(results).Should(HaveLen(5))
+				for i, result := range results {
+					Ω(result.Index).Should(Equal(i))
+					Ω(result.ID).Should(Equal(accounts[i].ID))
+					Ω(result.Status).Should(Equal(http.StatusCreated))
+					Ω(result.Data.ID).Should(Equal(accounts[i].ID))
+				}
+			})
+		})
+
+		Context("when some accounts cannot be created in partial mode", func() {
+
+			It("should return their errors", func() {
+				response := created(accounts[:2], 0)
+				response.Status = "partial"
+				response.Data[1] = apiclient.AccountBatchResult{Index: 1, ID: accounts[1].ID, Status: http.StatusConflict, Error: &apiclient.AccountBatchError{Code: "account_exists", Message: "Account already exists"}}
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", batchPath, "mode=partial"),
+					ghttp.RespondWithJSONEncoded(http.StatusMultiStatus, response),
+				))
+
+				results, err := accountClient.CreateBatch(accounts[:2], apiclient.BatchPartial)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(results[0].Error).Should(BeNil())
+				Ω(results[1].Status).Should(Equal(http.StatusConflict))
+				Ω(results[1].Error.Code).Should(Equal("account_exists"))
+			})
+		})
+
+		Context("when an atomic request fails", func() {
+
+			It("should return ErrBatchFailed and not send next requests", func() {
+				response := batchResponse{Status: "error", Data: []apiclient.AccountBatchResult{
+					{Index: 0, ID: accounts[0].ID, Status: http.StatusFailedDependency, Error: &apiclient.AccountBatchError{Code: "batch_failed"}},
+					{Index: 1, ID: accounts[1].ID, Status: http.StatusBadRequest, Error: &apiclient.AccountBatchError{Code: "invalid_account"}},
+				}}
+				server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusUnprocessableEntity, response))
+
+				results, err := accountClient.CreateBatch(accounts, apiclient.BatchAtomic)
+				Ω(err).Should(MatchError(apiclient.ErrBatchFailed))
+				Ω(results).Should(HaveLen(2))
+				Ω(results[1].Error.Code).Should(Equal("invalid_account"))
+				Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			})
+		})
+
+		Context("when the batch size is over the Accounts API limit", func() {
+
+			It("should return ErrWrongConfig", func() {
+				server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusRequestEntityTooLarge, map[string]string{"status": "error", "code": "batch_too_large"}))
+
+				results, err := accountClient.CreateBatch(accounts, apiclient.BatchAtomic)
+				Ω(err).Should(MatchError(apiclient.ErrWrongConfig))
+				Ω(results).Should(BeEmpty())
+			})
+		})
+	})
+})
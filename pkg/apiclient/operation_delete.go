@@ -1,10 +1,13 @@
 package apiclient
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+
+	"go.opentelemetry.io/otel/label"
 )
 
 // Delete operation requests Account deletion in underlying Accounts API
@@ -14,11 +17,24 @@ import (
 // Returns `false` with `error` when problems occured
 //
 // Errors:
-//   - ErrWrongConfig when Server URL is malformed
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
 //   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
 //   - ErrWrongVersion when Account exists, but Accounts API did not delete it, because requested version of the Account was different
 //   - ErrInternal when other, not handled issues appear
 func (client *AccountClient) Delete(accountID string, version int) (bool, error) {
+	return client.DeleteWithContext(context.Background(), accountID, version)
+}
+
+// DeleteWithContext is like `Delete`, but the request is sent with the context: it can be cancelled, and it is traced as a child of the context span.
+func (client *AccountClient) DeleteWithContext(ctx context.Context, accountID string, version int) (bool, error) {
+	ctx, op := client.startOperation(ctx, "Delete", label.String("account.id", accountID), label.Int("account.version", version))
+	deleted, err := client.delete(ctx, accountID, version)
+	op.end(err)
+	return deleted, err
+}
+
+func (client *AccountClient) delete(ctx context.Context, accountID string, version int) (bool, error) {
 	// get Server URL (with Account id and version)
 	query := url.Values{}
 	query.Add("version", strconv.Itoa(version))
@@ -27,14 +43,14 @@ func (client *AccountClient) Delete(accountID string, version int) (bool, error)
 		return false, fmt.Errorf("Failed to delete account: wrong API url %v %w", err, ErrWrongConfig)
 	}
 	// prepare Request
-	req, err := http.NewRequest("DELETE", deleteURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", deleteURL, nil)
 	if err != nil {
 		return false, fmt.Errorf("Failed to delete account: unknow error %v %w", err, ErrInternal)
 	}
 	// SEND the Request
-	resp, err := client.httpClient.Do(req)
+	resp, err := client.do(req)
 	if err != nil {
-		return false, fmt.Errorf("Failed to delete account: response error %v %w", err, ErrConnection)
+		return false, fmt.Errorf("Failed to delete account: %w", err)
 	}
 	defer resp.Body.Close()
 	// check Response Status Codes
@@ -0,0 +1,98 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/label"
+)
+
+// ExportFormat is the format of exported accounts
+type ExportFormat string
+
+const (
+	// ExportNDJSON - one `AccountResource` JSON per line
+	ExportNDJSON ExportFormat = "ndjson"
+	// ExportCSV - CSV with a header line, nested attributes are flattened, e.g. `name[0]` or `private_identification.city`
+	ExportCSV ExportFormat = "csv"
+)
+
+// Export operation streams all Accounts matching the filter to `w`, in one request read with constant memory by the client and Accounts API.
+// It is an alternative to `List` and `FetchAll` for large exports. Personal data is exported only when the client has `pii:read` scope.
+//
+// The request is not limited by `AccountClientConfig.Timeout`: use the context to limit it.
+// When the export fails after some accounts were written, `w` has incomplete data and an error is returned.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrInternal when other, not handled issues appear, e.g. Accounts API failed during the export, or writing to `w` failed
+func (client *AccountClient) Export(ctx context.Context, filter AccountListFilter, format ExportFormat, w io.Writer) error {
+	attributes := append(FilterAttributes(filter), label.String("account.export.format", string(format)))
+	ctx, op := client.startOperation(ctx, "Export", attributes...)
+	err := client.export(ctx, filter, format, w)
+	op.end(err)
+	return err
+}
+
+func (client *AccountClient) export(ctx context.Context, filter AccountListFilter, format ExportFormat, w io.Writer) error {
+	if format == "" {
+		format = ExportNDJSON
+	}
+	// get Server URL
+	query := url.Values{"format": []string{string(format)}}
+	for name, filterValues := range filter.QueryValues() {
+		query.Add("filter["+name+"]", strings.Join(filterValues, ","))
+	}
+	exportURL, err := client.config.getURL("export", query)
+	if err != nil {
+		return fmt.Errorf("Failed to export accounts: wrong API url %v %w", err, ErrWrongConfig)
+	}
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, "GET", exportURL, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to export accounts: unknow error %v %w", err, ErrInternal)
+	}
+	// SEND request
+	resp, err := client.stream(req)
+	if err != nil {
+		return fmt.Errorf("Failed to export accounts: %w", err)
+	}
+	defer resp.Body.Close()
+	// check Response Status Codes
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to export accounts: response status code %v %w", resp.StatusCode, ErrInternal)
+	}
+	// copy Response Body
+	output := &exportOutput{w: w}
+	if _, err = io.Copy(output, resp.Body); err != nil {
+		if output.err != nil {
+			return fmt.Errorf("Failed to export accounts: writing accounts failed %v %w", output.err, ErrInternal)
+		}
+		return fmt.Errorf("Failed to export accounts: response body error %v %w", err, ErrConnection)
+	}
+	// the trailer is set when Accounts API failed after it sent the status code
+	if exportErr := resp.Trailer.Get("X-Export-Error"); exportErr != "" {
+		return fmt.Errorf("Failed to export accounts: %v %w", exportErr, ErrInternal)
+	}
+	return nil
+}
+
+// exportOutput remembers errors of the writer, so they are not reported as connection errors
+type exportOutput struct {
+	w   io.Writer
+	err error
+}
+
+func (o *exportOutput) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
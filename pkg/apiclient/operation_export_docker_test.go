@@ -0,0 +1,88 @@
+package apiclient_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		accountClient *apiclient.AccountClient
+	)
+
+	BeforeEach(func() {
+		accountClient = apiclient.NewAccountClient(&DefaultTestConfig)
+	})
+
+	Describe("Export operation", func() {
+		var (
+			account *libtest.DBAccount
+			filter  apiclient.AccountListFilter
+		)
+
+		BeforeEach(func() {
+			account = libtest.DBCreateAccounts(1)[0]
+			filter = apiclient.AccountListFilter{Country: []string{account.Record.Country}}
+		})
+
+		Context("in NDJSON format", func() {
+
+			It("should export accounts matching the filter", func() {
+				output := &bytes.Buffer{}
+				Ω(accountClient.Export(context.Background(), filter, apiclient.ExportNDJSON, output)).Should(Succeed())
+
+				var ids []string
+				scanner := bufio.NewScanner(output)
+				for scanner.Scan() {
+					exported := apiclient.AccountResource{}
+					Ω(json.Unmarshal(scanner.Bytes(), &exported)).Should(Succeed())
+					ids = append(ids, exported.ID)
+				}
+				Ω(ids).Should(ContainElement(account.ID.String()))
+			})
+		})
+
+		Context("in CSV format", func() {
+
+			It("should flatten attributes", func() {
+				output := &bytes.Buffer{}
+				Ω(accountClient.Export(context.Background(), filter, apiclient.ExportCSV, output)).Should(Succeed())
+
+				records, err := csv.NewReader(output).ReadAll()
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(len(records)).Should(BeNumerically(">=", 2))
+				Ω(records[0]).Should(ContainElement("name[0]"))
+				Ω(records[0]).ShouldNot(ContainElement("private_identification.identification"))
+				columns := map[string]string{}
+				for _, record := range records[1:] {
+					if record[0] == account.ID.String() {
+						for i, name := range records[0] {
+							columns[name] = record[i]
+						}
+					}
+				}
+				Ω(columns["country"]).Should(Equal(account.Record.Country))
+				Ω(columns["name[0]"]).Should(Equal(account.Record.Name[0]))
+			})
+
+			It("should send the header when no account matches the filter", func() {
+				output := &bytes.Buffer{}
+				emptyFilter := apiclient.AccountListFilter{OrganisationID: []string{libtest.GenerateOrganisationID()}}
+				Ω(accountClient.Export(context.Background(), emptyFilter, apiclient.ExportCSV, output)).Should(Succeed())
+
+				records, err := csv.NewReader(output).ReadAll()
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(records).Should(HaveLen(1))
+				Ω(records[0]).Should(ContainElement("name[0]"))
+			})
+		})
+	})
+})
@@ -0,0 +1,113 @@
+package apiclient_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		server              *ghttp.Server
+		exportPath          string
+		accountClientConfig apiclient.AccountClientConfig
+		accountClient       *apiclient.AccountClient
+		exported            string
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		exportPath = "/v1/account/export"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/account"
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: 50 * time.Millisecond,
+		}
+		accountClient = apiclient.NewAccountClient(&accountClientConfig)
+		exported = "{\"id\":\"1\"}\n{\"id\":\"2\"}\n"
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Export operation", func() {
+
+		Context("when accounts are exported", func() {
+
+			It("should send the filter and format, and write accounts", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", exportPath, "filter%5Bcountry%5D=GB%2CAU&format=csv"),
+					ghttp.RespondWith(http.StatusOK, exported),
+				))
+				output := &bytes.Buffer{}
+
+				err := accountClient.Export(context.Background(), apiclient.AccountListFilter{Country: []string{"GB", "AU"}}, apiclient.ExportCSV, output)
+
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(output.String()).Should(Equal(exported))
+			})
+
+			It("should not be limited by the client timeout", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", exportPath, "format=ndjson"),
+					func(w http.ResponseWriter, r *http.Request) { time.Sleep(150 * time.Millisecond) },
+					ghttp.RespondWith(http.StatusOK, exported),
+				))
+				output := &bytes.Buffer{}
+
+				err := accountClient.Export(context.Background(), apiclient.AccountListFilter{}, "", output)
+
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(output.String()).Should(Equal(exported))
+			})
+		})
+
+		Context("when Accounts API fails after sending accounts", func() {
+
+			It("should return ErrInternal", func() {
+				server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Trailer", "X-Export-Error")
+					w.WriteHeader(http.StatusOK)
+					w.Write([]byte(exported))
+					w.Header().Set("X-Export-Error", "Export failed, accounts are missing")
+				})
+				output := &bytes.Buffer{}
+
+				err := accountClient.Export(context.Background(), apiclient.AccountListFilter{}, apiclient.ExportNDJSON, output)
+
+				Ω(errors.Is(err, apiclient.ErrInternal)).Should(BeTrue())
+				Ω(output.String()).Should(Equal(exported))
+			})
+		})
+
+		Context("when writing accounts fails", func() {
+
+			It("should return ErrInternal", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusOK, exported))
+
+				err := accountClient.Export(context.Background(), apiclient.AccountListFilter{}, apiclient.ExportNDJSON, failingWriter{})
+
+				Ω(errors.Is(err, apiclient.ErrInternal)).Should(BeTrue())
+				Ω(err.Error()).Should(ContainSubstring("disk full"))
+			})
+		})
+	})
+})
@@ -1,28 +1,49 @@
 package apiclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+
+	"go.opentelemetry.io/otel/label"
 )
 
 // Fetch operation requests Account Information from underlying Accounts API
 //
 // Returns errors:
-//   - ErrWrongConfig when Server URL is malformed
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
 //   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
 //   - ErrInternal when other, not handled issues appear
 func (client *AccountClient) Fetch(accountID string) (*AccountResource, error) {
+	return client.FetchWithContext(context.Background(), accountID)
+}
+
+// FetchWithContext is like `Fetch`, but the request is sent with the context: it can be cancelled, and it is traced as a child of the context span.
+func (client *AccountClient) FetchWithContext(ctx context.Context, accountID string) (*AccountResource, error) {
+	ctx, op := client.startOperation(ctx, "Fetch", label.String("account.id", accountID))
+	account, err := client.fetch(ctx, accountID)
+	op.end(err)
+	return account, err
+}
+
+func (client *AccountClient) fetch(ctx context.Context, accountID string) (*AccountResource, error) {
 	// get Server URL
 	fetchURL, err := client.config.getURL(accountID, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to fetch account info: wrong API url %v %w", err, ErrWrongConfig)
 	}
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, "GET", fetchURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch account info: unknow error %v %w", err, ErrInternal)
+	}
 	// SEND request
-	resp, err := client.httpClient.Get(fetchURL)
+	resp, err := client.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch account info: response error %v %w", err, ErrConnection)
+		return nil, fmt.Errorf("Failed to fetch account info: %w", err)
 	}
 	defer resp.Body.Close()
 	// check Response Status Codes
@@ -46,6 +46,23 @@ var _ = Describe("The AccountClient", func() {
 				Ω(accountInfo.Attributes.BIC).Should(Equal(dbAccount.Record.BIC))
 				// TODO: there is a problem with accountapi: multiple fileds are not set or retrived, e.g. name, status
 			})
+
+			It("should revalidate cached Account information", func() {
+				var statusCodes []int
+				config := DefaultTestConfig
+				config.Cache = &apiclient.CacheConfig{}
+				config.Observer = apiclient.ObserverFunc(func(observation apiclient.Observation) {
+					statusCodes = append(statusCodes, observation.StatusCode)
+				})
+				accountClient = apiclient.NewAccountClient(&config)
+
+				first, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+				second, err := accountClient.Fetch(accountID)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(second).Should(Equal(first))
+				Ω(statusCodes).Should(Equal([]int{200, 304}))
+			})
 		})
 
 		Context("when Account does not exist", func() {
@@ -0,0 +1,138 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel/label"
+)
+
+// ImportConflict decides what happens to imported accounts which already exist
+type ImportConflict string
+
+const (
+	// ImportSkip - existing accounts are not changed, they are listed in the report as skipped
+	ImportSkip ImportConflict = "skip"
+	// ImportFail - nothing is imported when any of the accounts exists
+	ImportFail ImportConflict = "fail"
+	// ImportOverwriteIfNewer - existing accounts of the same organisation are replaced when the imported version is greater
+	ImportOverwriteIfNewer ImportConflict = "overwrite-if-newer"
+)
+
+// Import operation streams newline-delimited `AccountResource` JSON from `r` to Accounts API, which imports it in one transaction.
+// The file is not loaded into memory, unless `RequestSigning` is configured and `r` is not an `io.ReadSeeker`, e.g. `*os.File`, and the request is not limited by `AccountClientConfig.Timeout`:
+// use the context to limit it, and keep in mind that Accounts API limits it with its HTTP read timeout.
+// Imports cannot be retried automatically, the import can be repeated with `ImportSkip` policy.
+//
+// Lines which were not imported are available with `ImportReport` when `ImportSummary.Skipped` or `ImportSummary.Rejected` is not 0.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrImportFailed with the summary when nothing was imported because of `ImportFail` policy, or the file is malformed
+//   - ErrInternal when other, not handled issues appear
+func (client *AccountClient) Import(ctx context.Context, r io.Reader, conflict ImportConflict) (*ImportSummary, error) {
+	ctx, op := client.startOperation(ctx, "Import", label.String("account.import.conflict", string(conflict)))
+	summary, err := client.importAccounts(ctx, r, conflict)
+	op.end(err)
+	return summary, err
+}
+
+func (client *AccountClient) importAccounts(ctx context.Context, r io.Reader, conflict ImportConflict) (*ImportSummary, error) {
+	// get Server URL, imports are next to accounts
+	importURL, err := client.config.getURL("../account-imports", url.Values{"conflict": []string{string(conflict)}})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to import accounts: wrong API url %v %w", err, ErrWrongConfig)
+	}
+	// prepare Request, the body is not buffered so it cannot be sent again
+	req, err := http.NewRequestWithContext(ctx, "POST", importURL, uploadBody(r))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to import accounts: unknow error %v %w", err, ErrInternal)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	// SEND request
+	resp, err := client.stream(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to import accounts: %w", err)
+	}
+	defer resp.Body.Close()
+	// parse Response Body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to import accounts: response body error %v %w", err, ErrInternal)
+	}
+	var jsonResponse struct {
+		Message string         `json:"message"`
+		Data    *ImportSummary `json:"data"`
+	}
+	// check Response Status Codes
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusConflict: // 201, 409
+		if err = json.Unmarshal(body, &jsonResponse); err != nil || jsonResponse.Data == nil {
+			return nil, fmt.Errorf("Failed to import accounts: response json parse issue %v %w", err, ErrInternal)
+		}
+		if resp.StatusCode == http.StatusConflict {
+			return jsonResponse.Data, fmt.Errorf("Failed to import accounts: %v %w", jsonResponse.Message, ErrImportFailed)
+		}
+		return jsonResponse.Data, nil
+	case http.StatusBadRequest: // 400
+		_ = json.Unmarshal(body, &jsonResponse)
+		return nil, fmt.Errorf("Failed to import accounts: %v %w", jsonResponse.Message, ErrImportFailed)
+	}
+	return nil, fmt.Errorf("Failed to import accounts: response status code %v %w", resp.StatusCode, ErrInternal)
+}
+
+// ImportReport operation downloads the report of lines which were not imported, as newline-delimited `ImportRejection` JSON.
+// Reports are kept by Accounts API for 7 days. The caller has to close the report.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrNoReport when the report does not exist anymore
+//   - ErrInternal when other, not handled issues appear
+func (client *AccountClient) ImportReport(ctx context.Context, summary *ImportSummary) (io.ReadCloser, error) {
+	ctx, op := client.startOperation(ctx, "ImportReport", label.String("account.import.id", summary.ID))
+	report, err := client.importReport(ctx, summary)
+	op.end(err)
+	return report, err
+}
+
+func (client *AccountClient) importReport(ctx context.Context, summary *ImportSummary) (io.ReadCloser, error) {
+	// get Server URL, the report path is absolute
+	reportURL, err := client.config.getURL("/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download import report: wrong API url %v %w", err, ErrWrongConfig)
+	}
+	report, err := url.Parse(summary.Report)
+	if err != nil || summary.Report == "" {
+		return nil, fmt.Errorf("Failed to download import report: wrong report path %q %w", summary.Report, ErrInternal)
+	}
+	base, _ := url.Parse(reportURL)
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, "GET", base.ResolveReference(report).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download import report: unknow error %v %w", err, ErrInternal)
+	}
+	// SEND request
+	resp, err := client.stream(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download import report: %w", err)
+	}
+	// check Response Status Codes
+	if resp.StatusCode == http.StatusNotFound { // 404
+		discardResponse(resp)
+		return nil, fmt.Errorf("Failed to download import report: report %v %w", summary.ID, ErrNoReport)
+	}
+	if resp.StatusCode != http.StatusOK {
+		discardResponse(resp)
+		return nil, fmt.Errorf("Failed to download import report: response status code %v %w", resp.StatusCode, ErrInternal)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,99 @@
+package apiclient_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		accountClient *apiclient.AccountClient
+	)
+
+	BeforeEach(func() {
+		accountClient = apiclient.NewAccountClient(&DefaultTestConfig)
+	})
+
+	Describe("Import operation", func() {
+		var (
+			existing *libtest.DBAccount
+			accounts []apiclient.AccountResource
+			file     *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			existing = libtest.DBCreateAccounts(1)[0]
+			organisationID := libtest.GenerateOrganisationID()
+			accounts = []apiclient.AccountResource{
+				{Type: "accounts", ID: libtest.GenerateID(), OrganisationID: organisationID, Attributes: libtest.GenerateAccountAttributes()},
+				{Type: "accounts", ID: existing.ID.String(), OrganisationID: existing.OrganisationID.String(), Version: int(existing.Version) + 1, Attributes: libtest.GenerateAccountAttributes()},
+			}
+			file = &bytes.Buffer{}
+			encoder := json.NewEncoder(file)
+			for _, account := range accounts {
+				Ω(encoder.Encode(account)).Should(Succeed())
+			}
+			file.WriteString("{\"id\":\"not-an-account\"}\n")
+		})
+
+		Context("with skip policy", func() {
+
+			It("should import new accounts and report the others", func() {
+				summary, err := accountClient.Import(context.Background(), file, apiclient.ImportSkip)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(summary.Received).Should(Equal(3))
+				Ω(summary.Imported).Should(Equal(1))
+				Ω(summary.Skipped).Should(Equal(1))
+				Ω(summary.Rejected).Should(Equal(1))
+
+				_, err = accountClient.Fetch(accounts[0].ID)
+				Ω(err).ShouldNot(HaveOccurred())
+
+				report, err := accountClient.ImportReport(context.Background(), summary)
+				Ω(err).ShouldNot(HaveOccurred())
+				defer report.Close()
+				var codes []string
+				scanner := bufio.NewScanner(report)
+				for scanner.Scan() {
+					rejection := apiclient.ImportRejection{}
+					Ω(json.Unmarshal(scanner.Bytes(), &rejection)).Should(Succeed())
+					codes = append(codes, rejection.Code)
+				}
+				Ω(codes).Should(Equal([]string{"skipped", "invalid_account"}))
+			})
+		})
+
+		Context("with overwrite-if-newer policy", func() {
+
+			It("should replace accounts with older versions", func() {
+				summary, err := accountClient.Import(context.Background(), file, apiclient.ImportOverwriteIfNewer)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(summary.Imported).Should(Equal(2))
+
+				account, err := accountClient.Fetch(existing.ID.String())
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(account.Version).Should(Equal(accounts[1].Version))
+				Ω(account.Attributes.Country).Should(Equal(accounts[1].Attributes.Country))
+			})
+		})
+
+		Context("with fail policy", func() {
+
+			It("should not import any account", func() {
+				summary, err := accountClient.Import(context.Background(), file, apiclient.ImportFail)
+				Ω(err).Should(MatchError(apiclient.ErrImportFailed))
+				Ω(summary.Imported).Should(Equal(0))
+
+				_, err = accountClient.Fetch(accounts[0].ID)
+				Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+			})
+		})
+	})
+})
@@ -0,0 +1,133 @@
+package apiclient_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		server              *ghttp.Server
+		importsPath         string
+		accountClientConfig apiclient.AccountClientConfig
+		accountClient       *apiclient.AccountClient
+		importFile          string
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		importsPath = "/v1/account-imports"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/account"
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: 50 * time.Millisecond,
+		}
+		accountClient = apiclient.NewAccountClient(&accountClientConfig)
+		importFile = "{\"id\":\"1\"}\n{\"id\":\"2\"}\n"
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Import operation", func() {
+
+		Context("when accounts are imported", func() {
+
+			It("should stream the file and return the summary", func() {
+				summary := apiclient.ImportSummary{ID: "import-1", Conflict: "overwrite-if-newer", Received: 2, Imported: 2, Report: importsPath + "/import-1/rejections"}
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", importsPath, "conflict=overwrite-if-newer"),
+					ghttp.VerifyContentType("application/x-ndjson"),
+					ghttp.VerifyBody([]byte(importFile)),
+					ghttp.RespondWithJSONEncoded(http.StatusCreated, map[string]interface{}{"status": "success", "data": summary}),
+				))
+
+				result, err := accountClient.Import(context.Background(), strings.NewReader(importFile), apiclient.ImportOverwriteIfNewer)
+
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(*result).Should(Equal(summary))
+			})
+
+			It("should not be limited by the client timeout", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					func(w http.ResponseWriter, r *http.Request) { time.Sleep(150 * time.Millisecond) },
+					ghttp.RespondWithJSONEncoded(http.StatusCreated, map[string]interface{}{"status": "success", "data": apiclient.ImportSummary{ID: "import-1"}}),
+				))
+
+				result, err := accountClient.Import(context.Background(), strings.NewReader(importFile), apiclient.ImportSkip)
+
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(result.ID).Should(Equal("import-1"))
+			})
+		})
+
+		Context("when accounts already exist with fail policy", func() {
+
+			It("should return ErrImportFailed with the summary", func() {
+				summary := apiclient.ImportSummary{ID: "import-1", Conflict: "fail", Received: 2, Skipped: 1, Rejected: 1}
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", importsPath, "conflict=fail"),
+					ghttp.RespondWithJSONEncoded(http.StatusConflict, map[string]interface{}{"status": "error", "code": "account_exists", "data": summary}),
+				))
+
+				result, err := accountClient.Import(context.Background(), strings.NewReader(importFile), apiclient.ImportFail)
+
+				Ω(errors.Is(err, apiclient.ErrImportFailed)).Should(BeTrue())
+				Ω(*result).Should(Equal(summary))
+			})
+		})
+
+		Context("when the file is malformed", func() {
+
+			It("should return ErrImportFailed without the summary", func() {
+				server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusBadRequest, map[string]interface{}{"status": "error", "message": "line 2 is too long"}))
+
+				result, err := accountClient.Import(context.Background(), strings.NewReader(importFile), apiclient.ImportSkip)
+
+				Ω(errors.Is(err, apiclient.ErrImportFailed)).Should(BeTrue())
+				Ω(err.Error()).Should(ContainSubstring("line 2 is too long"))
+				Ω(result).Should(BeNil())
+			})
+		})
+	})
+
+	Describe("ImportReport operation", func() {
+
+		It("should download the report from the summary path", func() {
+			report := "{\"line\":2,\"id\":\"2\",\"code\":\"skipped\",\"message\":\"Account already exists\"}\n"
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", importsPath+"/import-1/rejections"),
+				ghttp.RespondWith(http.StatusOK, report, http.Header{"Content-Type": []string{"application/x-ndjson"}}),
+			))
+
+			body, err := accountClient.ImportReport(context.Background(), &apiclient.ImportSummary{ID: "import-1", Report: importsPath + "/import-1/rejections"})
+			Ω(err).ShouldNot(HaveOccurred())
+			defer body.Close()
+			content, err := ioutil.ReadAll(body)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(content)).Should(Equal(report))
+		})
+
+		It("should return ErrNoReport when the report was removed", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, `{"status":"error","message":"There is no import"}`))
+
+			_, err := accountClient.ImportReport(context.Background(), &apiclient.ImportSummary{ID: "import-1", Report: importsPath + "/import-1/rejections"})
+
+			Ω(errors.Is(err, apiclient.ErrNoReport)).Should(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,273 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/label"
+)
+
+// JobSpec describes the job submitted with `SubmitJob`
+type JobSpec struct {
+	Type JobType
+	// import jobs
+	Input    io.Reader      // newline-delimited `AccountResource` JSON, like in `Import`
+	Conflict ImportConflict // ImportSkip when not set
+	// export jobs
+	Filter AccountListFilter
+	Sort   []Sort       // by `id` when empty
+	Format ExportFormat // ExportNDJSON when not set
+}
+
+// SubmitJob operation queues an import or export which is run asynchronously by Accounts API, so it is not limited by HTTP timeouts.
+// Use `WaitJob` or `GetJob` to follow the job, and `JobResult` to download its result.
+// The import file is uploaded with the request, which is not limited by `AccountClientConfig.Timeout`: use the context to limit it.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrInternal when other, not handled issues appear, e.g. the job spec is wrong
+func (client *AccountClient) SubmitJob(ctx context.Context, spec JobSpec) (*Job, error) {
+	ctx, op := client.startOperation(ctx, "SubmitJob", label.String("job.type", string(spec.Type)))
+	job, err := client.submitJob(ctx, spec)
+	op.end(err)
+	return job, err
+}
+
+func (client *AccountClient) submitJob(ctx context.Context, spec JobSpec) (*Job, error) {
+	query := url.Values{"type": []string{string(spec.Type)}}
+	var body io.Reader
+	switch spec.Type {
+	case JobImport:
+		if spec.Conflict != "" {
+			query.Set("conflict", string(spec.Conflict))
+		}
+		if spec.Input == nil {
+			return nil, fmt.Errorf("Failed to submit job: import job without input %w", ErrInternal)
+		}
+		// the body is not buffered so it cannot be sent again
+		body = uploadBody(spec.Input)
+	case JobExport:
+		if spec.Format != "" {
+			query.Set("format", string(spec.Format))
+		}
+		for name, filterValues := range spec.Filter.QueryValues() {
+			query.Add("filter["+name+"]", strings.Join(filterValues, ","))
+		}
+		if len(spec.Sort) > 0 {
+			query.Set("sort", sortValue(spec.Sort))
+		}
+	}
+	// get Server URL, jobs are next to accounts
+	submitURL, err := client.config.getURL("../jobs", query)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to submit job: wrong API url %v %w", err, ErrWrongConfig)
+	}
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, "POST", submitURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to submit job: unknow error %v %w", err, ErrInternal)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	// SEND request
+	resp, err := client.stream(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to submit job: %w", err)
+	}
+	defer resp.Body.Close()
+	job, message, err := parseJobResponse(resp)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to submit job: %v %w", err, ErrInternal)
+	}
+	// check Response Status Codes
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("Failed to submit job: response status code %v %v %w", resp.StatusCode, message, ErrInternal)
+	}
+	return job, nil
+}
+
+// GetJob operation requests the status and progress of the job
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrNoJob when the job does not exist
+//   - ErrInternal when other, not handled issues appear
+func (client *AccountClient) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	ctx, op := client.startOperation(ctx, "GetJob", label.String("job.id", jobID))
+	job, err := client.jobRequest(ctx, "GET", jobID)
+	op.end(err)
+	return job, err
+}
+
+// CancelJob operation cancels the job. Queued jobs are cancelled at once, running jobs within seconds,
+// and finished jobs are not changed: use `WaitJob` to know how the job ended.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrNoJob when the job does not exist
+//   - ErrInternal when other, not handled issues appear
+func (client *AccountClient) CancelJob(ctx context.Context, jobID string) (*Job, error) {
+	ctx, op := client.startOperation(ctx, "CancelJob", label.String("job.id", jobID))
+	job, err := client.jobRequest(ctx, "DELETE", jobID)
+	op.end(err)
+	return job, err
+}
+
+func (client *AccountClient) jobRequest(ctx context.Context, method string, jobID string) (*Job, error) {
+	// get Server URL
+	jobURL, err := client.config.getURL("../jobs/"+url.PathEscape(jobID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request job: wrong API url %v %w", err, ErrWrongConfig)
+	}
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, method, jobURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request job: unknow error %v %w", err, ErrInternal)
+	}
+	// SEND request
+	resp, err := client.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request job: %w", err)
+	}
+	defer resp.Body.Close()
+	// check Response Status Codes
+	if resp.StatusCode == http.StatusNotFound { // 404
+		return nil, fmt.Errorf("Failed to find job %v %w", jobID, ErrNoJob)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("Failed to request job: response status code %v %w", resp.StatusCode, ErrInternal)
+	}
+	job, _, err := parseJobResponse(resp)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request job: %v %w", err, ErrInternal)
+	}
+	return job, nil
+}
+
+// parseJobResponse returns the job, or the error message of the response
+func parseJobResponse(resp *http.Response) (*Job, string, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("response body error %v", err)
+	}
+	var jsonResponse struct {
+		Message string `json:"message"`
+		Data    *Job   `json:"data"`
+	}
+	if err = json.Unmarshal(body, &jsonResponse); err != nil {
+		return nil, "", fmt.Errorf("response json parse issue %v", err)
+	}
+	if jsonResponse.Data == nil && jsonResponse.Message == "" {
+		return nil, "", fmt.Errorf("response has no job")
+	}
+	return jsonResponse.Data, jsonResponse.Message, nil
+}
+
+// WaitJob operation polls the job every `pollInterval` until it finishes. It returns the job with nil error when the job succeeded,
+// and with ErrJobFailed when it failed or was cancelled, `Job.Error` says why. Use the context to stop waiting.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrNoJob when the job does not exist
+//   - ErrJobFailed with the job when it failed or was cancelled
+//   - ErrInternal when other, not handled issues appear, e.g. the context was cancelled
+func (client *AccountClient) WaitJob(ctx context.Context, jobID string, pollInterval time.Duration) (*Job, error) {
+	ctx, op := client.startOperation(ctx, "WaitJob", label.String("job.id", jobID))
+	job, err := client.waitJob(ctx, jobID, pollInterval)
+	op.end(err)
+	return job, err
+}
+
+func (client *AccountClient) waitJob(ctx context.Context, jobID string, pollInterval time.Duration) (*Job, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := client.jobRequest(ctx, "GET", jobID)
+		if err != nil {
+			return nil, err
+		}
+		if job.Status.Finished() {
+			if job.Status != JobSucceeded {
+				return job, fmt.Errorf("Job %v is %v: %v %w", jobID, job.Status, job.Error, ErrJobFailed)
+			}
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return job, fmt.Errorf("Failed to wait for job %v: %v %w", jobID, ctx.Err(), ErrInternal)
+		case <-ticker.C:
+		}
+	}
+}
+
+// JobResult operation downloads the result of the succeeded job: the `ImportSummary` JSON of imports,
+// or accounts in the requested format of exports. The caller has to close the result.
+//
+// Returns errors:
+//   - ErrWrongConfig when Server URL is malformed, or TLS certificates cannot be loaded
+//   - ErrConnection when there are problems connecting Accounts API, e.g. server unavailable, or connection timeout
+//   - ErrUnauthorized when Accounts API or its token endpoint rejected the client credentials
+//   - ErrNoJob when the job does not exist anymore
+//   - ErrJobFailed when the job did not succeed
+//   - ErrInternal when other, not handled issues appear
+func (client *AccountClient) JobResult(ctx context.Context, job *Job) (io.ReadCloser, error) {
+	ctx, op := client.startOperation(ctx, "JobResult", label.String("job.id", job.ID))
+	result, err := client.jobResult(ctx, job)
+	op.end(err)
+	return result, err
+}
+
+func (client *AccountClient) jobResult(ctx context.Context, job *Job) (io.ReadCloser, error) {
+	if job.Result == "" {
+		return nil, fmt.Errorf("Failed to download job result: job %v is %v %w", job.ID, job.Status, ErrJobFailed)
+	}
+	// get Server URL, the result path is absolute
+	serverURL, err := client.config.getURL("/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download job result: wrong API url %v %w", err, ErrWrongConfig)
+	}
+	result, err := url.Parse(job.Result)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download job result: wrong result path %q %w", job.Result, ErrInternal)
+	}
+	base, _ := url.Parse(serverURL)
+	// prepare Request
+	req, err := http.NewRequestWithContext(ctx, "GET", base.ResolveReference(result).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download job result: unknow error %v %w", err, ErrInternal)
+	}
+	// SEND request
+	resp, err := client.stream(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download job result: %w", err)
+	}
+	// check Response Status Codes
+	switch resp.StatusCode {
+	case http.StatusOK: // 200
+		return resp.Body, nil
+	case http.StatusNotFound: // 404
+		discardResponse(resp)
+		return nil, fmt.Errorf("Failed to download job result: job %v %w", job.ID, ErrNoJob)
+	case http.StatusConflict: // 409
+		discardResponse(resp)
+		return nil, fmt.Errorf("Failed to download job result: job %v did not succeed %w", job.ID, ErrJobFailed)
+	}
+	discardResponse(resp)
+	return nil, fmt.Errorf("Failed to download job result: response status code %v %w", resp.StatusCode, ErrInternal)
+}
@@ -0,0 +1,140 @@
+package apiclient_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		accountClient *apiclient.AccountClient
+		ctx           context.Context
+		cancel        context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		accountClient = apiclient.NewAccountClient(&DefaultTestConfig)
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("Job operations", func() {
+
+		Context("with export job", func() {
+
+			It("should be run by a worker and return exported accounts", func() {
+				account := libtest.DBCreateAccounts(1)[0]
+				job, err := accountClient.SubmitJob(ctx, apiclient.JobSpec{
+					Type:   apiclient.JobExport,
+					Filter: apiclient.AccountListFilter{Country: []string{account.Record.Country}},
+				})
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(job.Status).Should(Equal(apiclient.JobQueued))
+
+				job, err = accountClient.WaitJob(ctx, job.ID, 100*time.Millisecond)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(job.Status).Should(Equal(apiclient.JobSucceeded))
+				Ω(job.StartedOn).ShouldNot(BeNil())
+				Ω(job.Processed).Should(BeNumerically(">=", 1))
+				Ω(libtest.DBGetJob(job.ID).Attempts).Should(Equal(1))
+
+				result, err := accountClient.JobResult(ctx, job)
+				Ω(err).ShouldNot(HaveOccurred())
+				defer result.Close()
+				exported, err := ioutil.ReadAll(result)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(string(exported)).Should(ContainSubstring(account.ID.String()))
+			})
+		})
+
+		Context("with import job", func() {
+
+			It("should import the uploaded file and count its lines", func() {
+				organisationID := libtest.GenerateOrganisationID()
+				accounts := []apiclient.AccountResource{
+					{Type: "accounts", ID: libtest.GenerateID(), OrganisationID: organisationID, Attributes: libtest.GenerateAccountAttributes()},
+					{Type: "accounts", ID: libtest.GenerateID(), OrganisationID: organisationID, Attributes: libtest.GenerateAccountAttributes()},
+				}
+				file := &bytes.Buffer{}
+				encoder := json.NewEncoder(file)
+				for _, account := range accounts {
+					Ω(encoder.Encode(account)).Should(Succeed())
+				}
+				job, err := accountClient.SubmitJob(ctx, apiclient.JobSpec{Type: apiclient.JobImport, Input: file})
+				Ω(err).ShouldNot(HaveOccurred())
+
+				job, err = accountClient.WaitJob(ctx, job.ID, 100*time.Millisecond)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(job.Processed).Should(Equal(int64(2)))
+				Ω(job.Summary).ShouldNot(BeNil())
+				Ω(job.Summary.Imported).Should(Equal(2))
+				for _, account := range accounts {
+					_, err = accountClient.Fetch(account.ID)
+					Ω(err).ShouldNot(HaveOccurred())
+				}
+			})
+		})
+
+		Context("with job running on another instance", func() {
+			var jobID string
+
+			BeforeEach(func() {
+				jobID = libtest.DBCreateRunningJob(7, 0)
+			})
+
+			AfterEach(func() {
+				libtest.DBDeleteJob(jobID)
+			})
+
+			It("should return its progress", func() {
+				job, err := accountClient.GetJob(ctx, jobID)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(job.Status).Should(Equal(apiclient.JobRunning))
+				Ω(job.Processed).Should(Equal(int64(7)))
+			})
+
+			It("should request cancellation and leave the job to its worker", func() {
+				job, err := accountClient.CancelJob(ctx, jobID)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(job.Status).Should(Equal(apiclient.JobRunning))
+				Ω(libtest.DBGetJob(jobID).CancelRequested).Should(BeTrue())
+			})
+
+			It("should not be taken by workers while it sends heartbeats", func() {
+				Consistently(func() int {
+					return libtest.DBGetJob(jobID).Attempts
+				}, 2*time.Second, 200*time.Millisecond).Should(Equal(1))
+			})
+		})
+
+		Context("with job of a crashed instance", func() {
+			var jobID string
+
+			BeforeEach(func() {
+				jobID = libtest.DBCreateRunningJob(7, 2*time.Minute)
+			})
+
+			AfterEach(func() {
+				libtest.DBDeleteJob(jobID)
+			})
+
+			It("should be taken again and run from the beginning", func() {
+				job, err := accountClient.WaitJob(ctx, jobID, 100*time.Millisecond)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(job.Status).Should(Equal(apiclient.JobSucceeded))
+				Ω(libtest.DBGetJob(jobID).Attempts).Should(Equal(2))
+			})
+		})
+	})
+})
@@ -0,0 +1,182 @@
+package apiclient_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient", func() {
+	var (
+		server              *ghttp.Server
+		jobsPath            string
+		accountClientConfig apiclient.AccountClientConfig
+		accountClient       *apiclient.AccountClient
+		job                 apiclient.Job
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		jobsPath = "/v1/jobs"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = "/v1/account"
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: 50 * time.Millisecond,
+		}
+		accountClient = apiclient.NewAccountClient(&accountClientConfig)
+		job = apiclient.Job{ID: "job-1", Type: apiclient.JobExport, Status: apiclient.JobQueued}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("SubmitJob operation", func() {
+
+		It("should upload the import file", func() {
+			importFile := "{\"id\":\"1\"}\n"
+			job.Type = apiclient.JobImport
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", jobsPath, "conflict=fail&type=import"),
+				ghttp.VerifyContentType("application/x-ndjson"),
+				ghttp.VerifyBody([]byte(importFile)),
+				ghttp.RespondWithJSONEncoded(http.StatusAccepted, map[string]interface{}{"data": job}),
+			))
+
+			submitted, err := accountClient.SubmitJob(context.Background(), apiclient.JobSpec{
+				Type:     apiclient.JobImport,
+				Input:    strings.NewReader(importFile),
+				Conflict: apiclient.ImportFail,
+			})
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(submitted.ID).Should(Equal("job-1"))
+			Ω(submitted.Status).Should(Equal(apiclient.JobQueued))
+		})
+
+		It("should send the export filter and format", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", jobsPath, "filter%5Bcountry%5D=GB&format=csv&type=export"),
+				ghttp.RespondWithJSONEncoded(http.StatusAccepted, map[string]interface{}{"data": job}),
+			))
+
+			_, err := accountClient.SubmitJob(context.Background(), apiclient.JobSpec{
+				Type:   apiclient.JobExport,
+				Filter: apiclient.AccountListFilter{Country: []string{"GB"}},
+				Format: apiclient.ExportCSV,
+			})
+
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should return ErrInternal when the job is rejected", func() {
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusBadRequest, map[string]interface{}{"status": "error", "message": "Wrong value in type query parameter"}))
+
+			_, err := accountClient.SubmitJob(context.Background(), apiclient.JobSpec{Type: "backup"})
+
+			Ω(errors.Is(err, apiclient.ErrInternal)).Should(BeTrue())
+			Ω(err.Error()).Should(ContainSubstring("Wrong value in type query parameter"))
+		})
+	})
+
+	Describe("CancelJob operation", func() {
+
+		It("should return ErrNoJob when the job does not exist", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("DELETE", jobsPath+"/job-1"),
+				ghttp.RespondWithJSONEncoded(http.StatusNotFound, map[string]interface{}{"status": "error", "message": "There is no job"}),
+			))
+
+			_, err := accountClient.CancelJob(context.Background(), "job-1")
+
+			Ω(errors.Is(err, apiclient.ErrNoJob)).Should(BeTrue())
+		})
+	})
+
+	Describe("WaitJob operation", func() {
+
+		It("should poll the job until it succeeds", func() {
+			running, succeeded := job, job
+			running.Status = apiclient.JobRunning
+			succeeded.Status = apiclient.JobSucceeded
+			succeeded.Result = jobsPath + "/job-1/result"
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", jobsPath+"/job-1"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"data": running}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", jobsPath+"/job-1"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"data": succeeded}),
+				),
+			)
+
+			finished, err := accountClient.WaitJob(context.Background(), "job-1", time.Millisecond)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(finished.Result).Should(Equal(succeeded.Result))
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+		})
+
+		It("should return ErrJobFailed with the failed job", func() {
+			job.Status = apiclient.JobFailed
+			job.Error = "Nothing was imported because some accounts already exist"
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"data": job}))
+
+			failed, err := accountClient.WaitJob(context.Background(), "job-1", time.Millisecond)
+
+			Ω(errors.Is(err, apiclient.ErrJobFailed)).Should(BeTrue())
+			Ω(failed.Error).Should(Equal(job.Error))
+		})
+
+		It("should stop waiting when the context is cancelled", func() {
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"data": job}))
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := accountClient.WaitJob(ctx, "job-1", time.Hour)
+
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("JobResult operation", func() {
+
+		It("should download the result of the succeeded job", func() {
+			job.Status = apiclient.JobSucceeded
+			job.Result = jobsPath + "/job-1/result"
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", job.Result),
+				ghttp.RespondWith(http.StatusOK, "{\"id\":\"1\"}\n"),
+			))
+
+			result, err := accountClient.JobResult(context.Background(), &job)
+
+			Ω(err).ShouldNot(HaveOccurred())
+			defer result.Close()
+			body, err := ioutil.ReadAll(result)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(body)).Should(Equal("{\"id\":\"1\"}\n"))
+		})
+
+		It("should return ErrJobFailed when the job has no result", func() {
+			job.Status = apiclient.JobCancelled
+
+			_, err := accountClient.JobResult(context.Background(), &job)
+
+			Ω(errors.Is(err, apiclient.ErrJobFailed)).Should(BeTrue())
+			Ω(server.ReceivedRequests()).Should(BeEmpty())
+		})
+	})
+})
@@ -1,13 +1,16 @@
 package apiclient
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // List operation requests a list of Accounts Info with the ability to filter and page
@@ -20,7 +23,13 @@ import (
 //
 // `List()` does not send any requests to underlying Accounts API. The first request is sent when `AccountPageResult.Next()` is called for the first time.
 func (client *AccountClient) List(page AccountPage) AccountPageResult {
+	return client.ListWithContext(context.Background(), page)
+}
+
+// ListWithContext is like `List`, but every page request is sent with the context: it can be cancelled, and it is traced as a child of the context span.
+func (client *AccountClient) ListWithContext(ctx context.Context, page AccountPage) AccountPageResult {
 	return AccountPageResult{
+		ctx:       ctx,
 		currPage:  page,
 		currData:  nil,
 		lastError: nil,
@@ -28,14 +37,34 @@ func (client *AccountClient) List(page AccountPage) AccountPageResult {
 	}
 }
 
-func (client *AccountClient) fetchAccountList(page AccountPage) ([]AccountResource, error) {
-	listURL, err := client.config.getURL("", convertToQuery(page.PageNumber, page.PageSize, page.Filter))
+func (client *AccountClient) fetchAccountList(ctx context.Context, page AccountPage) ([]AccountResource, error) {
+	ctx, op := client.startOperation(ctx, "List", pageAttributes(page)...)
+	accounts, err := client.fetchAccountPage(ctx, page)
+	// running out of accounts is how the listing ends, not a failure
+	if errors.Is(err, ErrNoAccount) {
+		op.end(nil)
+	} else {
+		op.end(err)
+	}
+	return accounts, err
+}
+
+func (client *AccountClient) fetchAccountPage(ctx context.Context, page AccountPage) ([]AccountResource, error) {
+	query := convertToQuery(page.PageNumber, page.PageSize, page.Filter, page.Fields)
+	if len(page.Sort) > 0 {
+		query.Set("sort", sortValue(page.Sort))
+	}
+	listURL, err := client.config.getURL("", query)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get list: wrong API url %v %w", err, ErrWrongConfig)
 	}
-	resp, err := client.httpClient.Get(listURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get list: response error %v %w", err, ErrConnection)
+		return nil, fmt.Errorf("Failed to get list: unknow error %v %w", err, ErrInternal)
+	}
+	resp, err := client.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get list: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
@@ -58,7 +87,7 @@ func (client *AccountClient) fetchAccountList(page AccountPage) ([]AccountResour
 	return jsonResponse.Data, nil
 }
 
-func convertToQuery(pageNumber int, pageSize int, filter AccountListFilter) url.Values {
+func convertToQuery(pageNumber int, pageSize int, filter AccountListFilter, fields []string) url.Values {
 	values := url.Values{}
 	values.Add("page[number]", strconv.Itoa(pageNumber))
 	if pageSize <= 0 {
@@ -66,23 +95,63 @@ func convertToQuery(pageNumber int, pageSize int, filter AccountListFilter) url.
 	} else {
 		values.Add("page[size]", strconv.Itoa(pageSize))
 	}
-	if len(filter.AccountNumber) > 0 {
-		values.Add("filter[account_number]", strings.Join(filter.AccountNumber, ","))
+	for name, filterValues := range filter.QueryValues() {
+		values.Add("filter["+name+"]", strings.Join(filterValues, ","))
 	}
-	if len(filter.BankID) > 0 {
-		values.Add("filter[bank_id]", strings.Join(filter.BankID, ","))
+	if len(fields) > 0 {
+		values.Add("fields[accounts]", strings.Join(fields, ","))
 	}
-	if len(filter.BankIDCode) > 0 {
-		values.Add("filter[bank_id_code]", strings.Join(filter.BankIDCode, ","))
+	return values
+}
+
+// sortValue is the JSON:API `sort` query parameter, fields are comma separated and descending ones start with `-`
+func sortValue(sorts []Sort) string {
+	fields := make([]string, len(sorts))
+	for i, sort := range sorts {
+		fields[i] = string(sort.Field)
+		if sort.Descending {
+			fields[i] = "-" + fields[i]
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// QueryValues returns not empty filters by their `filter[...]` query parameter name, with values formatted like in the query.
+// Accounts API uses the same names, e.g. in trace attributes.
+func (filter AccountListFilter) QueryValues() map[string][]string {
+	values := map[string][]string{}
+	for name, filterValues := range map[string][]string{
+		"account_number":         filter.AccountNumber,
+		"bank_id":                filter.BankID,
+		"bank_id_code":           filter.BankIDCode,
+		"country":                filter.Country,
+		"customer_id":            filter.CustomerID,
+		"iban":                   filter.IBAN,
+		"status":                 filter.Status,
+		"account_classification": filter.AccountClassification,
+		"base_currency":          filter.BaseCurrency,
+		"organisation_id":        filter.OrganisationID,
+	} {
+		if len(filterValues) > 0 {
+			values[name] = filterValues
+		}
 	}
-	if len(filter.Country) > 0 {
-		values.Add("filter[country]", strings.Join(filter.Country, ","))
+	if filter.JointAccount != nil {
+		values["joint_account"] = []string{strconv.FormatBool(*filter.JointAccount)}
 	}
-	if len(filter.CustomerID) > 0 {
-		values.Add("filter[customer_id]", strings.Join(filter.CustomerID, ","))
+	for name, t := range map[string]time.Time{
+		"created_from":  filter.CreatedFrom,
+		"created_to":    filter.CreatedTo,
+		"modified_from": filter.ModifiedFrom,
+		"modified_to":   filter.ModifiedTo,
+	} {
+		if !t.IsZero() {
+			values[name] = []string{t.UTC().Format(time.RFC3339Nano)}
+		}
 	}
-	if len(filter.IBAN) > 0 {
-		values.Add("filter[iban]", strings.Join(filter.IBAN, ","))
+	// the prefix is one value, it can have commas
+	if filter.Name != "" {
+		values["name_prefix"] = []string{filter.Name}
 	}
 	return values
 }
@@ -1,9 +1,12 @@
 package apiclient_test
 
 import (
+	"time"
+
 	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
 	"github.com/fkondej/go-showcase/v1/pkg/libtest"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -75,5 +78,143 @@ var _ = Describe("The AccountClient", func() {
 				})
 			})
 		})
+
+		Context("when filtering by attributes, dates and name", func() {
+			var (
+				organisationID string
+				matching       *libtest.DBAccount
+			)
+
+			BeforeEach(func() {
+				organisationID = libtest.GenerateOrganisationID()
+				classification, status, jointAccount := "business", "confirmed", true
+				attributes := libtest.GenerateAccountAttributes()
+				attributes.AccountClassification = &classification
+				attributes.Status = &status
+				attributes.JointAccount = &jointAccount
+				attributes.Name = [4]string{"", "Zyxwvut_% Holdings", "", ""}
+				matching = libtest.DBUpsertAccount(libtest.GenerateID(), organisationID, attributes)
+				other := libtest.GenerateAccountAttributes()
+				other.Name = [4]string{"Zyxwvut Other", "", "", ""}
+				libtest.DBUpsertAccount(libtest.GenerateID(), organisationID, other)
+			})
+
+			It("should return only matching accounts", func() {
+				jointAccount := true
+				accounts := accountClient.List(apiclient.AccountPage{Filter: apiclient.AccountListFilter{
+					OrganisationID:        []string{organisationID},
+					Status:                []string{"confirmed"},
+					AccountClassification: []string{"business"},
+					JointAccount:          &jointAccount,
+					CreatedFrom:           matching.CreatedOn.Add(-time.Hour),
+					CreatedTo:             matching.CreatedOn.Add(time.Hour),
+					Name:                  "zyxwvut_%",
+				}})
+
+				Ω(accounts.Next()).Should(BeTrue())
+				data, err := accounts.Data()
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(data).Should(HaveLen(1))
+				Ω(data[0].ID).Should(Equal(matching.ID.String()))
+			})
+
+			It("should not return accounts created after the range", func() {
+				accounts := accountClient.List(apiclient.AccountPage{Filter: apiclient.AccountListFilter{
+					OrganisationID: []string{organisationID},
+					CreatedTo:      matching.CreatedOn.Add(-time.Hour),
+				}})
+
+				Ω(accounts.Next()).Should(BeFalse())
+			})
+		})
+
+		Context("when filtering by one attribute", func() {
+			var (
+				organisationID string
+				accounts       map[string]*libtest.DBAccount
+			)
+
+			BeforeEach(func() {
+				organisationID = libtest.GenerateOrganisationID()
+				create := func(currency string, status string, classification string, modifiedOn time.Time) *libtest.DBAccount {
+					attributes := libtest.GenerateAccountAttributes()
+					attributes.BaseCurrency = &currency
+					attributes.Status = &status
+					attributes.AccountClassification = &classification
+					account := libtest.DBUpsertAccount(libtest.GenerateID(), organisationID, attributes)
+					return libtest.DBSetModifiedOn(account.ID.String(), modifiedOn)
+				}
+				now := time.Now()
+				accounts = map[string]*libtest.DBAccount{
+					"older": create("GBP", "confirmed", "business", now.Add(-2*time.Hour)),
+					"newer": create("EUR", "pending", "personal", now),
+				}
+			})
+
+			DescribeTable("should return the matching account and not the other one",
+				func(filter func(modifiedBetween time.Time) apiclient.AccountListFilter, expected string) {
+					modifiedBetween := accounts["older"].ModifiedOn.Add(time.Hour)
+					listFilter := filter(modifiedBetween)
+					listFilter.OrganisationID = []string{organisationID}
+					ids := []string{}
+					result := accountClient.List(apiclient.AccountPage{Filter: listFilter})
+					for account := range result.FetchAll() {
+						ids = append(ids, account.ID)
+					}
+					Ω(ids).Should(Equal([]string{accounts[expected].ID.String()}))
+				},
+				Entry("base_currency", func(time.Time) apiclient.AccountListFilter {
+					return apiclient.AccountListFilter{BaseCurrency: []string{"GBP"}}
+				}, "older"),
+				Entry("modified_from", func(modifiedBetween time.Time) apiclient.AccountListFilter {
+					return apiclient.AccountListFilter{ModifiedFrom: modifiedBetween}
+				}, "newer"),
+				Entry("modified_to", func(modifiedBetween time.Time) apiclient.AccountListFilter {
+					return apiclient.AccountListFilter{ModifiedTo: modifiedBetween}
+				}, "older"),
+				Entry("status", func(time.Time) apiclient.AccountListFilter {
+					return apiclient.AccountListFilter{Status: []string{"pending"}}
+				}, "newer"),
+				Entry("account_classification", func(time.Time) apiclient.AccountListFilter {
+					return apiclient.AccountListFilter{AccountClassification: []string{"business"}}
+				}, "older"),
+			)
+		})
+
+		Context("when sorting", func() {
+			var (
+				organisationID string
+			)
+
+			BeforeEach(func() {
+				organisationID = libtest.GenerateOrganisationID()
+				for _, name := range []string{"Beta", "alpha", "Gamma"} {
+					attributes := libtest.GenerateAccountAttributes()
+					attributes.Name = [4]string{name, "", "", ""}
+					libtest.DBUpsertAccount(libtest.GenerateID(), organisationID, attributes)
+				}
+			})
+
+			names := func(sort apiclient.Sort) []string {
+				accounts := accountClient.List(apiclient.AccountPage{
+					PageSize: 2,
+					Filter:   apiclient.AccountListFilter{OrganisationID: []string{organisationID}},
+					Sort:     []apiclient.Sort{sort},
+				})
+				result := []string{}
+				for account := range accounts.FetchAll() {
+					result = append(result, account.Attributes.Name[0])
+				}
+				return result
+			}
+
+			It("should sort by name case-insensitively across pages", func() {
+				Ω(names(apiclient.Sort{Field: apiclient.SortByName})).Should(Equal([]string{"alpha", "Beta", "Gamma"}))
+			})
+
+			It("should sort descending", func() {
+				Ω(names(apiclient.Sort{Field: apiclient.SortByName, Descending: true})).Should(Equal([]string{"Gamma", "Beta", "alpha"}))
+			})
+		})
 	})
 })
@@ -156,6 +156,7 @@ var _ = Describe("The AccountClient", func() {
 		Describe("request with filters", func() {
 			var (
 				listOfAccounts []apiclient.AccountResource
+				jointAccount   = true
 			)
 
 			BeforeEach(func() {
@@ -193,9 +194,55 @@ var _ = Describe("The AccountClient", func() {
 				Entry("multiple Country", apiclient.AccountListFilter{Country: []string{"GB", "AU"}}, "filter[country]=GB,AU"),
 				Entry("multiple CustomerID", apiclient.AccountListFilter{CustomerID: []string{"1322132", "6546546"}}, "filter[customer_id]=1322132,6546546"),
 				Entry("multiple IBAN", apiclient.AccountListFilter{IBAN: []string{"56456464", "123213"}}, "filter[iban]=56456464,123213"),
+				Entry("multiple Status", apiclient.AccountListFilter{Status: []string{"pending", "confirmed"}}, "filter[status]=pending,confirmed"),
+				Entry("single AccountClassification", apiclient.AccountListFilter{AccountClassification: []string{"business"}}, "filter[account_classification]=business"),
+				Entry("multiple BaseCurrency", apiclient.AccountListFilter{BaseCurrency: []string{"GBP", "EUR"}}, "filter[base_currency]=GBP,EUR"),
+				Entry("single OrganisationID", apiclient.AccountListFilter{OrganisationID: []string{"eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"}}, "filter[organisation_id]=eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"),
+				Entry("JointAccount", apiclient.AccountListFilter{JointAccount: &jointAccount}, "filter[joint_account]=true"),
+				Entry("created range", apiclient.AccountListFilter{CreatedFrom: time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC), CreatedTo: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)}, "filter[created_from]=2021-01-02T15:04:05Z&filter[created_to]=2021-02-01T00:00:00Z"),
+				Entry("modified range in UTC", apiclient.AccountListFilter{ModifiedFrom: time.Date(2021, 1, 2, 16, 4, 5, 0, time.FixedZone("CET", 3600))}, "filter[modified_from]=2021-01-02T15:04:05Z"),
+				Entry("Name prefix with comma", apiclient.AccountListFilter{Name: "smith, j"}, "filter[name_prefix]=smith,+j"),
 				Entry("mixture", apiclient.AccountListFilter{IBAN: []string{"56456464", "123213"}, Country: []string{"GB", "AU"}, BankIDCode: []string{"23423423"}}, "filter[country]=GB,AU&filter[iban]=56456464,123213&filter[bank_id_code]=23423423"),
 			)
 		})
+
+		Describe("request with sort", func() {
+
+			BeforeEach(func() {
+				responseStatusCode = http.StatusOK // 200
+				responseData = &ListAccountResponse{
+					Data: libtest.GenerateAccountResources(5),
+				}
+			})
+
+			It("should send JSON:API sort query parameter", func() {
+				rawQuery[0] = "page[number]=0&page[size]=100&sort=-created_on,country"
+
+				accounts := accountClient.List(apiclient.AccountPage{Sort: []apiclient.Sort{
+					{Field: apiclient.SortByCreatedOn, Descending: true},
+					{Field: apiclient.SortByCountry},
+				}})
+
+				Ω(accounts.Next()).Should(BeTrue())
+				_, err := accounts.Data()
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			})
+
+			It("should sort every page the same way", func() {
+				rawQuery[0] = "page[number]=0&page[size]=5&sort=name"
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", listPath, "page[number]=1&page[size]=5&sort=name"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, ListAccountResponse{Data: []apiclient.AccountResource{}}),
+				))
+
+				accounts := accountClient.List(apiclient.AccountPage{PageSize: 5, Sort: []apiclient.Sort{{Field: apiclient.SortByName}}})
+
+				Ω(accounts.Next()).Should(BeTrue())
+				Ω(accounts.Next()).Should(BeFalse())
+				Ω(server.ReceivedRequests()).Should(HaveLen(2))
+			})
+		})
 	})
 
 	Describe("List Accounts: request multiple pages", func() {
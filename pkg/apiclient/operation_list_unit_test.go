@@ -6,6 +6,7 @@ package apiclient
 import (
 	"fmt"
 	"net/url"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -18,7 +19,7 @@ var _ = Describe("operation List functionality", func() {
 		Context("without filters", func() {
 			It("should not add filters to query", func() {
 				Ω(
-					convertToQuery(0, 100, AccountListFilter{}),
+					convertToQuery(0, 100, AccountListFilter{}, nil),
 				).Should(Equal(
 					url.Values{
 						"page[number]": []string{"0"},
@@ -38,7 +39,7 @@ var _ = Describe("operation List functionality", func() {
 						Country:       []string{"UK", "US", "AU"},
 						CustomerID:    []string{"123", "56646"},
 						IBAN:          []string{"7876868", "432432"},
-					}),
+					}, nil),
 				).Should(Equal(
 					url.Values{
 						"page[number]":           []string{"0"},
@@ -53,6 +54,51 @@ var _ = Describe("operation List functionality", func() {
 				))
 			})
 		})
+
+		Context("with status, classification, date and name filters", func() {
+			It("should add filters to query", func() {
+				jointAccount := false
+				Ω(
+					convertToQuery(0, 100, AccountListFilter{
+						Status:                []string{"confirmed"},
+						AccountClassification: []string{"personal", "business"},
+						BaseCurrency:          []string{"GBP"},
+						OrganisationID:        []string{"eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"},
+						JointAccount:          &jointAccount,
+						CreatedFrom:           time.Date(2021, 1, 2, 15, 4, 5, 500, time.UTC),
+						ModifiedTo:            time.Date(2021, 3, 1, 1, 0, 0, 0, time.FixedZone("CET", 3600)),
+						Name:                  "Smith, J",
+					}, nil),
+				).Should(Equal(
+					url.Values{
+						"page[number]":                   []string{"0"},
+						"page[size]":                     []string{"100"},
+						"filter[status]":                 []string{"confirmed"},
+						"filter[account_classification]": []string{"personal,business"},
+						"filter[base_currency]":          []string{"GBP"},
+						"filter[organisation_id]":        []string{"eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"},
+						"filter[joint_account]":          []string{"false"},
+						"filter[created_from]":           []string{"2021-01-02T15:04:05.0000005Z"},
+						"filter[modified_to]":            []string{"2021-03-01T00:00:00Z"},
+						"filter[name_prefix]":            []string{"Smith, J"},
+					},
+				))
+			})
+		})
+
+		Context("with fields", func() {
+			It("should add sparse fieldset to query", func() {
+				Ω(
+					convertToQuery(1, 10, AccountListFilter{}, []string{"country", "iban"}),
+				).Should(Equal(
+					url.Values{
+						"page[number]":     []string{"1"},
+						"page[size]":       []string{"10"},
+						"fields[accounts]": []string{"country,iban"},
+					},
+				))
+			})
+		})
 	})
 
 	Describe("AccountPageResult", func() {
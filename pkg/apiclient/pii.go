@@ -0,0 +1,108 @@
+package apiclient
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RedactedValue replaces personal data in redacted resources
+const RedactedValue = "[REDACTED]"
+
+// piiTag marks fields holding personal data, e.g. `pii:"true"`, a tagged struct is personal data as a whole
+const piiTag = "pii"
+
+// Redact returns a copy of `v` where fields tagged with `pii:"true"` are redacted:
+// strings are replaced with `RedactedValue`, and other values with their zero value.
+// Values without personal data, e.g. numbers or maps, are returned unchanged.
+//
+// It should be used before resources are logged or sent to systems not allowed to store personal data.
+func Redact(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	value := reflect.ValueOf(v)
+	if !containsPII(value.Type(), map[reflect.Type]bool{}) {
+		return v
+	}
+	return redactValue(value, false).Interface()
+}
+
+// containsPII checks if values of the type can hold personal data
+func containsPII(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return containsPII(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get(piiTag) == "true" || containsPII(field.Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactValue copies the value, when `all` is true then every string in the value is personal data
+func redactValue(value reflect.Value, all bool) reflect.Value {
+	result := reflect.New(value.Type()).Elem()
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			elem := redactValue(value.Elem(), all)
+			ptr := reflect.New(elem.Type())
+			ptr.Elem().Set(elem)
+			result.Set(ptr)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" { // unexported
+				continue
+			}
+			result.Field(i).Set(redactValue(value.Field(i), all || field.Tag.Get(piiTag) == "true"))
+		}
+	case reflect.Slice:
+		if !value.IsNil() {
+			result.Set(reflect.MakeSlice(value.Type(), value.Len(), value.Len()))
+			for i := 0; i < value.Len(); i++ {
+				result.Index(i).Set(redactValue(value.Index(i), all))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			result.Index(i).Set(redactValue(value.Index(i), all))
+		}
+	case reflect.String:
+		if !all {
+			result.Set(value)
+		} else if value.Len() > 0 {
+			result.SetString(RedactedValue)
+		}
+	default:
+		if !all {
+			result.Set(value)
+		}
+	}
+	return result
+}
+
+// String prints attributes with personal data redacted
+func (a AccountAttributes) String() string {
+	type plain AccountAttributes
+	return fmt.Sprintf("%+v", plain(a))
+}
+
+// String hides personal data when it is printed
+func (p PrivateIdentification) String() string {
+	return RedactedValue
+}
+
+// GoString hides personal data when it is printed with `%#v`
+func (p PrivateIdentification) GoString() string {
+	return RedactedValue
+}
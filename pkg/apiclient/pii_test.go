@@ -0,0 +1,78 @@
+package apiclient_test
+
+import (
+	"fmt"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Personal data redaction", func() {
+	var (
+		birthDate  string
+		attributes *apiclient.AccountAttributes
+		account    apiclient.AccountResource
+	)
+
+	BeforeEach(func() {
+		birthDate = "1970-01-01"
+		attributes = libtest.GenerateAccountAttributes()
+		attributes.PrivateIdentification = &apiclient.PrivateIdentification{
+			BirthDate:      &birthDate,
+			Identification: "AB123456",
+			Address:        []string{"10 Downing Street"},
+		}
+		account = apiclient.AccountResource{
+			Type:           "accounts",
+			ID:             libtest.GenerateID(),
+			OrganisationID: libtest.GenerateOrganisationID(),
+			Attributes:     attributes,
+		}
+	})
+
+	Context("when account is redacted", func() {
+
+		It("should replace personal data and keep other attributes", func() {
+			redacted := apiclient.Redact(account).(apiclient.AccountResource)
+			Ω(redacted.ID).Should(Equal(account.ID))
+			Ω(redacted.Attributes.Country).Should(Equal(attributes.Country))
+			Ω(redacted.Attributes.Name).Should(Equal(attributes.Name))
+			Ω(*redacted.Attributes.PrivateIdentification.BirthDate).Should(Equal(apiclient.RedactedValue))
+			Ω(redacted.Attributes.PrivateIdentification.Identification).Should(Equal(apiclient.RedactedValue))
+			Ω(redacted.Attributes.PrivateIdentification.Address).Should(Equal([]string{apiclient.RedactedValue}))
+			Ω(redacted.Attributes.PrivateIdentification.City).Should(BeNil())
+		})
+
+		It("should not modify the original", func() {
+			apiclient.Redact(&account)
+			Ω(*attributes.PrivateIdentification.BirthDate).Should(Equal(birthDate))
+			Ω(attributes.PrivateIdentification.Identification).Should(Equal("AB123456"))
+		})
+	})
+
+	Context("when value has no personal data", func() {
+
+		It("should return it unchanged", func() {
+			filter := apiclient.AccountListFilter{Country: []string{"GB"}}
+			Ω(apiclient.Redact(filter)).Should(Equal(filter))
+			Ω(apiclient.Redact(nil)).Should(BeNil())
+		})
+	})
+
+	Context("when account is printed", func() {
+
+		It("should not print personal data", func() {
+			for _, format := range []string{"%v", "%+v", "%s"} {
+				printed := fmt.Sprintf(format, account)
+				Ω(printed).ShouldNot(ContainSubstring("AB123456"), "format %v", format)
+				Ω(printed).ShouldNot(ContainSubstring("Downing"), "format %v", format)
+				Ω(printed).Should(ContainSubstring(apiclient.RedactedValue), "format %v", format)
+			}
+			printed := fmt.Sprintf("%#v", *attributes)
+			Ω(printed).ShouldNot(ContainSubstring("AB123456"))
+			Ω(printed).Should(ContainSubstring(apiclient.RedactedValue))
+		})
+	})
+})
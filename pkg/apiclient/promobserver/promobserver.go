@@ -0,0 +1,122 @@
+// Package promobserver collects Prometheus metrics of AccountClient operations.
+//
+// Usage:
+//
+//	observer := promobserver.New(promobserver.Options{Namespace: "importer"})
+//	prometheus.MustRegister(observer)
+//	client := apiclient.NewAccountClient(&apiclient.AccountClientConfig{
+//	  URL:      "http://localhost:8080/v1/account",
+//	  Observer: observer,
+//	})
+package promobserver
+
+import (
+	"strconv"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options of the collected metrics
+type Options struct {
+	Namespace   string            // prefix of metric names, e.g. name of the service using the client
+	ConstLabels prometheus.Labels // labels added to every metric, e.g. name of the Account API instance
+	Buckets     []float64         // buckets of the duration histogram, `prometheus.DefBuckets` when not set
+}
+
+// Observer is an `apiclient.Observer` and a `prometheus.Collector`
+type Observer struct {
+	operations    *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	attempts      *prometheus.CounterVec
+	hedged        *prometheus.CounterVec
+	bytesSent     *prometheus.CounterVec
+	bytesReceived *prometheus.CounterVec
+}
+
+// New creates metrics of AccountClient operations, they have to be registered before they are exposed
+func New(options Options) *Observer {
+	buckets := options.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	return &Observer{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Subsystem:   "account_client",
+			Name:        "operations_total",
+			Help:        "Number of AccountClient operations by operation, status code of the last response and error class.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"operation", "code", "error"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   options.Namespace,
+			Subsystem:   "account_client",
+			Name:        "operation_duration_seconds",
+			Help:        "Latency of AccountClient operations, including retries.",
+			ConstLabels: options.ConstLabels,
+			Buckets:     buckets,
+		}, []string{"operation"}),
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Subsystem:   "account_client",
+			Name:        "requests_total",
+			Help:        "Number of requests sent to Account API, including retries.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"operation"}),
+		hedged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Subsystem:   "account_client",
+			Name:        "hedged_requests_total",
+			Help:        "Number of hedged requests sent to Account API because it did not answer in time.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"operation"}),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Subsystem:   "account_client",
+			Name:        "sent_bytes_total",
+			Help:        "Size of request bodies sent to Account API.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"operation"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   options.Namespace,
+			Subsystem:   "account_client",
+			Name:        "received_bytes_total",
+			Help:        "Size of response bodies received from Account API.",
+			ConstLabels: options.ConstLabels,
+		}, []string{"operation"}),
+	}
+}
+
+// ObserveOperation implements `apiclient.Observer`
+func (o *Observer) ObserveOperation(observation apiclient.Observation) {
+	code := ""
+	if observation.StatusCode != 0 {
+		code = strconv.Itoa(observation.StatusCode)
+	}
+	o.operations.WithLabelValues(observation.Operation, code, observation.ErrorClass).Inc()
+	o.duration.WithLabelValues(observation.Operation).Observe(observation.Duration.Seconds())
+	o.attempts.WithLabelValues(observation.Operation).Add(float64(observation.Attempt))
+	o.hedged.WithLabelValues(observation.Operation).Add(float64(observation.Hedged))
+	o.bytesSent.WithLabelValues(observation.Operation).Add(float64(observation.BytesSent))
+	o.bytesReceived.WithLabelValues(observation.Operation).Add(float64(observation.BytesReceived))
+}
+
+// Describe implements `prometheus.Collector`
+func (o *Observer) Describe(ch chan<- *prometheus.Desc) {
+	o.operations.Describe(ch)
+	o.duration.Describe(ch)
+	o.attempts.Describe(ch)
+	o.hedged.Describe(ch)
+	o.bytesSent.Describe(ch)
+	o.bytesReceived.Describe(ch)
+}
+
+// Collect implements `prometheus.Collector`
+func (o *Observer) Collect(ch chan<- prometheus.Metric) {
+	o.operations.Collect(ch)
+	o.duration.Collect(ch)
+	o.attempts.Collect(ch)
+	o.hedged.Collect(ch)
+	o.bytesSent.Collect(ch)
+	o.bytesReceived.Collect(ch)
+}
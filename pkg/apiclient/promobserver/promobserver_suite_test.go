@@ -0,0 +1,13 @@
+package promobserver_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPromobserver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "promobserver")
+}
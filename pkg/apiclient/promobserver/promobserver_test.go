@@ -0,0 +1,93 @@
+package promobserver_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient/promobserver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Prometheus observer", func() {
+	var (
+		observer *promobserver.Observer
+		registry *prometheus.Registry
+	)
+
+	BeforeEach(func() {
+		observer = promobserver.New(promobserver.Options{
+			Namespace:   "importer",
+			ConstLabels: prometheus.Labels{"api": "accounts"},
+			Buckets:     []float64{0.1, 1},
+		})
+		registry = prometheus.NewRegistry()
+		Ω(registry.Register(observer)).Should(Succeed())
+	})
+
+	Context("when operations are observed", func() {
+
+		BeforeEach(func() {
+			observer.ObserveOperation(apiclient.Observation{
+				Operation:     "Fetch",
+				StatusCode:    200,
+				Duration:      50 * time.Millisecond,
+				Attempt:       2,
+				Hedged:        1,
+				BytesReceived: 300,
+			})
+			observer.ObserveOperation(apiclient.Observation{
+				Operation:  "Create",
+				ErrorClass: "connection",
+				Duration:   2 * time.Second,
+				Attempt:    1,
+				BytesSent:  120,
+			})
+		})
+
+		It("should count operations by status code and error class", func() {
+			expected := `
+# HELP importer_account_client_operations_total Number of AccountClient operations by operation, status code of the last response and error class.
+# TYPE importer_account_client_operations_total counter
+importer_account_client_operations_total{api="accounts",code="",error="connection",operation="Create"} 1
+importer_account_client_operations_total{api="accounts",code="200",error="",operation="Fetch"} 1
+`
+			Ω(testutil.GatherAndCompare(registry, strings.NewReader(expected), "importer_account_client_operations_total")).Should(Succeed())
+		})
+
+		It("should measure duration with configured buckets", func() {
+			expected := `
+# HELP importer_account_client_operation_duration_seconds Latency of AccountClient operations, including retries.
+# TYPE importer_account_client_operation_duration_seconds histogram
+importer_account_client_operation_duration_seconds_bucket{api="accounts",operation="Create",le="0.1"} 0
+importer_account_client_operation_duration_seconds_bucket{api="accounts",operation="Create",le="1"} 0
+importer_account_client_operation_duration_seconds_bucket{api="accounts",operation="Create",le="+Inf"} 1
+importer_account_client_operation_duration_seconds_sum{api="accounts",operation="Create"} 2
+importer_account_client_operation_duration_seconds_count{api="accounts",operation="Create"} 1
+importer_account_client_operation_duration_seconds_bucket{api="accounts",operation="Fetch",le="0.1"} 1
+importer_account_client_operation_duration_seconds_bucket{api="accounts",operation="Fetch",le="1"} 1
+importer_account_client_operation_duration_seconds_bucket{api="accounts",operation="Fetch",le="+Inf"} 1
+importer_account_client_operation_duration_seconds_sum{api="accounts",operation="Fetch"} 0.05
+importer_account_client_operation_duration_seconds_count{api="accounts",operation="Fetch"} 1
+`
+			Ω(testutil.GatherAndCompare(registry, strings.NewReader(expected), "importer_account_client_operation_duration_seconds")).Should(Succeed())
+		})
+
+		It("should count requests, hedged requests and bytes by operation", func() {
+			Ω(testutil.GatherAndCount(registry, "importer_account_client_requests_total")).Should(Equal(2))
+			Ω(testutil.GatherAndCount(registry, "importer_account_client_hedged_requests_total")).Should(Equal(2))
+			Ω(testutil.GatherAndCount(registry, "importer_account_client_sent_bytes_total")).Should(Equal(2))
+			Ω(testutil.GatherAndCount(registry, "importer_account_client_received_bytes_total")).Should(Equal(2))
+		})
+	})
+
+	Context("when no operation was observed", func() {
+
+		It("should not expose any series", func() {
+			Ω(testutil.CollectAndCount(observer)).Should(Equal(0))
+		})
+	})
+})
@@ -1,5 +1,7 @@
 package apiclient
 
+import "time"
+
 // AccountResource holds all information about Account
 type AccountResource struct {
 	Type           string             `json:"type"`                 // value "accounts"
@@ -10,6 +12,8 @@ type AccountResource struct {
 }
 
 // AccountAttributes specific to Account resource
+//
+// Fields holding personal data are tagged with `pii:"true"`, they are redacted in logs (see `Redact`).
 type AccountAttributes struct {
 	Country                 string                 `json:"country"` // required
 	BaseCurrency            *string                `json:"base_currency,omitempty"`
@@ -26,26 +30,108 @@ type AccountAttributes struct {
 	AccountMatchingOptOut   *bool                  `json:"account_matching_opt_out,omitempty"`
 	SecondaryIdentification *string                `json:"secondary_identification,omitempty"`
 	Switched                *bool                  `json:"switched,omitempty"`
-	PrivateIdentification   *PrivateIdentification `json:"private_identification,omitempty"`
+	PrivateIdentification   *PrivateIdentification `json:"private_identification,omitempty" pii:"true"`
 	Status                  *string                `json:"status"`
 }
 
-// PrivateIdentification holds information about Account holder
+// PrivateIdentification holds information about Account holder, all of it is personal data
 type PrivateIdentification struct {
-	BirthDate      *string  `json:"birth_date,omitempty"`
-	BirthCountry   *string  `json:"birth_country,omitempty"`
-	Identification string   `json:"identification"` // required
-	Address        []string `json:"address,omitempty"`
-	City           *string  `json:"city,omitempty"`
-	Country        *string  `json:"country,omitempty"`
+	BirthDate      *string  `json:"birth_date,omitempty" pii:"true"`
+	BirthCountry   *string  `json:"birth_country,omitempty" pii:"true"`
+	Identification string   `json:"identification" pii:"true"` // required
+	Address        []string `json:"address,omitempty" pii:"true"`
+	City           *string  `json:"city,omitempty" pii:"true"`
+	Country        *string  `json:"country,omitempty" pii:"true"`
+}
+
+// NewAccount is an account sent to Accounts API to be created
+type NewAccount struct {
+	Type           string             `json:"type"`
+	ID             string             `json:"id"`
+	OrganisationID string             `json:"organisation_id"`
+	Attributes     *AccountAttributes `json:"attributes"`
 }
 
 // Helper struct for Create action
 type CreateAccountResourceRequestData struct {
-	Data struct {
-		Type           string             `json:"type"`
-		ID             string             `json:"id"`
-		OrganisationID string             `json:"organisation_id"`
-		Attributes     *AccountAttributes `json:"attributes"`
-	} `json:"data"`
+	Data NewAccount `json:"data"`
+}
+
+// Helper struct for CreateBatch action
+type CreateAccountBatchRequestData struct {
+	Data []NewAccount `json:"data"`
+}
+
+// AccountBatchResult is a result of creating one account of a batch
+type AccountBatchResult struct {
+	Index  int                `json:"index"`           // position of the account in the batch
+	ID     string             `json:"id"`              // account ID
+	Status int                `json:"status"`          // 201 when the account was created, otherwise status code of the error, e.g. 409 when it already exists
+	Data   *AccountResource   `json:"data,omitempty"`  // created account
+	Error  *AccountBatchError `json:"error,omitempty"` // why the account was not created
+}
+
+// AccountBatchError describes why an account of a batch was not created
+type AccountBatchError struct {
+	Code    string `json:"code"` // e.g. `invalid_account`, `account_exists`, `forbidden` or `batch_failed`
+	Message string `json:"message"`
+}
+
+// ImportSummary is a result of importing accounts with `AccountClient.Import`
+type ImportSummary struct {
+	ID       string `json:"id"`       // import ID
+	Conflict string `json:"conflict"` // policy of accounts which already exist: skip, fail or overwrite-if-newer
+	Received int    `json:"received"` // number of accounts in the file
+	Imported int    `json:"imported"` // number of created or overwritten accounts
+	Skipped  int    `json:"skipped"`  // number of accounts not changed because they already exist, or because the import failed
+	Rejected int    `json:"rejected"` // number of accounts which were not imported because of errors
+	Report   string `json:"report"`   // path of the report with skipped and rejected accounts
+}
+
+// ImportRejection is a line of the import file which was not imported, returned in the rejection report
+type ImportRejection struct {
+	Line    int    `json:"line"` // line number in the import file, starting with 1
+	ID      string `json:"id,omitempty"`
+	Code    string `json:"code"` // e.g. `invalid_account`, `forbidden`, `duplicate`, `account_exists` or `skipped`
+	Message string `json:"message"`
+}
+
+// Job is an import or export run asynchronously by Accounts API, see `AccountClient.SubmitJob`
+type Job struct {
+	ID         string         `json:"id"`
+	Type       JobType        `json:"type"`
+	Status     JobStatus      `json:"status"`
+	Processed  int64          `json:"processed"`         // lines of the import file read, or accounts exported so far
+	Error      string         `json:"error,omitempty"`   // why the job failed
+	Summary    *ImportSummary `json:"summary,omitempty"` // summary of the finished import
+	Result     string         `json:"result,omitempty"`  // path of the result of the succeeded job
+	CreatedOn  time.Time      `json:"created_on"`
+	StartedOn  *time.Time     `json:"started_on,omitempty"`
+	FinishedOn *time.Time     `json:"finished_on,omitempty"`
+}
+
+// JobType is the operation run by the job
+type JobType string
+
+const (
+	// JobImport imports newline-delimited accounts, like `AccountClient.Import`
+	JobImport JobType = "import"
+	// JobExport exports accounts, like `AccountClient.Export`
+	JobExport JobType = "export"
+)
+
+// JobStatus is the state of the job, jobs start queued and end succeeded, failed or cancelled
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Finished checks if the job will not change anymore
+func (s JobStatus) Finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
 }
@@ -0,0 +1,121 @@
+package apiclient
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryConfig configures retries of requests rejected by Account API because of rate limits (429 status code),
+// and of reads rejected because the server is temporarily unavailable (503 status code).
+//
+// Time to wait is taken from `Retry-After` header, or `RateLimit-Reset` header when no requests are remaining.
+// When the response has neither of them the client waits `Backoff`, doubled after every attempt.
+type RetryConfig struct {
+	MaxAttempts int           // Number of attempts including the first one, 3 when not set
+	Backoff     time.Duration // Wait before the second attempt when the server does not say how long to wait, 100ms when not set
+	MaxWait     time.Duration // Requests are not retried when the server asks to wait longer, 10s when not set
+}
+
+func (c *RetryConfig) maxAttempts() int {
+	if c.MaxAttempts <= 0 {
+		return 3
+	}
+	return c.MaxAttempts
+}
+
+func (c *RetryConfig) backoff() time.Duration {
+	if c.Backoff <= 0 {
+		return 100 * time.Millisecond
+	}
+	return c.Backoff
+}
+
+func (c *RetryConfig) maxWait() time.Duration {
+	if c.MaxWait <= 0 {
+		return 10 * time.Second
+	}
+	return c.MaxWait
+}
+
+// retryTransport sends the request again after 429 and 503 responses
+type retryTransport struct {
+	base   http.RoundTripper
+	config *RetryConfig
+	now    func() time.Time
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attemptReq := req
+	backoff := t.config.backoff()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil || attempt >= t.config.maxAttempts() || !retryable(req, resp) {
+			return resp, err
+		}
+		wait, ok := retryAfter(resp, t.now())
+		if !ok {
+			wait = backoff
+			backoff *= 2
+		}
+		if wait > t.config.maxWait() {
+			return resp, nil
+		}
+		// the request body has been already consumed and cannot be sent again
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+		var body io.ReadCloser
+		if req.GetBody != nil {
+			if body, err = req.GetBody(); err != nil {
+				discardResponse(resp)
+				return nil, err
+			}
+		}
+		discardResponse(resp)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		attemptReq = req.Clone(req.Context())
+		attemptReq.Body = body
+	}
+}
+
+// retryable checks if the request can be sent again, requests rejected because of rate limits were not handled by the server,
+// and only reads are repeated after 503 because the server could have handled a part of the request
+func retryable(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return req.Method == http.MethodGet
+	}
+	return false
+}
+
+// retryAfter returns how long the server asked to wait before the next request
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			if wait := date.Sub(now); wait > 0 {
+				return wait, true
+			}
+			return 0, true
+		}
+	}
+	if resp.Header.Get("RateLimit-Remaining") == "0" {
+		if seconds, err := strconv.Atoi(resp.Header.Get("RateLimit-Reset")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
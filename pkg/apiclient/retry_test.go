@@ -0,0 +1,132 @@
+package apiclient_test
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with retries", func() {
+	type accountResponse struct {
+		Data apiclient.AccountResource `json:"data"`
+	}
+
+	var (
+		server              *ghttp.Server
+		accountsPath        string
+		accountClientConfig apiclient.AccountClientConfig
+		accountClient       *apiclient.AccountClient
+		accountID           string
+		organisationID      string
+		attributes          *apiclient.AccountAttributes
+		rateLimited         http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		accountsPath = "/v1/accounts"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = accountsPath
+		accountClientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+			Retry:   &apiclient.RetryConfig{MaxAttempts: 3, Backoff: time.Millisecond, MaxWait: time.Second},
+		}
+		accountClient = apiclient.NewAccountClient(&accountClientConfig)
+		accountID = libtest.GenerateID()
+		organisationID = libtest.GenerateOrganisationID()
+		attributes = libtest.GenerateAccountAttributes()
+		rateLimited = ghttp.RespondWith(http.StatusTooManyRequests, nil, http.Header{"Retry-After": []string{"0"}})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when request is rate limited once", func() {
+
+		It("should send the request again", func() {
+			request := apiclient.CreateAccountResourceRequestData{}
+			request.Data.Type = "accounts"
+			request.Data.ID = accountID
+			request.Data.OrganisationID = organisationID
+			request.Data.Attributes = attributes
+			server.AppendHandlers(
+				ghttp.CombineHandlers(ghttp.VerifyRequest("POST", accountsPath), rateLimited),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", accountsPath),
+					ghttp.VerifyJSONRepresenting(request),
+					ghttp.RespondWithJSONEncoded(http.StatusCreated, accountResponse{Data: apiclient.AccountResource{ID: accountID, OrganisationID: organisationID, Attributes: attributes}}),
+				),
+			)
+			created, err := accountClient.Create(accountID, organisationID, attributes)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(created.ID).Should(Equal(accountID))
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("when every attempt is rate limited", func() {
+
+		It("should return ErrRateLimited after all attempts", func() {
+			server.AppendHandlers(rateLimited, rateLimited, rateLimited)
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrRateLimited))
+			Ω(server.ReceivedRequests()).Should(HaveLen(3))
+		})
+	})
+
+	Context("when server asks to wait longer than MaxWait", func() {
+
+		It("should not retry", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusTooManyRequests, nil, http.Header{"Retry-After": []string{"60"}}))
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrRateLimited))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when server is unavailable", func() {
+
+		It("should retry reads", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, nil),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", path.Join(accountsPath, accountID)),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, accountResponse{Data: apiclient.AccountResource{ID: accountID}}),
+				),
+			)
+			account, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(account.ID).Should(Equal(accountID))
+			Ω(server.ReceivedRequests()).Should(HaveLen(2))
+		})
+
+		It("should not retry deletes", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, nil))
+			_, err := accountClient.Delete(accountID, 0)
+			Ω(err).Should(MatchError(apiclient.ErrInternal))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when retries are not configured", func() {
+
+		It("should return ErrRateLimited", func() {
+			accountClientConfig.Retry = nil
+			accountClient = apiclient.NewAccountClient(&accountClientConfig)
+			server.AppendHandlers(rateLimited)
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrRateLimited))
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,84 @@
+package apiclient
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+)
+
+// RequestSigningConfig is a configuration of HMAC request signing.
+//
+// Every request is signed with the shared secret: method, path, query, body digest and creation time are covered by the signature.
+type RequestSigningConfig struct {
+	KeyID  string // Key identifier known to Account API
+	Secret []byte // Secret shared with Account API
+}
+
+// signingTransport adds HTTP Message Signatures headers to every request
+type signingTransport struct {
+	base   http.RoundTripper
+	signer *httpsig.Signer
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if seekable, ok := req.Body.(*seekableBody); ok {
+		return t.roundTripSeekable(req, seekable)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	signed := req.Clone(req.Context())
+	if req.Body != nil {
+		signed.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err := t.signer.Sign(signed, body); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// roundTripSeekable reads the body twice, to sign its digest and to send it, so uploads are not kept in memory
+func (t *signingTransport) roundTripSeekable(req *http.Request, body *seekableBody) (*http.Response, error) {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := httpsig.DigestReader(body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = body.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	signed := req.Clone(req.Context())
+	if err := t.signer.SignDigest(signed, digest); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// seekableBody is a request body which is not buffered, the signature of a seekable body is created without buffering it either
+type seekableBody struct {
+	io.ReadSeeker
+}
+
+func (b *seekableBody) Close() error {
+	return nil
+}
+
+// uploadBody wraps the reader uploaded by the operation, e.g. an import file
+func uploadBody(r io.Reader) io.ReadCloser {
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		return &seekableBody{ReadSeeker: seeker}
+	}
+	return ioutil.NopCloser(r)
+}
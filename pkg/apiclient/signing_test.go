@@ -0,0 +1,163 @@
+package apiclient_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with request signing", func() {
+	var (
+		server        *ghttp.Server
+		fetchPath     string
+		clientConfig  apiclient.AccountClientConfig
+		accountClient *apiclient.AccountClient
+
+		accountID         string
+		organisationID    string
+		accountAttributes *apiclient.AccountAttributes
+		responseData      interface{}
+		verifiedKeys      []string
+
+		// verifySignature checks requests the same way apiserver does
+		verifySignature = func() http.HandlerFunc {
+			verifier := &httpsig.Verifier{
+				Keys: func(keyID string) ([]byte, bool) {
+					return []byte("shared-secret"), keyID == "key-1"
+				},
+				MaxSkew: time.Minute,
+				Nonces:  httpsig.NewNonceCache(),
+			}
+			return func(rw http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				Ω(err).ShouldNot(HaveOccurred())
+				keyID, err := verifier.Verify(r, body)
+				if err != nil {
+					rw.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				verifiedKeys = append(verifiedKeys, keyID)
+			}
+		}
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		fetchPath = "/v1/accounts"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = fetchPath
+		clientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+			RequestSigning: &apiclient.RequestSigningConfig{
+				KeyID:  "key-1",
+				Secret: []byte("shared-secret"),
+			},
+		}
+		accountClient = apiclient.NewAccountClient(&clientConfig)
+
+		accountID = libtest.GenerateID()
+		organisationID = libtest.GenerateOrganisationID()
+		accountAttributes = libtest.GenerateAccountAttributes()
+		responseData = map[string]interface{}{
+			"data": apiclient.AccountResource{
+				Type:           "accounts",
+				ID:             accountID,
+				OrganisationID: organisationID,
+				Attributes:     accountAttributes,
+			},
+		}
+		verifiedKeys = nil
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when the secret is shared with Server API", func() {
+
+		BeforeEach(func() {
+			verify := verifySignature()
+			server.AppendHandlers(
+				ghttp.CombineHandlers(verify, ghttp.RespondWithJSONEncoded(http.StatusCreated, responseData)),
+				ghttp.CombineHandlers(verify, ghttp.RespondWithJSONEncoded(http.StatusOK, responseData)),
+				ghttp.CombineHandlers(verify, ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"data": []interface{}{}})),
+				ghttp.CombineHandlers(verify, ghttp.RespondWith(http.StatusNoContent, nil)),
+			)
+		})
+
+		It("should sign every operation", func() {
+			_, err := accountClient.Create(accountID, organisationID, accountAttributes)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			accounts := accountClient.List(apiclient.FirstPage)
+			Ω(accounts.Next()).Should(BeFalse())
+			deleted, err := accountClient.Delete(accountID, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(deleted).Should(BeTrue())
+
+			Ω(server.ReceivedRequests()).Should(HaveLen(4))
+			Ω(verifiedKeys).Should(Equal([]string{"key-1", "key-1", "key-1", "key-1"}))
+		})
+	})
+
+	Context("when the import file is seekable", func() {
+		var importFile string
+
+		BeforeEach(func() {
+			importFile = `{"id": "` + accountID + `"}` + "\n"
+			verifier := &httpsig.Verifier{
+				Keys: func(keyID string) ([]byte, bool) {
+					return []byte("shared-secret"), keyID == "key-1"
+				},
+				MaxSkew: time.Minute,
+			}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					func(rw http.ResponseWriter, r *http.Request) {
+						_, err := verifier.VerifyStream(r)
+						Ω(err).ShouldNot(HaveOccurred())
+						body, err := ioutil.ReadAll(r.Body)
+						Ω(err).ShouldNot(HaveOccurred())
+						Ω(string(body)).Should(Equal(importFile))
+					},
+					ghttp.RespondWithJSONEncoded(http.StatusCreated, map[string]interface{}{"data": apiclient.ImportSummary{ID: "import-1", Received: 1, Imported: 1}}),
+				),
+			)
+		})
+
+		It("should sign its digest and send it unchanged", func() {
+			summary, err := accountClient.Import(context.Background(), strings.NewReader(importFile), apiclient.ImportSkip)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(summary.Imported).Should(Equal(1))
+		})
+	})
+
+	Context("when Server API does not know the key", func() {
+
+		BeforeEach(func() {
+			clientConfig.RequestSigning.KeyID = "key-2"
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+			server.AppendHandlers(verifySignature())
+		})
+
+		It("should return ErrUnauthorized", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrUnauthorized))
+			Ω(accountData).Should(BeNil())
+			Ω(verifiedKeys).Should(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,46 @@
+package apiclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig is a configuration of TLS connections to Account API
+type TLSConfig struct {
+	CAFile     string // PEM encoded CA bundle used to verify Account API certificate, system roots are used when not set
+	CertFile   string // PEM encoded client certificate, sent when Account API requires mutual TLS
+	KeyFile    string // PEM encoded private key of the client certificate
+	MinVersion uint16 // Minimum TLS version, e.g. `tls.VersionTLS13`, TLS 1.2 when not set
+	ServerName string // Name used to verify Account API certificate, host from `AccountClientConfig.URL` when not set
+}
+
+// clientTLSConfig loads certificates and creates `tls.Config` used by the HTTP transport
+func (c *TLSConfig) clientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: c.MinVersion,
+		ServerName: c.ServerName,
+	}
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	if c.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates in CA bundle %v", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
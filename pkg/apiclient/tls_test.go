@@ -0,0 +1,166 @@
+package apiclient_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("The AccountClient with mutual TLS", func() {
+	var (
+		server        *ghttp.Server
+		certificates  *libtest.TestCertificates
+		fetchPath     string
+		clientConfig  apiclient.AccountClientConfig
+		accountClient *apiclient.AccountClient
+
+		accountID    string
+		responseData interface{}
+		peerSubjects []string
+	)
+
+	BeforeEach(func() {
+		certificates = libtest.GenerateTestCertificates("client-1")
+		server = ghttp.NewUnstartedServer()
+		server.HTTPTestServer.TLS = &tls.Config{
+			Certificates: []tls.Certificate{certificates.ServerCertificate},
+			ClientCAs:    certificates.CAPool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MaxVersion:   tls.VersionTLS12,
+		}
+		server.HTTPTestServer.StartTLS()
+
+		fetchPath = "/v1/accounts"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = fetchPath
+		clientConfig = apiclient.AccountClientConfig{
+			URL:     serverURL.String(),
+			Timeout: time.Second,
+			TLS: &apiclient.TLSConfig{
+				CAFile:   certificates.CAFile,
+				CertFile: certificates.ClientCertFile,
+				KeyFile:  certificates.ClientKeyFile,
+			},
+		}
+		accountClient = apiclient.NewAccountClient(&clientConfig)
+
+		accountID = libtest.GenerateID()
+		responseData = map[string]interface{}{
+			"data": apiclient.AccountResource{
+				Type:           "accounts",
+				ID:             accountID,
+				OrganisationID: libtest.GenerateOrganisationID(),
+				Attributes:     libtest.GenerateAccountAttributes(),
+			},
+		}
+		peerSubjects = nil
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", path.Join(fetchPath, accountID)),
+			func(rw http.ResponseWriter, r *http.Request) {
+				for _, cert := range r.TLS.PeerCertificates {
+					peerSubjects = append(peerSubjects, cert.Subject.CommonName)
+				}
+			},
+			ghttp.RespondWithJSONEncoded(http.StatusOK, responseData),
+		))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		certificates.Remove()
+	})
+
+	Context("when client certificate is configured", func() {
+
+		It("should present it to Server API", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(accountData.ID).Should(Equal(accountID))
+			Ω(peerSubjects).Should(Equal([]string{"client-1"}))
+		})
+	})
+
+	Context("when client certificate is not configured", func() {
+
+		BeforeEach(func() {
+			clientConfig.TLS.CertFile = ""
+			clientConfig.TLS.KeyFile = ""
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+		})
+
+		It("should return ErrConnection", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrConnection))
+			Ω(accountData).Should(BeNil())
+			Ω(server.ReceivedRequests()).Should(HaveLen(0))
+		})
+	})
+
+	Context("when Server API certificate is not signed by trusted CA", func() {
+
+		BeforeEach(func() {
+			// system roots do not contain the test CA
+			clientConfig.TLS.CAFile = ""
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+		})
+
+		It("should return ErrConnection", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrConnection))
+			Ω(accountData).Should(BeNil())
+			Ω(server.ReceivedRequests()).Should(HaveLen(0))
+		})
+	})
+
+	Context("when Server API does not support minimum TLS version", func() {
+
+		BeforeEach(func() {
+			clientConfig.TLS.MinVersion = tls.VersionTLS13
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+		})
+
+		It("should return ErrConnection", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrConnection))
+			Ω(accountData).Should(BeNil())
+		})
+	})
+
+	Context("when server name does not match Server API certificate", func() {
+
+		BeforeEach(func() {
+			clientConfig.TLS.ServerName = "accounts.example.com"
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+		})
+
+		It("should return ErrConnection", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrConnection))
+			Ω(accountData).Should(BeNil())
+		})
+	})
+
+	Context("when certificate files cannot be loaded", func() {
+
+		BeforeEach(func() {
+			clientConfig.TLS.KeyFile = clientConfig.TLS.CAFile
+			accountClient = apiclient.NewAccountClient(&clientConfig)
+		})
+
+		It("should return ErrWrongConfig without calling Server API", func() {
+			accountData, err := accountClient.Fetch(accountID)
+			Ω(err).Should(MatchError(apiclient.ErrWrongConfig))
+			Ω(accountData).Should(BeNil())
+			Ω(server.ReceivedRequests()).Should(HaveLen(0))
+		})
+	})
+})
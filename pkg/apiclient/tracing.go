@@ -0,0 +1,68 @@
+package apiclient
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies spans created by the client
+const instrumentationName = "github.com/fkondej/go-showcase/v1/pkg/apiclient"
+
+// startSpan starts a client span around the operation, when tracing is not configured the span is a no-op
+func (client *AccountClient) startSpan(ctx context.Context, operation string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	return client.tracer.Start(ctx, "AccountClient."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// endSpan records the operation error and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// pageAttributes describes the requested page and its filters
+func pageAttributes(page AccountPage) []label.KeyValue {
+	attributes := []label.KeyValue{
+		label.Int("account.page.number", page.PageNumber),
+		label.Int("account.page.size", page.PageSize),
+	}
+	attributes = append(attributes, FilterAttributes(page.Filter)...)
+	if len(page.Sort) > 0 {
+		attributes = append(attributes, label.String("account.sort", sortValue(page.Sort)))
+	}
+	return attributes
+}
+
+// personalFilters are filters of personal data, their values are not recorded in spans
+var personalFilters = map[string]bool{"account_number": true, "customer_id": true, "iban": true, "name_prefix": true}
+
+// FilterAttributes describes filters which are set as `account.filter.<name>` span attributes, ordered by name.
+// Only the number of values of personal data filters is recorded, e.g. `account.filter.iban.count`.
+// Accounts API uses it too, so spans of the client and the server have the same attributes.
+func FilterAttributes(filter AccountListFilter) []label.KeyValue {
+	values := filter.QueryValues()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	attributes := make([]label.KeyValue, 0, len(names))
+	for _, name := range names {
+		if personalFilters[name] {
+			attributes = append(attributes, label.Int("account.filter."+name+".count", len(values[name])))
+			continue
+		}
+		attributes = append(attributes, label.String("account.filter."+name, strings.Join(values[name], ",")))
+	}
+	return attributes
+}
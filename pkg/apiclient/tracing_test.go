@@ -0,0 +1,172 @@
+package apiclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/libtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("The AccountClient with tracing", func() {
+	var (
+		server        *ghttp.Server
+		fetchPath     string
+		exporter      *tracetest.InMemoryExporter
+		provider      *sdktrace.TracerProvider
+		accountClient *apiclient.AccountClient
+
+		accountID    string
+		responseData interface{}
+
+		// spanAttributes returns attributes of a span as a map
+		spanAttributes = func(attributes []label.KeyValue) map[label.Key]string {
+			result := map[label.Key]string{}
+			for _, attribute := range attributes {
+				result[attribute.Key] = attribute.Value.Emit()
+			}
+			return result
+		}
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		fetchPath = "/v1/accounts"
+		serverURL, err := url.Parse(server.URL())
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL.Path = fetchPath
+
+		exporter = tracetest.NewInMemoryExporter()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		accountClient = apiclient.NewAccountClient(&apiclient.AccountClientConfig{
+			URL:            serverURL.String(),
+			Timeout:        time.Second,
+			TracerProvider: provider,
+		})
+
+		accountID = libtest.GenerateID()
+		responseData = map[string]interface{}{
+			"data": apiclient.AccountResource{
+				Type:           "accounts",
+				ID:             accountID,
+				OrganisationID: libtest.GenerateOrganisationID(),
+				Attributes:     libtest.GenerateAccountAttributes(),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when operation is called within a span", func() {
+
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, responseData))
+		})
+
+		It("should record a child span and propagate trace context to Server API", func() {
+			ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+			_, err := accountClient.FetchWithContext(ctx, accountID)
+			parent.End()
+			Ω(err).ShouldNot(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(2))
+			span := spans[0]
+			Ω(span.Name).Should(Equal("AccountClient.Fetch"))
+			Ω(span.SpanKind).Should(Equal(trace.SpanKindClient))
+			Ω(span.ParentSpanID).Should(Equal(parent.SpanContext().SpanID))
+			Ω(span.SpanContext.TraceID).Should(Equal(parent.SpanContext().TraceID))
+			Ω(span.StatusCode).Should(Equal(codes.Unset))
+			attributes := spanAttributes(span.Attributes)
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("account.id"), accountID))
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("http.status_code"), "200"))
+
+			Ω(server.ReceivedRequests()).Should(HaveLen(1))
+			traceParent := server.ReceivedRequests()[0].Header.Get("traceparent")
+			Ω(traceParent).Should(ContainSubstring(span.SpanContext.TraceID.String()))
+			Ω(traceParent).Should(ContainSubstring(span.SpanContext.SpanID.String()))
+		})
+	})
+
+	Context("when listing accounts with filters", func() {
+
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"data": []interface{}{},
+			}))
+		})
+
+		It("should record a span for each page with filter attributes", func() {
+			pageResult := accountClient.List(apiclient.AccountPage{
+				PageNumber: 2,
+				PageSize:   10,
+				Filter:     apiclient.AccountListFilter{Country: []string{"GB", "PL"}},
+			})
+			Ω(pageResult.Next()).Should(BeFalse())
+			_, err := pageResult.Data()
+			Ω(err).Should(MatchError(apiclient.ErrNoAccount))
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(1))
+			Ω(spans[0].Name).Should(Equal("AccountClient.List"))
+			Ω(spans[0].StatusCode).Should(Equal(codes.Unset))
+			attributes := spanAttributes(spans[0].Attributes)
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("account.page.number"), "2"))
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("account.page.size"), "10"))
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("account.filter.country"), "GB,PL"))
+		})
+
+		It("should record only the number of personal data filter values", func() {
+			pageResult := accountClient.List(apiclient.AccountPage{
+				PageSize: 10,
+				Filter: apiclient.AccountListFilter{
+					IBAN:       []string{"GB11NWBK40030041426819", "GB22NWBK40030041426819"},
+					CustomerID: []string{"customer-1"},
+					Name:       "Sam",
+				},
+			})
+			Ω(pageResult.Next()).Should(BeFalse())
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(1))
+			attributes := spanAttributes(spans[0].Attributes)
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("account.filter.iban.count"), "2"))
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("account.filter.customer_id.count"), "1"))
+			Ω(attributes).Should(HaveKeyWithValue(label.Key("account.filter.name_prefix.count"), "1"))
+			for _, attribute := range spans[0].Attributes {
+				Ω(attribute.Value.Emit()).ShouldNot(ContainSubstring("NWBK"))
+				Ω(attribute.Value.Emit()).ShouldNot(ContainSubstring("customer-1"))
+				Ω(attribute.Value.Emit()).ShouldNot(ContainSubstring("Sam"))
+			}
+		})
+	})
+
+	Context("when Server API returns an error", func() {
+
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, nil))
+		})
+
+		It("should mark the span as failed", func() {
+			_, err := accountClient.Fetch(accountID)
+			Ω(err).Should(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(1))
+			Ω(spans[0].Name).Should(Equal("AccountClient.Fetch"))
+			Ω(spans[0].StatusCode).Should(Equal(codes.Error))
+		})
+	})
+})
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// exportPath is the account ID segment routed to exports, gin cannot route a static segment next to the account ID
+	exportPath = "export"
+	// exportFetchSize is number of accounts fetched from the export cursor at once
+	exportFetchSize = 500
+	// exportErrorTrailer is sent after the exported accounts when the export failed after the status code was sent
+	exportErrorTrailer = "X-Export-Error"
+)
+
+//
+// routes
+//
+
+// exportAccounts streams all accounts matching `filter[...]` query parameters as newline-delimited JSON or CSV (`format` query parameter).
+// Attributes can be selected with `fields[accounts]`, and accounts ordered with `sort` like in the list route.
+func (ar *accountRouter) exportAccounts(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in format query parameter, should be ndjson or csv"})
+		return
+	}
+	fields, err := parseFieldSelection(c.Query("fields[accounts]"), hasScope(c, scopePIIRead))
+	if err == errPIINotAllowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
+	}
+	filter, err := parseAccountFilter(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
+	}
+	if !scopeFilter(c, &filter) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "Not allowed to export accounts of other organisations"})
+		return
+	}
+	sorts, err := parseAccountSort(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
+	}
+	writer := newExportWriter(c.Writer, format, fields, func() {
+		contentType, extension := exportContentType(format)
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="accounts.%v"`, extension))
+		c.Header("Trailer", exportErrorTrailer)
+		c.Status(http.StatusOK)
+	})
+	exported, err := ar.accountService.exportAccounts(c.Request.Context(), filter, sorts, writer.write)
+	if err == nil {
+		// an export without accounts is started here, e.g. to send the CSV header
+		writer.start()
+		err = writer.flush()
+	}
+	logger := loggerFrom(c.Request.Context(), ar.logger).With("format", format, "exported", exported)
+	if err != nil && !writer.started {
+		logger.Error("Export failed", "error", err)
+		abortWithStoreError(c, err, "Problem exporting accounts from storage")
+		return
+	}
+	if err != nil {
+		// the status code has been already sent, the trailer tells the client that the export is not complete
+		logger.Error("Export failed after sending accounts", "error", err)
+		c.Writer.Header().Set(exportErrorTrailer, "Export failed, accounts are missing")
+		return
+	}
+	logger.Info("Exported accounts")
+}
+
+// exportWriter writes exported accounts in the format, `begin` is called before the first account, e.g. to send the status code
+type exportWriter struct {
+	w       io.Writer
+	begin   func()
+	fields  *fieldSelection
+	columns []csvColumn
+	csv     *csv.Writer
+	started bool
+}
+
+func newExportWriter(w io.Writer, format string, fields *fieldSelection, begin func()) *exportWriter {
+	writer := &exportWriter{w: w, begin: begin, fields: fields}
+	if format == "csv" {
+		writer.columns = fields.csvColumns()
+		writer.csv = csv.NewWriter(w)
+	}
+	return writer
+}
+
+// exportContentType returns content type and file extension of the format
+func exportContentType(format string) (string, string) {
+	if format == "csv" {
+		return "text/csv", "csv"
+	}
+	return "application/x-ndjson", "ndjson"
+}
+
+// start is called before the first account, or after the export when there were no accounts
+func (w *exportWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if w.begin != nil {
+		w.begin()
+	}
+	if w.csv != nil {
+		header := make([]string, len(w.columns))
+		for i, column := range w.columns {
+			header[i] = column.name
+		}
+		w.csv.Write(header)
+	}
+}
+
+func (w *exportWriter) write(account apiclient.AccountResource) error {
+	w.start()
+	if w.csv != nil {
+		record := make([]string, len(w.columns))
+		for i, column := range w.columns {
+			record[i] = column.value(&account)
+		}
+		return w.csv.Write(record)
+	}
+	view, err := w.fields.view(account)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(view)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(encoded, '\n'))
+	return err
+}
+
+func (w *exportWriter) flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// csvColumn is a column of exported CSV, nested attributes are flattened, e.g. `name[0]` or `private_identification.city`
+type csvColumn struct {
+	name      string
+	attribute string // top level attribute, used to select columns with `fields[accounts]`, empty for columns always exported
+	value     func(account *apiclient.AccountResource) string
+}
+
+// csvColumns are all columns of exported CSV in order
+var csvColumns = accountCSVColumns()
+
+func accountCSVColumns() []csvColumn {
+	attribute := func(name string, value func(a *apiclient.AccountAttributes) string) csvColumn {
+		return csvColumn{name: name, attribute: strings.SplitN(strings.SplitN(name, ".", 2)[0], "[", 2)[0], value: func(account *apiclient.AccountResource) string {
+			if account.Attributes == nil {
+				return ""
+			}
+			return value(account.Attributes)
+		}}
+	}
+	private := func(name string, value func(p *apiclient.PrivateIdentification) string) csvColumn {
+		return attribute("private_identification."+name, func(a *apiclient.AccountAttributes) string {
+			if a.PrivateIdentification == nil {
+				return ""
+			}
+			return value(a.PrivateIdentification)
+		})
+	}
+	columns := []csvColumn{
+		{name: "id", value: func(account *apiclient.AccountResource) string { return account.ID }},
+		{name: "organisation_id", value: func(account *apiclient.AccountResource) string { return account.OrganisationID }},
+		{name: "version", value: func(account *apiclient.AccountResource) string { return strconv.Itoa(account.Version) }},
+		attribute("country", func(a *apiclient.AccountAttributes) string { return a.Country }),
+		attribute("base_currency", func(a *apiclient.AccountAttributes) string { return csvString(a.BaseCurrency) }),
+		attribute("account_number", func(a *apiclient.AccountAttributes) string { return csvString(a.AccountNumber) }),
+		attribute("bank_id", func(a *apiclient.AccountAttributes) string { return csvString(a.BankID) }),
+		attribute("bank_id_code", func(a *apiclient.AccountAttributes) string { return csvString(a.BankIDCode) }),
+		attribute("bic", func(a *apiclient.AccountAttributes) string { return csvString(a.BIC) }),
+		attribute("iban", func(a *apiclient.AccountAttributes) string { return csvString(a.IBAN) }),
+		attribute("customer_id", func(a *apiclient.AccountAttributes) string { return csvString(a.CustomerID) }),
+	}
+	for i := 0; i < 4; i++ {
+		i := i
+		columns = append(columns, attribute(fmt.Sprintf("name[%v]", i), func(a *apiclient.AccountAttributes) string { return a.Name[i] }))
+	}
+	for i := 0; i < 3; i++ {
+		i := i
+		columns = append(columns, attribute(fmt.Sprintf("alternative_names[%v]", i), func(a *apiclient.AccountAttributes) string {
+			if a.AlternativeNames == nil {
+				return ""
+			}
+			return a.AlternativeNames[i]
+		}))
+	}
+	return append(columns,
+		attribute("account_classification", func(a *apiclient.AccountAttributes) string { return csvString(a.AccountClassification) }),
+		attribute("joint_account", func(a *apiclient.AccountAttributes) string { return csvBool(a.JointAccount) }),
+		attribute("account_matching_opt_out", func(a *apiclient.AccountAttributes) string { return csvBool(a.AccountMatchingOptOut) }),
+		attribute("secondary_identification", func(a *apiclient.AccountAttributes) string { return csvString(a.SecondaryIdentification) }),
+		attribute("switched", func(a *apiclient.AccountAttributes) string { return csvBool(a.Switched) }),
+		attribute("status", func(a *apiclient.AccountAttributes) string { return csvString(a.Status) }),
+		private("birth_date", func(p *apiclient.PrivateIdentification) string { return csvString(p.BirthDate) }),
+		private("birth_country", func(p *apiclient.PrivateIdentification) string { return csvString(p.BirthCountry) }),
+		private("identification", func(p *apiclient.PrivateIdentification) string { return p.Identification }),
+		// address lines are joined, CSV quotes values with new lines
+		private("address", func(p *apiclient.PrivateIdentification) string { return strings.Join(p.Address, "\n") }),
+		private("city", func(p *apiclient.PrivateIdentification) string { return csvString(p.City) }),
+		private("country", func(p *apiclient.PrivateIdentification) string { return csvString(p.Country) }),
+	)
+}
+
+// csvColumns returns columns of the selected attributes, personal data only with `pii:read` scope
+func (fs *fieldSelection) csvColumns() []csvColumn {
+	columns := []csvColumn{}
+	for _, column := range csvColumns {
+		if column.attribute != "" && ((len(fs.fields) > 0 && !fs.fields[column.attribute]) || (accountAttributes[column.attribute] && !fs.includePII)) {
+			continue
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+func csvString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func csvBool(value *bool) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*value)
+}
+
+//
+// store
+//
+
+// exportAccounts reads accounts matching the filter with a server-side cursor, `exportFetchSize` accounts at once, and calls `fn` for every account.
+// Accounts are read from one snapshot, ordered by `sorts` and then by ID. It returns the number of exported accounts.
+func (s *AccountService) exportAccounts(ctx context.Context, filter apiclient.AccountListFilter, sorts []apiclient.Sort, fn func(account apiclient.AccountResource) error) (int, error) {
+	logger := loggerFrom(ctx, s.logger)
+
+	queryCtx, span := startQuerySpan(ctx, "exportAccounts", apiclient.FilterAttributes(filter)...)
+	start := time.Now()
+	exported, err := s.readExport(queryCtx, filter, sorts, fn)
+	s.metrics.observeQuery("exportAccounts", start, err)
+	span.SetAttributes(label.Int("account.export.count", exported))
+	endQuerySpan(span, err)
+	if err != nil {
+		logger.Error("Export accounts failed", "exported", exported, "error", err)
+		return exported, queryError(queryCtx, err, "Failed to export accounts from store")
+	}
+	return exported, nil
+}
+
+func (s *AccountService) readExport(ctx context.Context, filter apiclient.AccountListFilter, sorts []apiclient.Sort, fn func(account apiclient.AccountResource) error) (int, error) {
+	tx, err := s.dbConnPool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DECLARE account_export NO SCROLL CURSOR FOR
+		SELECT id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record, private_identification
+		FROM "Account"
+		WHERE `+accountFilterCondition(1)+`
+		`+accountOrderBy(sorts), accountFilterArgs(filter)...)
+	if err != nil {
+		return 0, err
+	}
+	exported := 0
+	for {
+		fetched, err := s.fetchExport(ctx, tx, func(account apiclient.AccountResource) error {
+			exported++
+			return fn(account)
+		})
+		if err != nil {
+			return exported, err
+		}
+		if fetched < exportFetchSize {
+			return exported, nil
+		}
+	}
+}
+
+// fetchExport reads the next accounts from the export cursor, it returns the number of accounts read
+func (s *AccountService) fetchExport(ctx context.Context, tx pgx.Tx, fn func(account apiclient.AccountResource) error) (int, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(`FETCH FORWARD %v FROM account_export`, exportFetchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	fetched := 0
+	for rows.Next() {
+		account := dbAccount{}
+		err = rows.Scan(&account.ID, &account.OrganisationID, &account.Version, &account.IsDeleted, &account.IsLocked, &account.CreatedOn, &account.ModifiedOn, &account.Record, &account.PrivateIdentification)
+		if err != nil {
+			return fetched, err
+		}
+		if err = s.decryptPrivateIdentification(&account); err != nil {
+			return fetched, fmt.Errorf("decrypting private identification of account %v: %v", account.ID, err)
+		}
+		fetched++
+		err = fn(apiclient.AccountResource{
+			Type:           "account",
+			ID:             account.ID.String(),
+			OrganisationID: account.OrganisationID.String(),
+			Version:        int(account.Version),
+			Attributes:     &account.Record,
+		})
+		if err != nil {
+			return fetched, err
+		}
+	}
+	return fetched, rows.Err()
+}
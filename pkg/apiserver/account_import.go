@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+)
+
+// importConflicts are policies of imported accounts which already exist:
+//   - skip - existing accounts are not changed,
+//   - fail - nothing is imported when any of the accounts exists,
+//   - overwrite-if-newer - existing accounts of the same organisation are replaced when the imported version is greater.
+var importConflicts = map[string]bool{"skip": true, "fail": true, "overwrite-if-newer": true}
+
+const (
+	// importReportRetention is how long summaries and rejection reports of imports are kept
+	importReportRetention = 7 * 24 * time.Hour
+	// importProgressLines is number of lines read between progress logs
+	importProgressLines = 10000
+	// maxImportLineSize limits size of one account in the import file
+	maxImportLineSize = 1 << 20
+)
+
+var (
+	// errInvalidImport is returned when the import file cannot be read, e.g. a line is too long
+	errInvalidImport = errors.New("invalid import file")
+	// errImportConflict is returned when nothing was imported because of existing accounts and `fail` conflict policy
+	errImportConflict = errors.New("imported accounts already exist")
+)
+
+//
+// routes
+//
+
+type importRouter struct {
+	accountService *AccountService
+	reportsPath    string // path of the routes group, used in links to rejection reports
+	logger         *Logger
+}
+
+// SetupImportRouting registers routes of account imports, `limiter` can be nil when requests are not limited
+func SetupImportRouting(router *gin.RouterGroup, accountService *AccountService, timeouts statementTimeouts, streams streamTimeout, limiter *rateLimiter, logger *Logger) {
+	ir := importRouter{
+		accountService: accountService,
+		reportsPath:    router.BasePath(),
+		logger:         logger,
+	}
+	// without the trailing slash, a redirect could not send the streamed body again
+	router.POST("", limiter.middleware("write"), timeouts.middleware("import"), streams.middleware(), ir.importAccounts)
+	router.GET("/:importId", limiter.middleware("read"), timeouts.middleware("fetch"), ir.getImport)
+	router.GET("/:importId/rejections", limiter.middleware("read"), timeouts.middleware("fetch"), ir.getImportRejections)
+}
+
+// importAccounts imports newline-delimited `AccountResource` JSON streamed in the request body.
+// It responds with the import summary, and a link to the report of lines which were not imported.
+func (ir *importRouter) importAccounts(c *gin.Context) {
+	conflict := c.DefaultQuery("conflict", "skip")
+	if !importConflicts[conflict] {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in conflict query parameter, should be skip, fail or overwrite-if-newer"})
+		return
+	}
+	authorize := func(organisationID string) bool { return canActOnBehalfOf(c, organisationID) }
+	summary, err := ir.accountService.importAccounts(c.Request.Context(), c.Request.Body, conflict, callerOrganisation(c), authorize)
+	if errors.Is(err, httpsig.ErrDigestMismatch) {
+		abortWithDigestMismatch(c, ir.logger, err)
+		return
+	}
+	if errors.Is(err, errInvalidImport) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
+	}
+	if err != nil && !errors.Is(err, errImportConflict) {
+		abortWithStoreError(c, err, "Problem importing accounts")
+		return
+	}
+	if summary.ID != "" {
+		summary.Report = fmt.Sprintf("%v/%v/rejections", ir.reportsPath, summary.ID)
+		c.Header("Location", fmt.Sprintf("%v/%v", ir.reportsPath, summary.ID))
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"status": "error", "code": "account_exists", "message": "Nothing was imported because some accounts already exist", "data": summary})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "data": summary})
+}
+
+func (ir *importRouter) getImport(c *gin.Context) {
+	summary, err := ir.accountService.getImport(c.Request.Context(), c.Param("importId"), callerOrganisation(c))
+	if err != nil {
+		abortWithStoreError(c, err, "Problem reading import from storage")
+		return
+	}
+	if summary == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "error", "message": "There is no import"})
+		return
+	}
+	summary.Report = fmt.Sprintf("%v/%v/rejections", ir.reportsPath, summary.ID)
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+// getImportRejections responds with newline-delimited `ImportRejection` JSON, ordered by line of the import file
+func (ir *importRouter) getImportRejections(c *gin.Context) {
+	importID := c.Param("importId")
+	summary, err := ir.accountService.getImport(c.Request.Context(), importID, callerOrganisation(c))
+	if err != nil {
+		abortWithStoreError(c, err, "Problem reading import from storage")
+		return
+	}
+	if summary == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "error", "message": "There is no import"})
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%v-rejections.ndjson"`, summary.ID))
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	err = ir.accountService.readImportRejections(c.Request.Context(), summary.ID, func(rejection apiclient.ImportRejection) error {
+		return encoder.Encode(rejection)
+	})
+	if err != nil {
+		// the status code has been already sent, the client sees a truncated report
+		loggerFrom(c.Request.Context(), ir.logger).Error("Sending import rejections failed", "import_id", summary.ID, "error", err)
+	}
+}
+
+//
+// store
+//
+
+// importAccounts copies accounts from newline-delimited JSON to a staging table with Postgres COPY,
+// and merges them into "Account" table with the conflict policy in one transaction.
+// The summary and lines which were not imported are saved as the import report in the same transaction.
+// It returns errImportConflict with the summary when nothing was imported because of `fail` conflict policy.
+func (s *AccountService) importAccounts(ctx context.Context, body io.Reader, conflict string, owner string, authorize func(organisationID string) bool) (*apiclient.ImportSummary, error) {
+	importID := uuid.New()
+	logger := loggerFrom(ctx, s.logger).With("import_id", importID.String(), "conflict", conflict)
+
+	queryCtx, span := startQuerySpan(ctx, "importAccounts", label.String("account.import.id", importID.String()), label.String("account.import.conflict", conflict))
+	start := time.Now()
+	summary, err := s.mergeImport(queryCtx, importID, owner, body, conflict, authorize, logger)
+	s.metrics.observeQuery("importAccounts", start, err)
+	endQuerySpan(span, err)
+	if err != nil && !errors.Is(err, errImportConflict) {
+		logger.Error("Import failed", "error", err)
+		if errors.Is(err, errInvalidImport) || errors.Is(err, httpsig.ErrDigestMismatch) {
+			return nil, err
+		}
+		return nil, queryError(queryCtx, err, "Failed to import accounts")
+	}
+	logger.Info("Imported accounts", "received", summary.Received, "imported", summary.Imported, "skipped", summary.Skipped, "rejected", summary.Rejected)
+	return summary, err
+}
+
+// mergeImport runs the import in one transaction and saves its report.
+// Lines which are not imported are kept in the staging table with the reason, so they are never held in memory.
+func (s *AccountService) mergeImport(ctx context.Context, importID uuid.UUID, owner string, body io.Reader, conflict string, authorize func(organisationID string) bool, logger *Logger) (*apiclient.ImportSummary, error) {
+	tx, err := s.dbConnPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// rows with `code` are rejected lines, `id` is NULL when the line is not a valid account
+	_, err = tx.Exec(ctx, `CREATE TEMPORARY TABLE "AccountImportStaging" (
+		line INTEGER PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		id UUID,
+		organisation_id UUID,
+		version INTEGER,
+		record jsonb,
+		private_identification jsonb,
+		code TEXT,
+		message TEXT
+	) ON COMMIT DROP`)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+	source := &importSource{scanner: scanner, authorize: authorize, encrypt: s.encryptPrivateIdentification, logger: logger}
+	copied, err := tx.CopyFrom(ctx, pgx.Identifier{"AccountImportStaging"},
+		[]string{"line", "account_id", "id", "organisation_id", "version", "record", "private_identification", "code", "message"}, source)
+	if errors.Is(err, bufio.ErrTooLong) {
+		return nil, fmt.Errorf("line %v is longer than %v bytes: %w", source.line+1, maxImportLineSize, errInvalidImport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary := &apiclient.ImportSummary{ID: importID.String(), Conflict: conflict, Received: source.received, Rejected: source.rejected}
+
+	// the line with the greatest version is imported, or the last one with the same version
+	duplicates, err := tx.Exec(ctx, `UPDATE "AccountImportStaging" s SET code = 'duplicate', message = $1 FROM (
+			SELECT line, row_number() OVER (PARTITION BY id ORDER BY version DESC, line DESC) AS n FROM "AccountImportStaging" WHERE code IS NULL
+		) d WHERE s.line = d.line AND d.n > 1`,
+		"Account is repeated in the file, the line with the greatest version is imported",
+	)
+	if err != nil {
+		return nil, err
+	}
+	summary.Rejected += int(duplicates.RowsAffected())
+	staged := int(copied) - summary.Rejected
+
+	if conflict == "fail" {
+		existing, err := tx.Exec(ctx, `UPDATE "AccountImportStaging" s SET code = 'account_exists', message = $1
+			FROM "Account" a WHERE a.id = s.id AND s.code IS NULL`,
+			"Account already exists, nothing was imported",
+		)
+		if err != nil {
+			return nil, err
+		}
+		if existing.RowsAffected() > 0 {
+			summary.Rejected += int(existing.RowsAffected())
+			summary.Skipped = staged - int(existing.RowsAffected())
+			if err = s.saveImportReport(ctx, tx, importID, owner, summary); err != nil {
+				return nil, err
+			}
+			if err = tx.Commit(ctx); err != nil {
+				return nil, err
+			}
+			return summary, errImportConflict
+		}
+	}
+
+	merge := `ON CONFLICT (id) DO NOTHING`
+	skippedMessage := "Account already exists"
+	if conflict == "overwrite-if-newer" {
+		merge = `ON CONFLICT (id) DO UPDATE SET
+				organisation_id = EXCLUDED.organisation_id, version = EXCLUDED.version, modified_on = current_timestamp,
+				record = EXCLUDED.record, private_identification = EXCLUDED.private_identification
+			WHERE "Account".version < EXCLUDED.version AND "Account".organisation_id = EXCLUDED.organisation_id`
+		skippedMessage = "Account already exists with the same or newer version, or in another organisation"
+	}
+	skipped, err := tx.Exec(ctx, `WITH merged AS (
+			INSERT INTO "Account" (id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record, private_identification)
+			SELECT id, organisation_id, version, FALSE, FALSE, current_timestamp, current_timestamp, record, private_identification FROM "AccountImportStaging"
+			WHERE code IS NULL
+			`+merge+`
+			RETURNING id
+		)
+		UPDATE "AccountImportStaging" SET code = 'skipped', message = $1 WHERE code IS NULL AND id NOT IN (SELECT id FROM merged)`,
+		skippedMessage,
+	)
+	if err != nil {
+		return nil, err
+	}
+	summary.Skipped = int(skipped.RowsAffected())
+	summary.Imported = staged - summary.Skipped
+	if err = s.saveImportReport(ctx, tx, importID, owner, summary); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// saveImportReport saves the summary and lines of the staging table which were not imported, reports older than importReportRetention are removed
+func (s *AccountService) saveImportReport(ctx context.Context, tx pgx.Tx, importID uuid.UUID, owner string, summary *apiclient.ImportSummary) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM "AccountImport" WHERE created_on < $1`, time.Now().Add(-importReportRetention)); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx,
+		`INSERT INTO "AccountImport" (id, owner, conflict, received, imported, skipped, rejected) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		importID, owner, summary.Conflict, summary.Received, summary.Imported, summary.Skipped, summary.Rejected,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO "AccountImportRejection" (import_id, line, account_id, code, message)
+			SELECT $1, line, account_id, code, message FROM "AccountImportStaging" WHERE code IS NOT NULL`,
+		importID,
+	)
+	return err
+}
+
+// getImport returns the import summary, or nil when there is no import of the owner
+func (s *AccountService) getImport(ctx context.Context, importID string, owner string) (*apiclient.ImportSummary, error) {
+	id, err := uuid.Parse(importID)
+	if err != nil {
+		return nil, nil
+	}
+	ctx, span := startQuerySpan(ctx, "getImport", label.String("account.import.id", importID))
+	defer span.End()
+	start := time.Now()
+	summary := apiclient.ImportSummary{ID: id.String()}
+	err = s.dbConnPool.QueryRow(ctx,
+		`SELECT conflict, received, imported, skipped, rejected FROM "AccountImport" WHERE id = $1 AND ($2 = '' OR lower(owner) = lower($2))`,
+		id, owner,
+	).Scan(&summary.Conflict, &summary.Received, &summary.Imported, &summary.Skipped, &summary.Rejected)
+	if err == pgx.ErrNoRows {
+		s.metrics.observeQuery("getImport", start, nil)
+		return nil, nil
+	}
+	s.metrics.observeQuery("getImport", start, err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		loggerFrom(ctx, s.logger).Error("Get import failed", "import_id", importID, "error", err)
+		return nil, queryError(ctx, err, "Failed to fetch import from store")
+	}
+	return &summary, nil
+}
+
+// readImportRejections calls `fn` for every rejection of the import, ordered by line. Errors of `fn` are returned as they are.
+func (s *AccountService) readImportRejections(ctx context.Context, importID string, fn func(rejection apiclient.ImportRejection) error) error {
+	queryCtx, span := startQuerySpan(ctx, "readImportRejections", label.String("account.import.id", importID))
+	start := time.Now()
+	var fnErr error
+	read, err := s.scanImportRejections(queryCtx, importID, func(rejection apiclient.ImportRejection) error {
+		fnErr = fn(rejection)
+		return fnErr
+	})
+	if err != nil && err == fnErr {
+		err = nil
+	}
+	s.metrics.observeQuery("readImportRejections", start, err)
+	span.SetAttributes(label.Int("account.import.rejections", read))
+	endQuerySpan(span, err)
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("Read import rejections failed", "import_id", importID, "error", err)
+		return queryError(queryCtx, err, "Failed to fetch import rejections from store")
+	}
+	return fnErr
+}
+
+// scanImportRejections returns the number of rejections passed to `fn`
+func (s *AccountService) scanImportRejections(ctx context.Context, importID string, fn func(rejection apiclient.ImportRejection) error) (int, error) {
+	read := 0
+	rows, err := s.dbConnPool.Query(ctx,
+		`SELECT line, account_id, code, message FROM "AccountImportRejection" WHERE import_id = $1 ORDER BY line`, importID)
+	if err != nil {
+		return read, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rejection := apiclient.ImportRejection{}
+		if err = rows.Scan(&rejection.Line, &rejection.ID, &rejection.Code, &rejection.Message); err != nil {
+			return read, err
+		}
+		if err = fn(rejection); err != nil {
+			return read, err
+		}
+		read++
+	}
+	return read, rows.Err()
+}
+
+// importSource reads accounts from lines of the import file for Postgres COPY,
+// lines which cannot be imported are copied too, with the code and the message of the rejection
+type importSource struct {
+	scanner   *bufio.Scanner
+	authorize func(organisationID string) bool
+	encrypt   func(id uuid.UUID, attributes *apiclient.AccountAttributes) (*apiclient.AccountAttributes, []byte, error)
+	logger    *Logger
+
+	line     int // number of the last read line
+	received int // number of lines with accounts, blank lines are ignored
+	rejected int // number of rejected lines
+	values   []interface{}
+	err      error
+}
+
+func (s *importSource) Next() bool {
+	for s.scanner.Scan() {
+		s.line++
+		if s.line%importProgressLines == 0 {
+			s.logger.Info("Importing accounts", "lines", s.line, "rejected", s.rejected)
+		}
+		text := bytes.TrimSpace(s.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		s.received++
+		s.values, s.err = s.parse(text)
+		return s.err == nil
+	}
+	s.err = s.scanner.Err()
+	return false
+}
+
+func (s *importSource) Values() ([]interface{}, error) {
+	return s.values, nil
+}
+
+func (s *importSource) Err() error {
+	return s.err
+}
+
+// parse returns values of the staging table row, rows of rejected lines have only the account ID, the code and the message
+func (s *importSource) parse(text []byte) ([]interface{}, error) {
+	account := apiclient.AccountResource{}
+	if err := json.Unmarshal(text, &account); err != nil {
+		return s.reject(account.ID, "invalid_account", fmt.Sprintf("Line is not an account: %v", err)), nil
+	}
+	if account.Type != "" && account.Type != "accounts" && account.Type != "account" {
+		return s.reject(account.ID, "invalid_account", "Account type should be accounts"), nil
+	}
+	id, organisationID, err := validateAccount(account.ID, account.OrganisationID, account.Attributes)
+	if err != nil {
+		return s.reject(account.ID, "invalid_account", err.Error()), nil
+	}
+	if account.Version < 0 {
+		return s.reject(account.ID, "invalid_account", "Account version should not be negative"), nil
+	}
+	if !s.authorize(account.OrganisationID) {
+		return s.reject(account.ID, "forbidden", "Not allowed to create accounts for this organisation"), nil
+	}
+	record, privateIdentification, err := s.encrypt(id, account.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting private identification of line %v: %v", s.line, err)
+	}
+	encodedRecord, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("encoding record of line %v: %v", s.line, err)
+	}
+	var encrypted interface{} // NULL when it is not encrypted
+	if privateIdentification != nil {
+		encrypted = string(privateIdentification)
+	}
+	return []interface{}{s.line, id.String(), id.String(), organisationID.String(), int32(account.Version), encodedRecord, encrypted, nil, nil}, nil
+}
+
+func (s *importSource) reject(accountID string, code string, message string) []interface{} {
+	s.rejected++
+	return []interface{}{s.line, accountID, nil, nil, nil, nil, nil, code, message}
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
 )
 
 type accountRouter struct {
 	accountService *AccountService
-	logger         *log.Logger
+	batchMaxSize   int // maximum number of accounts in one batch
+	logger         *Logger
 }
 
 func (ar *accountRouter) getMultipleAccounts(c *gin.Context) {
@@ -29,52 +33,151 @@ func (ar *accountRouter) getMultipleAccounts(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in page[size] query parameter"})
 		return
 	}
-	accountNumber := c.Query("filter[account_number]")
-	if len(accountNumber) > 0 {
-		page.Filter.AccountNumber = strings.Split(accountNumber, ",")
-	}
-	bankID := c.Query("filter[bank_id]")
-	if len(bankID) > 0 {
-		page.Filter.BankID = strings.Split(bankID, ",")
+	if page.Filter, err = parseAccountFilter(c); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
 	}
-	bankIDCode := c.Query("filter[bank_id_code]")
-	if len(bankIDCode) > 0 {
-		page.Filter.BankIDCode = strings.Split(bankIDCode, ",")
+	if !scopeFilter(c, &page.Filter) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "Not allowed to list accounts of other organisations"})
+		return
 	}
-	country := c.Query("filter[country]")
-	if len(country) > 0 {
-		page.Filter.Country = strings.Split(country, ",")
+	if page.Sort, err = parseAccountSort(c); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
 	}
-	customerID := c.Query("filter[customer_id]")
-	if len(customerID) > 0 {
-		page.Filter.CustomerID = strings.Split(customerID, ",")
+	fields, err := parseFieldSelection(c.Query("fields[accounts]"), hasScope(c, scopePIIRead))
+	if err == errPIINotAllowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
 	}
-	iban := c.Query("filter[iban]")
-	if len(iban) > 0 {
-		page.Filter.IBAN = strings.Split(iban, ",")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
 	}
-	accountList, err := ar.accountService.getAccountList(page)
+	accountList, err := ar.accountService.getAccountList(c.Request.Context(), page)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("Problem getting accounts from storage %v", err)})
+		abortWithStoreError(c, err, fmt.Sprintf("Problem getting accounts from storage %v", err))
 		return
 	}
+	views := make([]accountView, 0, len(accountList))
+	for _, account := range accountList {
+		view, err := fields.view(account)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("Problem preparing accounts %v", err)})
+			return
+		}
+		views = append(views, view)
+	}
 	c.JSON(200, gin.H{
-		"data": accountList,
+		"data": views,
 	})
 }
 
+// parseAccountFilter reads `filter[...]` query parameters with comma separated values, they are the same for list and export routes.
+// It returns an error when a value cannot be filtered with, e.g. a malformed date.
+func parseAccountFilter(c *gin.Context) (apiclient.AccountListFilter, error) {
+	filter := apiclient.AccountListFilter{}
+	for name, values := range map[string]*[]string{
+		"account_number":         &filter.AccountNumber,
+		"bank_id":                &filter.BankID,
+		"bank_id_code":           &filter.BankIDCode,
+		"country":                &filter.Country,
+		"customer_id":            &filter.CustomerID,
+		"iban":                   &filter.IBAN,
+		"status":                 &filter.Status,
+		"account_classification": &filter.AccountClassification,
+		"base_currency":          &filter.BaseCurrency,
+		"organisation_id":        &filter.OrganisationID,
+	} {
+		if value := c.Query("filter[" + name + "]"); len(value) > 0 {
+			*values = strings.Split(value, ",")
+		}
+	}
+	for _, organisationID := range filter.OrganisationID {
+		if _, err := uuid.Parse(organisationID); err != nil {
+			return filter, fmt.Errorf("Wrong value in filter[organisation_id] query parameter, %q is not a UUID", organisationID)
+		}
+	}
+	if value := c.Query("filter[joint_account]"); len(value) > 0 {
+		jointAccount, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("Wrong value in filter[joint_account] query parameter, should be true or false")
+		}
+		filter.JointAccount = &jointAccount
+	}
+	for name, t := range map[string]*time.Time{
+		"created_from":  &filter.CreatedFrom,
+		"created_to":    &filter.CreatedTo,
+		"modified_from": &filter.ModifiedFrom,
+		"modified_to":   &filter.ModifiedTo,
+	} {
+		if value := c.Query("filter[" + name + "]"); len(value) > 0 {
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return filter, fmt.Errorf("Wrong value in filter[%v] query parameter, should be RFC 3339 time, e.g. 2021-01-02T15:04:05Z", name)
+			}
+			*t = parsed
+		}
+	}
+	filter.Name = c.Query("filter[name_prefix]")
+	return filter, nil
+}
+
+// parseAccountSort reads the JSON:API `sort` query parameter, e.g. `-created_on,country`, it is the same for list and export routes
+func parseAccountSort(c *gin.Context) ([]apiclient.Sort, error) {
+	value := c.Query("sort")
+	if value == "" {
+		return nil, nil
+	}
+	sorts := []apiclient.Sort{}
+	seen := map[string]bool{}
+	for _, field := range strings.Split(value, ",") {
+		sort := apiclient.Sort{}
+		if strings.HasPrefix(field, "-") {
+			sort.Descending = true
+			field = field[1:]
+		}
+		if _, ok := accountSortColumns[field]; !ok {
+			return nil, fmt.Errorf("Wrong value in sort query parameter, %q cannot be sorted by, should be one of id, created_on, modified_on, country, name or status", field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("Wrong value in sort query parameter, %q is repeated", field)
+		}
+		seen[field] = true
+		sort.Field = apiclient.SortField(field)
+		sorts = append(sorts, sort)
+	}
+	return sorts, nil
+}
+
 func (ar *accountRouter) getOneAccount(c *gin.Context) {
 	accountID := c.Param("accountId")
-	data, err := ar.accountService.getAccount(accountID)
+	if accountID == exportPath {
+		ar.exportAccounts(c)
+		return
+	}
+	data, modifiedOn, err := ar.accountService.getAccount(c.Request.Context(), accountID, callerOrganisation(c))
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		abortWithStoreError(c, err, fmt.Sprintf("%v", err))
 		return
 	}
-	status := http.StatusOK
 	if data == nil {
-		status = http.StatusNotFound
+		c.JSON(http.StatusNotFound, gin.H{
+			"data": data,
+		})
+		return
 	}
-	c.JSON(status, gin.H{
+	fields := callerFieldSelection(c)
+	setCacheHeaders(c, data.Version, fields, modifiedOn)
+	if notModified(c.Request, accountETag(data.Version, fields), modifiedOn) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if data, err = fields.resource(*data); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("Problem preparing account %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
 		"data": data,
 	})
 }
@@ -85,23 +188,183 @@ func (ar *accountRouter) createAccount(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("Wrong request body %v", err)})
 		return
 	}
-	err := ar.accountService.upsertAccount(data)
+	if !canActOnBehalfOf(c, data.Data.OrganisationID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "Not allowed to create accounts for this organisation"})
+		return
+	}
+	// with `If-Match` only the account with the version is updated
+	var version *int
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		matchVersion, ok := parseETag(ifMatch)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in If-Match header"})
+			return
+		}
+		version = &matchVersion
+	}
+	err := ar.accountService.upsertAccount(c.Request.Context(), data, version, callerOrganisation(c))
+	if errors.Is(err, errVersionMismatch) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"status": "error", "code": "precondition_failed", "message": "Account version does not match If-Match header"})
+		return
+	}
+	if errors.Is(err, errAccountNotOwned) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"status": "error", "code": "account_exists", "message": "Account ID is already used by another organisation"})
+		return
+	}
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("Problem creating account in storage %v", err)})
+		abortWithStoreError(c, err, fmt.Sprintf("Problem creating account in storage %v", err))
 		return
 	}
-	newData, err := ar.accountService.getAccount(data.Data.ID)
+	newData, modifiedOn, err := ar.accountService.getAccount(c.Request.Context(), data.Data.ID, callerOrganisation(c))
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		abortWithStoreError(c, err, fmt.Sprintf("%v", err))
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	status := http.StatusCreated
+	if version != nil {
+		status = http.StatusOK
+	}
+	if newData != nil {
+		fields := callerFieldSelection(c)
+		setCacheHeaders(c, newData.Version, fields, modifiedOn)
+		if newData, err = fields.resource(*newData); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("Problem preparing account %v", err)})
+			return
+		}
+	}
+	c.JSON(status, gin.H{
 		"status": "success",
 		"data":   newData,
 	})
 }
 
+// createAccountBatch creates many accounts in one request. In `atomic` mode (default) no account is created when any of them fails,
+// in `partial` mode all valid accounts are created. Every account gets its own result, with an error when it was not created.
+func (ar *accountRouter) createAccountBatch(c *gin.Context) {
+	mode := c.DefaultQuery("mode", "atomic")
+	if mode != "atomic" && mode != "partial" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in mode query parameter, should be atomic or partial"})
+		return
+	}
+	data := apiclient.CreateAccountBatchRequestData{}
+	if err := c.ShouldBindBodyWith(&data, binding.JSON); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("Wrong request body %v", err)})
+		return
+	}
+	if len(data.Data) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Batch has no accounts"})
+		return
+	}
+	if len(data.Data) > ar.batchMaxSize {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"status": "error", "code": "batch_too_large", "message": fmt.Sprintf("Batch has more than %v accounts", ar.batchMaxSize)})
+		return
+	}
+
+	results := make([]apiclient.AccountBatchResult, len(data.Data))
+	valid := []batchAccount{}
+	validIndexes := []int{}
+	seen := map[uuid.UUID]bool{}
+	failed := false
+	for i, account := range data.Data {
+		results[i] = apiclient.AccountBatchResult{Index: i, ID: account.ID}
+		validAccount, status, batchErr := validateBatchAccount(c, account)
+		if batchErr == nil && seen[validAccount.id] {
+			status, batchErr = http.StatusBadRequest, &apiclient.AccountBatchError{Code: "invalid_account", Message: "Account ID is repeated in the batch"}
+		}
+		if batchErr != nil {
+			results[i].Status, results[i].Error = status, batchErr
+			failed = true
+			continue
+		}
+		seen[validAccount.id] = true
+		valid = append(valid, validAccount)
+		validIndexes = append(validIndexes, i)
+	}
+
+	atomic := mode == "atomic"
+	var inserted map[uuid.UUID]bool
+	committed := false
+	if len(valid) > 0 && !(atomic && failed) {
+		var err error
+		if inserted, committed, err = ar.accountService.createAccountBatch(c.Request.Context(), valid, atomic); err != nil {
+			abortWithStoreError(c, err, fmt.Sprintf("Problem creating accounts in storage %v", err))
+			return
+		}
+	}
+	fields := callerFieldSelection(c)
+	for j, account := range valid {
+		result := &results[validIndexes[j]]
+		switch {
+		case committed && inserted[account.id]:
+			result.Status = http.StatusCreated
+			data, err := fields.resource(apiclient.AccountResource{
+				Type:           "account",
+				ID:             account.id.String(),
+				OrganisationID: account.organisationID.String(),
+				Version:        0,
+				Attributes:     account.attributes,
+			})
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": fmt.Sprintf("Problem preparing accounts %v", err)})
+				return
+			}
+			result.Data = data
+		case inserted != nil && !inserted[account.id]:
+			result.Status, result.Error = http.StatusConflict, &apiclient.AccountBatchError{Code: "account_exists", Message: "Account already exists"}
+			failed = true
+		default:
+			result.Status, result.Error = http.StatusFailedDependency, &apiclient.AccountBatchError{Code: "batch_failed", Message: "Account not created because other accounts of the batch failed"}
+		}
+	}
+
+	switch {
+	case !failed:
+		c.JSON(http.StatusCreated, gin.H{"status": "success", "data": results})
+	case atomic:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"status": "error", "data": results})
+	default:
+		c.JSON(http.StatusMultiStatus, gin.H{"status": "partial", "data": results})
+	}
+}
+
+// validateBatchAccount checks the account of a batch, it returns status code and error of the account when it cannot be created
+func validateBatchAccount(c *gin.Context, account apiclient.NewAccount) (batchAccount, int, *apiclient.AccountBatchError) {
+	invalid := func(message string) (batchAccount, int, *apiclient.AccountBatchError) {
+		return batchAccount{}, http.StatusBadRequest, &apiclient.AccountBatchError{Code: "invalid_account", Message: message}
+	}
+	if account.Type != "" && account.Type != "accounts" {
+		return invalid("Account type should be accounts")
+	}
+	id, organisationID, err := validateAccount(account.ID, account.OrganisationID, account.Attributes)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	if !canActOnBehalfOf(c, account.OrganisationID) {
+		return batchAccount{}, http.StatusForbidden, &apiclient.AccountBatchError{Code: "forbidden", Message: "Not allowed to create accounts for this organisation"}
+	}
+	return batchAccount{id: id, organisationID: organisationID, attributes: account.Attributes}, 0, nil
+}
+
+// validateAccount checks fields required to store the account, it returns parsed IDs of the account and its organisation
+func validateAccount(accountID string, organisationID string, attributes *apiclient.AccountAttributes) (uuid.UUID, uuid.UUID, error) {
+	id, err := uuid.Parse(accountID)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, errors.New("Account ID is not a UUID")
+	}
+	organisation, err := uuid.Parse(organisationID)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, errors.New("Organisation ID is not a UUID")
+	}
+	if attributes == nil {
+		return uuid.UUID{}, uuid.UUID{}, errors.New("Account attributes are required")
+	}
+	if attributes.Country == "" {
+		return uuid.UUID{}, uuid.UUID{}, errors.New("Account country is required")
+	}
+	return id, organisation, nil
+}
+
 func (ar *accountRouter) deleteAccount(c *gin.Context) {
 	var (
 		version    int
@@ -109,17 +372,39 @@ func (ar *accountRouter) deleteAccount(c *gin.Context) {
 		statusCode int
 	)
 	accountID := c.Param("accountId")
-	if version, err = strconv.Atoi(c.Query("version")); err != nil {
-		ar.logger.Printf("deleteAccount, wrong version %v: %v, FAILED", c.Query("version"), err)
+	// `If-Match` header is an alternative to the version query parameter
+	ifMatch := c.GetHeader("If-Match")
+	useIfMatch := c.Query("version") == "" && ifMatch != ""
+	if useIfMatch {
+		var ok bool
+		if version, ok = parseETag(ifMatch); !ok {
+			loggerFrom(c.Request.Context(), ar.logger).Warn("Delete account failed: wrong If-Match header", "account_id", accountID, "if_match", ifMatch)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in If-Match header"})
+			return
+		}
+	} else if version, err = strconv.Atoi(c.Query("version")); err != nil {
+		loggerFrom(c.Request.Context(), ar.logger).Warn("Delete account failed: wrong version", "account_id", accountID, "version", c.Query("version"), "error", err)
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in version query parameter"})
 		return
 	}
-	deleted, err := ar.accountService.deleteAccount(accountID, version)
+	deleted, err := ar.accountService.deleteAccount(c.Request.Context(), accountID, version, callerOrganisation(c))
 	if err != nil {
-		ar.logger.Printf("deleteAccount, %v, FAILED", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error", "message": "Problem deleting account from storage"})
+		loggerFrom(c.Request.Context(), ar.logger).Error("Delete account failed", "account_id", accountID, "error", err)
+		abortWithStoreError(c, err, "Problem deleting account from storage")
 		return
 	}
+	if !deleted && useIfMatch {
+		// a failed precondition is reported only for accounts which exist
+		account, _, err := ar.accountService.getAccount(c.Request.Context(), accountID, callerOrganisation(c))
+		if err != nil {
+			abortWithStoreError(c, err, fmt.Sprintf("%v", err))
+			return
+		}
+		if account != nil {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"status": "error", "code": "precondition_failed", "message": "Account version does not match If-Match header"})
+			return
+		}
+	}
 	if deleted {
 		statusCode = http.StatusNoContent
 	} else {
@@ -130,13 +415,127 @@ func (ar *accountRouter) deleteAccount(c *gin.Context) {
 	})
 }
 
-func SetupAccountRouting(router *gin.RouterGroup, accountService *AccountService, logger *log.Logger) {
+// accountETag is a strong entity tag of the account version in the representation returned with the field selection,
+// e.g. `"3-pii"`, so representations with and without personal data never share a tag
+func accountETag(version int, fields *fieldSelection) string {
+	return `"` + strconv.Itoa(version) + "-" + fields.tag() + `"`
+}
+
+// parseETag returns the account version of a strong entity tag, the representation part is ignored,
+// as preconditions of updates and deletes depend only on the version. Tags with the version only, e.g. `"3"`, are accepted too.
+func parseETag(etag string) (int, bool) {
+	etag = strings.TrimSpace(etag)
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+	value := etag[1 : len(etag)-1]
+	if i := strings.IndexByte(value, '-'); i >= 0 {
+		value = value[:i]
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// setCacheHeaders sets `ETag` and `Last-Modified` headers, clients have to revalidate cached accounts before they are used.
+// Representations depend on scopes of the caller, so responses vary by credentials, and they are private,
+// because client certificates cannot be named in `Vary`.
+func setCacheHeaders(c *gin.Context, version int, fields *fieldSelection, modifiedOn time.Time) {
+	c.Header("ETag", accountETag(version, fields))
+	c.Header("Vary", "Authorization, Signature-Input")
+	if !modifiedOn.IsZero() {
+		c.Header("Last-Modified", modifiedOn.UTC().Format(http.TimeFormat))
+	}
+	c.Header("Cache-Control", "private, no-cache")
+}
+
+// notModified checks `If-None-Match` header, or `If-Modified-Since` header when there is no `If-None-Match`
+func notModified(req *http.Request, etag string, modifiedOn time.Time) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			// weak comparison, `W/` prefix is ignored
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := req.Header.Get("If-Modified-Since"); ifModifiedSince != "" && !modifiedOn.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		// Last-Modified has one second precision
+		return err == nil && !modifiedOn.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// SetupAccountRouting registers account routes, `limiter` can be nil when requests are not limited
+func SetupAccountRouting(router *gin.RouterGroup, accountService *AccountService, timeouts statementTimeouts, streams streamTimeout, limiter *rateLimiter, batchMaxSize int, logger *Logger) {
 	ar := accountRouter{
 		accountService: accountService,
+		batchMaxSize:   batchMaxSize,
 		logger:         logger,
 	}
-	router.GET("/", ar.getMultipleAccounts)
-	router.GET("/:accountId", ar.getOneAccount)
-	router.POST("/", ar.createAccount)
-	router.DELETE("/:accountId", ar.deleteAccount)
+	router.GET("/", limiter.middleware("list"), timeouts.middleware("list"), ar.getMultipleAccounts)
+	// exports share the route of the account ID, see exportPath
+	router.GET("/:accountId", limiter.middleware("read"), timeouts.middlewareBy(func(c *gin.Context) string {
+		if isExport(c) {
+			return "export"
+		}
+		return "fetch"
+	}), streams.middlewareIf(isExport), ar.getOneAccount)
+	router.POST("/", limiter.middleware("write"), timeouts.middleware("create"), ar.createAccount)
+	router.POST("/batch", limiter.middleware("write"), timeouts.middleware("batch"), ar.createAccountBatch)
+	router.DELETE("/:accountId", limiter.middleware("write"), timeouts.middleware("delete"), ar.deleteAccount)
+}
+
+// isExport reports if the request of the account ID route is an export, see exportPath
+func isExport(c *gin.Context) bool {
+	return c.Param("accountId") == exportPath
+}
+
+// accountRoutes are names of routes used to configure statement timeouts, `job` is the route of submitting exports, and reading and cancelling jobs
+var accountRoutes = map[string]bool{"list": true, "fetch": true, "create": true, "batch": true, "delete": true, "import": true, "export": true, "job": true}
+
+// statementTimeouts limit time of queries run while handling a request
+type statementTimeouts struct {
+	fallback time.Duration            // used by routes without own timeout, 0 disables the timeout
+	routes   map[string]time.Duration // by route name, see accountRoutes
+}
+
+// middleware sets deadline of the request context, queries still running after it are cancelled
+func (t statementTimeouts) middleware(route string) gin.HandlerFunc {
+	return t.middlewareBy(func(*gin.Context) string { return route })
+}
+
+// middlewareBy is like `middleware`, but the route name is decided for every request
+func (t statementTimeouts) middlewareBy(route func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout, ok := t.routes[route(c)]
+		if !ok {
+			timeout = t.fallback
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// abortWithStoreError responds with 504 when a query timed out, 503 when it was cancelled, and 500 on other store errors
+func abortWithStoreError(c *gin.Context, err error, message string) {
+	status, code := http.StatusInternalServerError, "store_error"
+	switch {
+	case errors.Is(err, errQueryTimeout):
+		status, code = http.StatusGatewayTimeout, "query_timeout"
+	case errors.Is(err, errQueryCanceled):
+		status, code = http.StatusServiceUnavailable, "query_cancelled"
+	}
+	c.AbortWithStatusJSON(status, gin.H{"status": "error", "code": code, "message": message})
 }
@@ -2,117 +2,294 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
 	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
 )
 
 type DBConfig struct {
+	URL      string // connection string, the other fields override its parts when set
 	Host     string
 	Port     int
 	User     string
 	Password string
 	Database string
+
+	// pool tuning, pgxpool defaults are used for zero values
+	MaxConns          int
+	MinConns          int
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	ConnectTimeout    time.Duration
 }
 
 type AccountService struct {
 	dbConnConfig *pgxpool.Config
 	dbConnPool   *pgxpool.Pool
-	logger       *log.Logger
+	logger       *Logger
+	metrics      *serverMetrics
+	keyring      *keyring // encrypts private identification, nil when it is stored in plaintext
 }
 
-func NewAccountService(dbConfig *DBConfig, logger *log.Logger) (*AccountService, error) {
-	dbConnConfig, _ := pgxpool.ParseConfig("")
-	dbConnConfig.ConnConfig.Host = dbConfig.Host
-	dbConnConfig.ConnConfig.Port = uint16(dbConfig.Port)
-	dbConnConfig.ConnConfig.User = dbConfig.User
-	dbConnConfig.ConnConfig.Password = dbConfig.Password
-	dbConnConfig.ConnConfig.Database = dbConfig.Database
-
-	dbConnPoll, err := pgxpool.ConnectConfig(context.Background(), dbConnConfig)
+func NewAccountService(dbConfig *DBConfig, logger *Logger, metrics *serverMetrics, encryption *EncryptionConfig) (*AccountService, error) {
+	dbConnConfig, err := pgxpool.ParseConfig(dbConfig.URL)
 	if err != nil {
-		logger.Printf("Error when creating Postgress connection pool: %v", err)
+		logger.Error("Parsing Postgres connection string failed", "error", err)
 		return nil, fmt.Errorf("Failed to setup store")
 	}
-	// install postgres extension to generate uuid, i.e. use uuid_generate_v4()
-	_, err = dbConnPoll.Exec(context.Background(), `CREATE EXTENSION IF NOT EXISTS "uuid-ossp";`)
+	if dbConfig.Host != "" {
+		dbConnConfig.ConnConfig.Host = dbConfig.Host
+	}
+	if dbConfig.Port != 0 {
+		dbConnConfig.ConnConfig.Port = uint16(dbConfig.Port)
+	}
+	if dbConfig.User != "" {
+		dbConnConfig.ConnConfig.User = dbConfig.User
+	}
+	if dbConfig.Password != "" {
+		dbConnConfig.ConnConfig.Password = dbConfig.Password
+	}
+	if dbConfig.Database != "" {
+		dbConnConfig.ConnConfig.Database = dbConfig.Database
+	}
+	if dbConfig.MaxConns > 0 {
+		dbConnConfig.MaxConns = int32(dbConfig.MaxConns)
+	}
+	if dbConfig.MinConns > 0 {
+		dbConnConfig.MinConns = int32(dbConfig.MinConns)
+	}
+	if dbConfig.MaxConnLifetime > 0 {
+		dbConnConfig.MaxConnLifetime = dbConfig.MaxConnLifetime
+	}
+	if dbConfig.MaxConnIdleTime > 0 {
+		dbConnConfig.MaxConnIdleTime = dbConfig.MaxConnIdleTime
+	}
+	if dbConfig.HealthCheckPeriod > 0 {
+		dbConnConfig.HealthCheckPeriod = dbConfig.HealthCheckPeriod
+	}
+	if dbConfig.ConnectTimeout > 0 {
+		dbConnConfig.ConnConfig.ConnectTimeout = dbConfig.ConnectTimeout
+	}
+
+	dbConnPoll, err := pgxpool.ConnectConfig(context.Background(), dbConnConfig)
 	if err != nil {
-		logger.Printf("Error when installing Postgres extension uuid-ossp: %v", err)
-		defer dbConnPoll.Close()
-		return nil, fmt.Errorf("Failed setup store")
+		logger.Error("Creating Postgres connection pool failed", "error", err)
+		return nil, fmt.Errorf("Failed to setup store")
 	}
-	_, err = dbConnPoll.Exec(context.Background(), `CREATE TABLE IF NOT EXISTS "Account" (
-		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-		organisation_id UUID NOT NULL,
-		version INTEGER NOT NULL DEFAULT 0,
-		is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
-		is_locked BOOLEAN NOT NULL DEFAULT FALSE,
-		created_on TIMESTAMP NOT NULL DEFAULT NOW(),
-		modified_on TIMESTAMP,
-		record jsonb
-	)`)
-	if err != nil {
-		logger.Printf("Error when creating Account table: %v", err)
+	if err = migrate(context.Background(), dbConnPoll, logger); err != nil {
 		defer dbConnPoll.Close()
 		return nil, fmt.Errorf("Failed setup store")
 	}
 
-	return &AccountService{
+	metrics.registerPool(dbConnPoll)
+
+	service := &AccountService{
 		dbConnConfig: dbConnConfig,
 		dbConnPool:   dbConnPoll,
 		logger:       logger,
-	}, nil
+		metrics:      metrics,
+	}
+	if encryption != nil {
+		service.keyring = encryption.Keyring
+	}
+	return service, nil
 }
 
-func (s *AccountService) upsertAccount(data apiclient.CreateAccountResourceRequestData) error {
+// upsertAccount creates or updates the account, when `version` is set only the account with this version is updated,
+// and errVersionMismatch is returned when there is no such account.
+// Only accounts of the `owner` organisation are updated, errAccountNotOwned is returned when the account belongs to another one.
+// The owner is empty when authentication is not configured.
+func (s *AccountService) upsertAccount(ctx context.Context, data apiclient.CreateAccountResourceRequestData, version *int, owner string) error {
+	logger := loggerFrom(ctx, s.logger).With("account_id", data.Data.ID, "organisation_id", data.Data.OrganisationID)
 
 	id, err := uuid.Parse(data.Data.ID)
 	if err != nil {
-		s.logger.Printf("Upsert failed: cannot parse id %v: %v", data.Data.ID, err)
+		logger.Warn("Upsert failed: cannot parse id", "error", err)
 		return fmt.Errorf("Faild to parse id")
 	}
 	organisationID, err := uuid.Parse(data.Data.OrganisationID)
 	if err != nil {
-		s.logger.Printf("Upsert failed: cannot parse organisation_id %v: %v", data.Data.OrganisationID, err)
+		logger.Warn("Upsert failed: cannot parse organisation_id", "error", err)
 		return fmt.Errorf("Faild to parse organisation_id")
 	}
 
-	_, err = s.dbConnPool.Exec(
-		context.Background(),
-		`INSERT INTO "Account" (id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record) VALUES($1, $2, 0, FALSE, FALSE, current_timestamp, current_timestamp, $3) 
-			ON CONFLICT (id) DO UPDATE SET organisation_id = $1, version = "Account".version + 1, modified_on = current_timestamp, record = $3`,
-		id, organisationID, data.Data.Attributes,
-	)
+	record, privateIdentification, err := s.encryptPrivateIdentification(id, data.Data.Attributes)
+	if err != nil {
+		logger.Error("Upsert failed: cannot encrypt private identification", "error", err)
+		return fmt.Errorf("Failed to encrypt private identification")
+	}
+
+	ctx, span := startQuerySpan(ctx, "upsertAccount", label.String("account.id", data.Data.ID), label.String("account.organisation_id", data.Data.OrganisationID))
+	start := time.Now()
+	var cmdTag pgconn.CommandTag
+	if version == nil {
+		cmdTag, err = s.dbConnPool.Exec(
+			ctx,
+			`INSERT INTO "Account" (id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record, private_identification) VALUES($1, $2, 0, FALSE, FALSE, current_timestamp, current_timestamp, $3, $4) 
+				ON CONFLICT (id) DO UPDATE SET organisation_id = $2, version = "Account".version + 1, modified_on = current_timestamp, record = $3, private_identification = $4
+				WHERE $5::uuid IS NULL OR "Account".organisation_id = $5`,
+			id, organisationID, record, privateIdentification, ownerArg(owner),
+		)
+	} else {
+		cmdTag, err = s.dbConnPool.Exec(
+			ctx,
+			`UPDATE "Account" SET organisation_id = $2, version = version + 1, modified_on = current_timestamp, record = $3, private_identification = $4
+				WHERE id = $1 AND version = $5 AND ($6::uuid IS NULL OR organisation_id = $6)`,
+			id, organisationID, record, privateIdentification, *version, ownerArg(owner),
+		)
+	}
+	s.metrics.observeQuery("upsertAccount", start, err)
+	endQuerySpan(span, err)
 	if err != nil {
-		s.logger.Printf("Upsert failed: failed to execute insert: %v", err)
-		return fmt.Errorf("Failed to create/update record in store")
+		logger.Error("Upsert failed: failed to execute insert", "error", err)
+		return queryError(ctx, err, "Failed to create/update record in store")
+	}
+	if version != nil && cmdTag.RowsAffected() == 0 {
+		logger.Warn("Update failed: no account with the version", "version", *version)
+		return fmt.Errorf("Failed to update account with version %v: %w", *version, errVersionMismatch)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		logger.Warn("Upsert failed: account belongs to another organisation", "owner", owner)
+		return fmt.Errorf("Failed to update account: %w", errAccountNotOwned)
 	}
 
-	s.logger.Printf("Successfully created/updated Account %v", id)
+	logger.Info("Successfully created/updated Account")
 	return nil
 }
 
-func (s *AccountService) getAccount(accountID string) (*apiclient.AccountResource, error) {
+// batchAccount is a validated account of a batch
+type batchAccount struct {
+	id             uuid.UUID
+	organisationID uuid.UUID
+	attributes     *apiclient.AccountAttributes
+}
+
+// createAccountBatch inserts accounts in one statement, accounts which already exist are not changed.
+// It returns IDs of inserted accounts, and if they were committed: with `atomic` nothing is committed when any account already exists.
+func (s *AccountService) createAccountBatch(ctx context.Context, accounts []batchAccount, atomic bool) (map[uuid.UUID]bool, bool, error) {
+	logger := loggerFrom(ctx, s.logger).With("batch_size", len(accounts), "atomic", atomic)
 
-	rows, err := s.dbConnPool.Query(context.Background(), `select id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record FROM "Account" WHERE id = $1`, accountID)
+	ids := make([]string, len(accounts))
+	organisationIDs := make([]string, len(accounts))
+	records := make([]string, len(accounts))
+	privateIdentifications := make([]*string, len(accounts)) // nil when it is not encrypted
+	for i, account := range accounts {
+		record, privateIdentification, err := s.encryptPrivateIdentification(account.id, account.attributes)
+		if err != nil {
+			logger.Error("Create batch failed: cannot encrypt private identification", "account_id", account.id, "error", err)
+			return nil, false, fmt.Errorf("Failed to encrypt private identification")
+		}
+		encodedRecord, err := json.Marshal(record)
+		if err != nil {
+			logger.Error("Create batch failed: cannot encode record", "account_id", account.id, "error", err)
+			return nil, false, fmt.Errorf("Failed to encode account")
+		}
+		ids[i], organisationIDs[i], records[i] = account.id.String(), account.organisationID.String(), string(encodedRecord)
+		if privateIdentification != nil {
+			encrypted := string(privateIdentification)
+			privateIdentifications[i] = &encrypted
+		}
+	}
+
+	ctx, span := startQuerySpan(ctx, "createAccountBatch", label.Int("account.batch.size", len(accounts)), label.Bool("account.batch.atomic", atomic))
+	start := time.Now()
+	inserted, committed, err := s.insertAccountBatch(ctx, ids, organisationIDs, records, privateIdentifications, atomic)
+	s.metrics.observeQuery("createAccountBatch", start, err)
+	endQuerySpan(span, err)
 	if err != nil {
-		s.logger.Printf("Get account failed: failed to execute query %v", err)
-		return nil, fmt.Errorf("Failed to fetch data from store")
+		logger.Error("Create batch failed: failed to execute insert", "error", err)
+		return nil, false, queryError(ctx, err, "Failed to create records in store")
 	}
 
+	logger.Info("Created batch of Accounts", "created", len(inserted), "committed", committed)
+	return inserted, committed, nil
+}
+
+func (s *AccountService) insertAccountBatch(ctx context.Context, ids, organisationIDs, records []string, privateIdentifications []*string, atomic bool) (map[uuid.UUID]bool, bool, error) {
+	tx, err := s.dbConnPool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`INSERT INTO "Account" (id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record, private_identification)
+			SELECT id, organisation_id, 0, FALSE, FALSE, current_timestamp, current_timestamp, record::jsonb, private_identification::jsonb
+			FROM unnest($1::uuid[], $2::uuid[], $3::text[], $4::text[]) AS batch(id, organisation_id, record, private_identification)
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id`,
+		ids, organisationIDs, records, privateIdentifications,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	inserted := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+		inserted[id] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if atomic && len(inserted) < len(ids) {
+		return inserted, false, nil
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return inserted, true, nil
+}
+
+// getAccount returns the account and its modification time, it returns nil account when it does not exist,
+// or it belongs to another organisation than the `owner`
+func (s *AccountService) getAccount(ctx context.Context, accountID string, owner string) (*apiclient.AccountResource, time.Time, error) {
+	logger := loggerFrom(ctx, s.logger).With("account_id", accountID)
+
+	ctx, span := startQuerySpan(ctx, "getAccount", label.String("account.id", accountID))
+	defer span.End()
+	start := time.Now()
+	rows, err := s.dbConnPool.Query(ctx, `select id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record, private_identification FROM "Account" WHERE id = $1 AND ($2::uuid IS NULL OR organisation_id = $2)`, accountID, ownerArg(owner))
+	s.metrics.observeQuery("getAccount", start, err)
+	if err != nil {
+		logger.Error("Get account failed: failed to execute query", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, time.Time{}, queryError(ctx, err, "Failed to fetch data from store")
+	}
+	defer rows.Close()
+
 	account := dbAccount{}
 	if rows.Next() {
-		err = rows.Scan(&account.ID, &account.OrganisationID, &account.Version, &account.IsDeleted, &account.IsLocked, &account.CreatedOn, &account.ModifiedOn, &account.Record)
+		err = rows.Scan(&account.ID, &account.OrganisationID, &account.Version, &account.IsDeleted, &account.IsLocked, &account.CreatedOn, &account.ModifiedOn, &account.Record, &account.PrivateIdentification)
 		if err != nil {
-			s.logger.Printf("Get account failed: failed to parse data from strore %v", err)
-			return nil, fmt.Errorf("Failed to parse data from store")
+			logger.Error("Get account failed: failed to parse data from store", "error", err)
+			return nil, time.Time{}, queryError(ctx, err, "Failed to parse data from store")
+		}
+		if err = s.decryptPrivateIdentification(&account); err != nil {
+			logger.Error("Get account failed: failed to decrypt private identification", "error", err)
+			return nil, time.Time{}, fmt.Errorf("Failed to decrypt data from store")
 		}
+	} else if err = rows.Err(); err != nil {
+		logger.Error("Get account failed: failed to read data from store", "error", err)
+		return nil, time.Time{}, queryError(ctx, err, "Failed to fetch data from store")
 	} else {
-		return nil, nil
+		return nil, time.Time{}, nil
 	}
 
 	return &apiclient.AccountResource{
@@ -121,47 +298,47 @@ func (s *AccountService) getAccount(accountID string) (*apiclient.AccountResourc
 		OrganisationID: account.OrganisationID.String(),
 		Version:        int(account.Version),
 		Attributes:     &account.Record,
-	}, nil
+	}, account.ModifiedOn, nil
 }
 
-func (s *AccountService) getAccountList(page apiclient.AccountPage) ([]apiclient.AccountResource, error) {
+func (s *AccountService) getAccountList(ctx context.Context, page apiclient.AccountPage) ([]apiclient.AccountResource, error) {
+	logger := loggerFrom(ctx, s.logger).With("page_number", page.PageNumber, "page_size", page.PageSize)
 
 	limit := page.PageSize
 	offset := page.PageSize * page.PageNumber
 
-	rows, err := s.dbConnPool.Query(context.Background(), `
-	SELECT id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record
+	attributes := append(apiclient.FilterAttributes(page.Filter), label.Int("account.page.number", page.PageNumber), label.Int("account.page.size", page.PageSize))
+	ctx, span := startQuerySpan(ctx, "getAccountList", attributes...)
+	defer span.End()
+	start := time.Now()
+	rows, err := s.dbConnPool.Query(ctx, `
+	SELECT id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record, private_identification
 	FROM "Account"
-	WHERE
-	    (CARDINALITY($3::varchar[]) IS NULL OR record->>'account_number' = ANY($3))
-	  AND
-	    (CARDINALITY($4::varchar[]) IS NULL OR record->>'bank_id' = ANY($4))
-	  AND
-		(CARDINALITY($5::varchar[]) IS NULL OR record->>'bank_id_code' = ANY($5))
-	  AND
-	    (CARDINALITY($6::varchar[]) IS NULL OR record->>'country' = ANY($6))
-	  AND
-		(CARDINALITY($7::varchar[]) IS NULL OR record->>'customer_id' = ANY($7))
-	  AND
-		(CARDINALITY($8::varchar[]) IS NULL OR record->>'iban' = ANY($8))
-	ORDER BY id
+	WHERE `+accountFilterCondition(3)+`
+	`+accountOrderBy(page.Sort)+`
 	LIMIT $1
-	OFFSET $2`, limit, offset,
-		page.Filter.AccountNumber, page.Filter.BankID, page.Filter.BankIDCode,
-		page.Filter.Country, page.Filter.CustomerID, page.Filter.IBAN)
+	OFFSET $2`, append([]interface{}{limit, offset}, accountFilterArgs(page.Filter)...)...)
+	s.metrics.observeQuery("getAccountList", start, err)
 	if err != nil {
-		s.logger.Printf("Get account list failed: failed to get data from store %v", err)
-		return nil, fmt.Errorf("Failed to fetch data from store")
+		logger.Error("Get account list failed: failed to get data from store", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, queryError(ctx, err, "Failed to fetch data from store")
 	}
+	defer rows.Close()
 
 	result := []apiclient.AccountResource{}
 
 	for rows.Next() {
 		account := dbAccount{}
-		err = rows.Scan(&account.ID, &account.OrganisationID, &account.Version, &account.IsDeleted, &account.IsLocked, &account.CreatedOn, &account.ModifiedOn, &account.Record)
+		err = rows.Scan(&account.ID, &account.OrganisationID, &account.Version, &account.IsDeleted, &account.IsLocked, &account.CreatedOn, &account.ModifiedOn, &account.Record, &account.PrivateIdentification)
 		if err != nil {
-			s.logger.Printf("Get account list failed: failed to parse a record %v", err)
-			return nil, fmt.Errorf("Failed to parse data from store")
+			logger.Error("Get account list failed: failed to parse a record", "error", err)
+			return nil, queryError(ctx, err, "Failed to parse data from store")
+		}
+		if err = s.decryptPrivateIdentification(&account); err != nil {
+			logger.Error("Get account list failed: failed to decrypt private identification", "account_id", account.ID, "error", err)
+			return nil, fmt.Errorf("Failed to decrypt data from store")
 		}
 		result = append(result, apiclient.AccountResource{
 			Type:           "account",
@@ -171,25 +348,113 @@ func (s *AccountService) getAccountList(page apiclient.AccountPage) ([]apiclient
 			Attributes:     &account.Record,
 		})
 	}
+	if err = rows.Err(); err != nil {
+		logger.Error("Get account list failed: failed to read data from store", "error", err)
+		return nil, queryError(ctx, err, "Failed to fetch data from store")
+	}
 
 	return result, nil
 }
 
-func (s *AccountService) deleteAccount(accountID string, version int) (bool, error) {
+// accountSortColumns are SQL expressions of fields accounts can be sorted by
+var accountSortColumns = map[string]string{
+	"id":          "id",
+	"created_on":  "created_on",
+	"modified_on": "modified_on",
+	"country":     "record->>'country'",
+	"name":        "lower(record->'name'->>0)",
+	"status":      "record->>'status'",
+}
+
+// accountOrderBy is the ORDER BY clause of sorted accounts. Accounts are sorted by id last, so pages of equal values are stable.
+func accountOrderBy(sorts []apiclient.Sort) string {
+	columns := make([]string, 0, len(sorts)+1)
+	sortedByID := false
+	for _, sort := range sorts {
+		// fields are validated by the router, only known columns are put into SQL
+		column, ok := accountSortColumns[string(sort.Field)]
+		if !ok {
+			continue
+		}
+		direction := "ASC"
+		if sort.Descending {
+			direction = "DESC"
+		}
+		columns = append(columns, fmt.Sprintf("%v %v NULLS LAST", column, direction))
+		sortedByID = sortedByID || sort.Field == apiclient.SortByID
+	}
+	if !sortedByID {
+		columns = append(columns, "id ASC")
+	}
+	return "ORDER BY " + strings.Join(columns, ", ")
+}
+
+// accountFilterColumns are record attributes filtered with `AccountListFilter`, in order of accountFilterArgs
+var accountFilterColumns = []string{"account_number", "bank_id", "bank_id_code", "country", "customer_id", "iban", "status", "account_classification", "base_currency"}
+
+// accountFilterCondition is the WHERE condition of `AccountListFilter`, values of filters are query parameters starting with $first.
+// Empty filters match all accounts.
+func accountFilterCondition(first int) string {
+	conditions := make([]string, 0, len(accountFilterColumns)+7)
+	for i, column := range accountFilterColumns {
+		conditions = append(conditions, fmt.Sprintf("(CARDINALITY($%[1]v::varchar[]) IS NULL OR record->>'%[2]v' = ANY($%[1]v))", first+i, column))
+	}
+	next := first + len(accountFilterColumns)
+	conditions = append(conditions,
+		fmt.Sprintf("(CARDINALITY($%[1]v::uuid[]) IS NULL OR organisation_id = ANY($%[1]v))", next),
+		fmt.Sprintf("($%[1]v::boolean IS NULL OR COALESCE((record->>'joint_account')::boolean, FALSE) = $%[1]v)", next+1),
+		fmt.Sprintf("($%[1]v::timestamp IS NULL OR created_on >= $%[1]v)", next+2),
+		fmt.Sprintf("($%[1]v::timestamp IS NULL OR created_on < $%[1]v)", next+3),
+		fmt.Sprintf("($%[1]v::timestamp IS NULL OR modified_on >= $%[1]v)", next+4),
+		fmt.Sprintf("($%[1]v::timestamp IS NULL OR modified_on < $%[1]v)", next+5),
+		// LIKE special characters are escaped in accountFilterArgs
+		fmt.Sprintf("($%[1]v::text IS NULL OR EXISTS (SELECT 1 FROM jsonb_array_elements_text(record->'name') AS name WHERE lower(name) LIKE lower($%[1]v) || '%%'))", next+6),
+	)
+	return strings.Join(conditions, " AND ")
+}
+
+// accountFilterArgs are query parameters of accountFilterCondition
+func accountFilterArgs(filter apiclient.AccountListFilter) []interface{} {
+	args := []interface{}{filter.AccountNumber, filter.BankID, filter.BankIDCode, filter.Country, filter.CustomerID, filter.IBAN,
+		filter.Status, filter.AccountClassification, filter.BaseCurrency, filter.OrganisationID, filter.JointAccount}
+	// created_on and modified_on are stored in UTC without time zone
+	for _, t := range []time.Time{filter.CreatedFrom, filter.CreatedTo, filter.ModifiedFrom, filter.ModifiedTo} {
+		var value *time.Time
+		if !t.IsZero() {
+			utc := t.UTC()
+			value = &utc
+		}
+		args = append(args, value)
+	}
+	var namePrefix *string
+	if filter.Name != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Name)
+		namePrefix = &escaped
+	}
+	return append(args, namePrefix)
+}
+
+// deleteAccount deletes the account with the version, it returns false when there is no such account of the `owner` organisation
+func (s *AccountService) deleteAccount(ctx context.Context, accountID string, version int, owner string) (bool, error) {
+	logger := loggerFrom(ctx, s.logger).With("account_id", accountID, "version", version)
 	id, err := uuid.Parse(accountID)
 	if err != nil {
-		s.logger.Printf("Delete account failed: cannot parse account_id %v: %v", accountID, err)
+		logger.Warn("Delete account failed: cannot parse account_id", "error", err)
 		return false, fmt.Errorf("Faild to parse accountID")
 	}
 
+	ctx, span := startQuerySpan(ctx, "deleteAccount", label.String("account.id", accountID), label.Int("account.version", version))
+	start := time.Now()
 	cmdTag, err := s.dbConnPool.Exec(
-		context.Background(),
-		`DELETE FROM "Account" WHERE id = $1 AND version = $2`,
-		id, version,
+		ctx,
+		`DELETE FROM "Account" WHERE id = $1 AND version = $2 AND ($3::uuid IS NULL OR organisation_id = $3)`,
+		id, version, ownerArg(owner),
 	)
+	s.metrics.observeQuery("deleteAccount", start, err)
+	endQuerySpan(span, err)
 	if err != nil {
-		s.logger.Printf("Delete account failed: failed to execute DELETE command %v", err)
-		return false, fmt.Errorf("Failed to delete record from store")
+		logger.Error("Delete account failed: failed to execute DELETE command", "error", err)
+		return false, queryError(ctx, err, "Failed to delete record from store")
 	}
 	if cmdTag.RowsAffected() > 0 {
 		return true, nil
@@ -198,6 +463,100 @@ func (s *AccountService) deleteAccount(accountID string, version int) (bool, err
 	return false, nil
 }
 
+// accountCount is a number of accounts with the same country and status
+type accountCount struct {
+	Country string
+	Status  string
+	Count   int64
+}
+
+func (s *AccountService) countAccounts(ctx context.Context) ([]accountCount, error) {
+
+	ctx, span := startQuerySpan(ctx, "countAccounts")
+	defer span.End()
+	start := time.Now()
+	rows, err := s.dbConnPool.Query(ctx, `
+	SELECT COALESCE(record->>'country', ''), COALESCE(record->>'status', ''), COUNT(*)
+	FROM "Account"
+	GROUP BY 1, 2`)
+	s.metrics.observeQuery("countAccounts", start, err)
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("Count accounts failed: failed to execute query", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("Failed to fetch data from store")
+	}
+	defer rows.Close()
+
+	result := []accountCount{}
+	for rows.Next() {
+		count := accountCount{}
+		if err = rows.Scan(&count.Country, &count.Status, &count.Count); err != nil {
+			loggerFrom(ctx, s.logger).Error("Count accounts failed: failed to parse a record", "error", err)
+			return nil, fmt.Errorf("Failed to parse data from store")
+		}
+		result = append(result, count)
+	}
+
+	return result, nil
+}
+
+// sqlStateQueryCanceled is the Postgres error code of queries cancelled by statement_timeout
+const sqlStateQueryCanceled = "57014"
+
+var (
+	// errQueryTimeout is returned when a query was cancelled because it did not finish before the statement timeout
+	errQueryTimeout = errors.New("query timed out")
+	// errQueryCanceled is returned when a query was cancelled before it finished, e.g. the client disconnected
+	errQueryCanceled = errors.New("query cancelled")
+	// errVersionMismatch is returned when a conditional update did not find the account with the expected version
+	errVersionMismatch = errors.New("account version mismatch")
+	// errAccountNotOwned is returned when the account exists, but belongs to another organisation than the caller
+	errAccountNotOwned = errors.New("account belongs to another organisation")
+)
+
+// ownerArg is the query parameter of the owner organisation, NULL matches accounts of all organisations
+func ownerArg(owner string) *string {
+	if owner == "" {
+		return nil
+	}
+	return &owner
+}
+
+// queryError returns an error with the message, which wraps errQueryTimeout or errQueryCanceled when the query was cancelled
+func queryError(ctx context.Context, err error, message string) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%v: %w", message, errQueryTimeout)
+	case errors.As(err, &pgErr) && pgErr.Code == sqlStateQueryCanceled:
+		// statement_timeout configured in Postgres, e.g. for the database role
+		return fmt.Errorf("%v: %w", message, errQueryTimeout)
+	case ctx.Err() == context.Canceled || errors.Is(err, context.Canceled):
+		return fmt.Errorf("%v: %w", message, errQueryCanceled)
+	}
+	return errors.New(message)
+}
+
+// ready checks that Postgres is reachable and the schema is migrated, on `conn` which is not a connection of the pool.
+// The connection is opened when it is nil, it returns the connection for the next check, or nil when the check failed.
+func (s *AccountService) ready(ctx context.Context, conn *pgx.Conn) (*pgx.Conn, error) {
+	var err error
+	if conn == nil || conn.IsClosed() {
+		if conn, err = pgx.ConnectConfig(ctx, s.dbConnConfig.ConnConfig.Copy()); err != nil {
+			return nil, fmt.Errorf("connecting to Postgres: %v", err)
+		}
+	}
+	if err = conn.Ping(ctx); err == nil {
+		err = checkMigrations(ctx, conn)
+	}
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
 func (s *AccountService) Close() {
 	if s.dbConnPool != nil {
 		defer s.dbConnPool.Close()
@@ -213,4 +572,32 @@ type dbAccount struct {
 	CreatedOn      time.Time
 	ModifiedOn     time.Time
 	Record         apiclient.AccountAttributes
+	// PrivateIdentification is an encrypted `envelope`, nil when private identification is stored in `Record`
+	PrivateIdentification []byte
+}
+
+// encryptPrivateIdentification moves private identification out of the record into an encrypted envelope, the envelope is authenticated with the account ID
+func (s *AccountService) encryptPrivateIdentification(id uuid.UUID, attributes *apiclient.AccountAttributes) (*apiclient.AccountAttributes, []byte, error) {
+	if s.keyring == nil || attributes == nil || attributes.PrivateIdentification == nil {
+		return attributes, nil, nil
+	}
+	encrypted, err := s.keyring.sealJSON(attributes.PrivateIdentification, id[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	record := *attributes
+	record.PrivateIdentification = nil
+	return &record, encrypted, nil
+}
+
+// decryptPrivateIdentification puts decrypted private identification back into the record
+func (s *AccountService) decryptPrivateIdentification(account *dbAccount) error {
+	if account.PrivateIdentification == nil {
+		return nil
+	}
+	if s.keyring == nil {
+		return fmt.Errorf("private identification is encrypted, but no key-encryption keys are configured")
+	}
+	account.Record.PrivateIdentification = &apiclient.PrivateIdentification{}
+	return s.keyring.openJSON(account.PrivateIdentification, account.ID[:], account.Record.PrivateIdentification)
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/gin-gonic/gin"
+)
+
+// accountAttributes maps names of account attributes to information if they hold personal data (`pii:"true"` tag)
+var accountAttributes = attributeNames(reflect.TypeOf(apiclient.AccountAttributes{}))
+
+func attributeNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		names[name] = field.Tag.Get("pii") == "true"
+	}
+	return names
+}
+
+// accountView is an account returned in list responses, with only the selected attributes
+type accountView struct {
+	Type           string                     `json:"type"`
+	ID             string                     `json:"id"`
+	OrganisationID string                     `json:"organisation_id"`
+	Version        int                        `json:"version"`
+	Attributes     map[string]json.RawMessage `json:"attributes,omitempty"`
+}
+
+// fieldSelection decides which attributes are returned
+type fieldSelection struct {
+	fields     map[string]bool // requested with `fields[accounts]`, all when empty
+	includePII bool            // personal data is returned only to callers with `pii:read` scope
+}
+
+// parseFieldSelection parses `fields[accounts]` query parameter, it returns errPIINotAllowed when personal data is requested without the scope
+func parseFieldSelection(query string, includePII bool) (*fieldSelection, error) {
+	selection := &fieldSelection{fields: map[string]bool{}, includePII: includePII}
+	if query == "" {
+		return selection, nil
+	}
+	for _, name := range strings.Split(query, ",") {
+		name = strings.TrimSpace(name)
+		isPII, ok := accountAttributes[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown attribute %v in fields[accounts] query parameter", name)
+		}
+		if isPII && !includePII {
+			return nil, errPIINotAllowed
+		}
+		selection.fields[name] = true
+	}
+	return selection, nil
+}
+
+var errPIINotAllowed = fmt.Errorf("Reading personal data requires %v scope", scopePIIRead)
+
+// callerFieldSelection selects all attributes the caller can read, it is used by responses without `fields[accounts]`,
+// so personal data is left out of fetched and created accounts like from listed ones
+func callerFieldSelection(c *gin.Context) *fieldSelection {
+	return &fieldSelection{fields: map[string]bool{}, includePII: hasScope(c, scopePIIRead)}
+}
+
+// tag identifies the representation in entity tags: `pii` or `no-pii`, followed by a hash of requested fields when they are selected
+func (fs *fieldSelection) tag() string {
+	tag := "no-pii"
+	if fs.includePII {
+		tag = "pii"
+	}
+	if len(fs.fields) == 0 {
+		return tag
+	}
+	names := make([]string, 0, len(fs.fields))
+	for name := range fs.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.Join(names, ",")))
+	return fmt.Sprintf("%v-%08x", tag, hash.Sum32())
+}
+
+// view returns the account with the selected attributes
+func (fs *fieldSelection) view(account apiclient.AccountResource) (accountView, error) {
+	view := accountView{
+		Type:           account.Type,
+		ID:             account.ID,
+		OrganisationID: account.OrganisationID,
+		Version:        account.Version,
+	}
+	if account.Attributes == nil {
+		return view, nil
+	}
+	encoded, err := json.Marshal(account.Attributes)
+	if err != nil {
+		return view, err
+	}
+	if err = json.Unmarshal(encoded, &view.Attributes); err != nil {
+		return view, err
+	}
+	for name := range view.Attributes {
+		if (len(fs.fields) > 0 && !fs.fields[name]) || (accountAttributes[name] && !fs.includePII) {
+			delete(view.Attributes, name)
+		}
+	}
+	return view, nil
+}
+
+// resource returns the account with the selected attributes, like `view`, but keeping its type
+func (fs *fieldSelection) resource(account apiclient.AccountResource) (*apiclient.AccountResource, error) {
+	view, err := fs.view(account)
+	if err != nil || view.Attributes == nil {
+		return &account, err
+	}
+	encoded, err := json.Marshal(view.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	account.Attributes = &apiclient.AccountAttributes{}
+	if err = json.Unmarshal(encoded, account.Attributes); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("fieldSelection", func() {
+	var account apiclient.AccountResource
+
+	BeforeEach(func() {
+		account = apiclient.AccountResource{
+			Type:           "account",
+			ID:             "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc",
+			OrganisationID: "eb0bd6f5-c3f5-44b2-b677-acd23cdde73c",
+			Attributes: &apiclient.AccountAttributes{
+				Country: "GB",
+				PrivateIdentification: &apiclient.PrivateIdentification{
+					Identification: "AB123456",
+				},
+			},
+		}
+	})
+
+	It("leaves personal data out of the resource without pii:read scope", func() {
+		resource, err := (&fieldSelection{fields: map[string]bool{}}).resource(account)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resource.ID).Should(Equal(account.ID))
+		Ω(resource.Attributes.Country).Should(Equal("GB"))
+		Ω(resource.Attributes.PrivateIdentification).Should(BeNil())
+		Ω(account.Attributes.PrivateIdentification).ShouldNot(BeNil())
+	})
+
+	It("returns personal data with pii:read scope", func() {
+		resource, err := (&fieldSelection{fields: map[string]bool{}, includePII: true}).resource(account)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resource.Attributes.PrivateIdentification.Identification).Should(Equal("AB123456"))
+	})
+
+	It("keeps a resource without attributes", func() {
+		account.Attributes = nil
+		resource, err := (&fieldSelection{fields: map[string]bool{}}).resource(account)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resource.Attributes).Should(BeNil())
+	})
+
+	It("rejects personal data in fields[accounts] without pii:read scope", func() {
+		_, err := parseFieldSelection("country,private_identification", false)
+		Ω(err).Should(Equal(errPIINotAllowed))
+	})
+
+	It("gives representations with and without personal data different entity tags", func() {
+		withPII := accountETag(3, &fieldSelection{fields: map[string]bool{}, includePII: true})
+		withoutPII := accountETag(3, &fieldSelection{fields: map[string]bool{}})
+		Ω(withPII).ShouldNot(Equal(withoutPII))
+
+		countryOnly, err := parseFieldSelection("country", false)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(accountETag(3, countryOnly)).ShouldNot(Equal(withoutPII))
+	})
+
+	It("reads the account version of entity tags of any representation", func() {
+		for _, etag := range []string{accountETag(3, &fieldSelection{fields: map[string]bool{}, includePII: true}), `"3-no-pii"`, `"3"`} {
+			version, ok := parseETag(etag)
+			Ω(ok).Should(BeTrue())
+			Ω(version).Should(Equal(3))
+		}
+		_, ok := parseETag(`"-3"`)
+		Ω(ok).Should(BeFalse())
+	})
+})
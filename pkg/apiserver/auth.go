@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+	"github.com/gin-gonic/gin"
+)
+
+// principal is an authenticated caller of Account API
+type principal struct {
+	Organisation string   // organisation ID the caller acts on behalf of
+	KeyID        string   // HMAC key used to authenticate the caller
+	Subject      string   // client certificate subject used to authenticate the caller
+	Scopes       []string // permissions granted to the caller on top of acting on behalf of the organisation, e.g. `pii:read`
+}
+
+// scopePIIRead allows reading personal data, e.g. `private_identification` of listed accounts
+const scopePIIRead = "pii:read"
+
+const principalContextKey = "principal"
+
+func setPrincipal(c *gin.Context, p *principal) {
+	c.Set(principalContextKey, p)
+}
+
+// getPrincipal returns authenticated caller, or nil when authentication is not configured
+func getPrincipal(c *gin.Context) *principal {
+	if p, ok := c.Get(principalContextKey); ok {
+		return p.(*principal)
+	}
+	return nil
+}
+
+// hasScope checks if the authenticated caller was granted the scope, callers are not granted any scope when authentication is not configured,
+// so personal data is not returned at all without authentication
+func hasScope(c *gin.Context, scope string) bool {
+	p := getPrincipal(c)
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// canActOnBehalfOf checks if the caller can access resources of the organisation
+func canActOnBehalfOf(c *gin.Context, organisationID string) bool {
+	p := getPrincipal(c)
+	return p == nil || strings.EqualFold(p.Organisation, organisationID)
+}
+
+// callerOrganisation is the organisation of the caller, accounts, imports and jobs of other organisations are not visible to it.
+// It is empty when authentication is not configured.
+func callerOrganisation(c *gin.Context) string {
+	if p := getPrincipal(c); p != nil {
+		return p.Organisation
+	}
+	return ""
+}
+
+// scopeFilter restricts the filter of listed or exported accounts to the organisation of the caller.
+// It returns false when the caller filters accounts of other organisations.
+func scopeFilter(c *gin.Context, filter *apiclient.AccountListFilter) bool {
+	organisation := callerOrganisation(c)
+	if organisation == "" {
+		return true
+	}
+	for _, organisationID := range filter.OrganisationID {
+		if !strings.EqualFold(organisationID, organisation) {
+			return false
+		}
+	}
+	filter.OrganisationID = []string{organisation}
+	return true
+}
+
+//
+// HMAC request signatures
+//
+
+// hmacKey is a secret shared with one organisation
+type hmacKey struct {
+	Organisation string
+	Secret       []byte
+	Scopes       []string
+}
+
+// maxSignedBodySize limits bodies of signed requests which are buffered to verify their digest, e.g. account batches
+const maxSignedBodySize = 10 << 20
+
+// hmacAuthenticator verifies request signatures created by `apiclient.AccountClient` with `RequestSigningConfig`.
+//
+// Requests signed outside of `maxSkew` window, and requests with already used nonce are rejected.
+// When client certificates are required too, requests whose certificate and signing key belong to different organisations are rejected.
+type hmacAuthenticator struct {
+	keys     map[string]hmacKey
+	verifier *httpsig.Verifier
+	logger   *Logger
+}
+
+func newHMACAuthenticator(keys map[string]hmacKey, maxSkew time.Duration, logger *Logger) *hmacAuthenticator {
+	return &hmacAuthenticator{
+		keys: keys,
+		verifier: &httpsig.Verifier{
+			Keys: func(keyID string) ([]byte, bool) {
+				key, ok := keys[keyID]
+				return key.Secret, ok
+			},
+			MaxSkew: maxSkew,
+			Nonces:  httpsig.NewNonceCache(),
+		},
+		logger: logger,
+	}
+}
+
+// middleware reads the body up to maxSignedBodySize before the handler, and rejects it when it does not match its digest
+func (a *hmacAuthenticator) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBodySize))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"status": "error", "message": fmt.Sprintf("Request body is larger than %v bytes", maxSignedBodySize)})
+			return
+		}
+		// handlers read the body again
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		keyID, err := a.verifier.Verify(c.Request, body)
+		a.authenticate(c, keyID, err)
+	}
+}
+
+// streamingMiddleware does not buffer the body, e.g. of imports. The digest is checked when the handler reaches the end of the body,
+// and reading it fails with httpsig.ErrDigestMismatch, so the handler has to discard what it read and call abortWithDigestMismatch.
+func (a *hmacAuthenticator) streamingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID, err := a.verifier.VerifyStream(c.Request)
+		a.authenticate(c, keyID, err)
+	}
+}
+
+func (a *hmacAuthenticator) authenticate(c *gin.Context, keyID string, err error) {
+	if err != nil {
+		loggerFrom(c.Request.Context(), a.logger).Warn("Request signature verification failed", "error", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+		return
+	}
+	key := a.keys[keyID]
+	signed := &principal{
+		Organisation: key.Organisation,
+		KeyID:        keyID,
+		Scopes:       key.Scopes,
+	}
+	// requests authenticated with a client certificate too act on behalf of one organisation, and get only scopes granted to both credentials
+	if certified := getPrincipal(c); certified != nil {
+		if !strings.EqualFold(certified.Organisation, signed.Organisation) {
+			loggerFrom(c.Request.Context(), a.logger).Warn("Request signature and client certificate belong to different organisations",
+				"key_id", keyID, "subject", certified.Subject)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "Request signature and client certificate belong to different organisations"})
+			return
+		}
+		signed.Subject = certified.Subject
+		signed.Scopes = commonScopes(certified.Scopes, key.Scopes)
+	}
+	setPrincipal(c, signed)
+	c.Next()
+}
+
+func commonScopes(scopes []string, other []string) []string {
+	result := []string{}
+	for _, scope := range scopes {
+		for _, o := range other {
+			if scope == o {
+				result = append(result, scope)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// abortWithDigestMismatch responds like hmacAuthenticator when the streamed body does not match its signed digest
+func abortWithDigestMismatch(c *gin.Context, logger *Logger, err error) {
+	loggerFrom(c.Request.Context(), logger).Warn("Request signature verification failed", "error", err)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": fmt.Sprintf("%v", httpsig.ErrDigestMismatch)})
+}
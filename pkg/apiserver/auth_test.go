@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HMAC authentication with client certificates", func() {
+	const (
+		organisation      = "eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"
+		otherOrganisation = "6b9a8ee8-2d6b-4b0c-9a5d-3c0b8b3e6f41"
+	)
+
+	var router *gin.Engine
+
+	// request sends the request signed with `keyID` by the client presenting certificate of `certified` principal
+	request := func(certified *principal, keyID string) (int, *principal) {
+		router = gin.New()
+		router.Use(func(c *gin.Context) {
+			setPrincipal(c, certified)
+			c.Next()
+		})
+		hmacAuth := newHMACAuthenticator(map[string]hmacKey{
+			"key-1": {Organisation: organisation, Secret: []byte("secret-1"), Scopes: []string{scopePIIRead}},
+			"key-2": {Organisation: otherOrganisation, Secret: []byte("secret-2")},
+		}, time.Minute, NewLogger(GinkgoWriter, levelError))
+		router.Use(hmacAuth.middleware())
+		router.GET("/v1/account/", func(c *gin.Context) { c.JSON(http.StatusOK, getPrincipal(c)) })
+
+		req := httptest.NewRequest("GET", "/v1/account/", nil)
+		signer := httpsig.Signer{KeyID: keyID, Secret: []byte(map[string]string{"key-1": "secret-1", "key-2": "secret-2"}[keyID])}
+		Ω(signer.Sign(req, nil)).Should(Succeed())
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			return recorder.Code, nil
+		}
+		result := &principal{}
+		Ω(json.Unmarshal(recorder.Body.Bytes(), result)).Should(Succeed())
+		return recorder.Code, result
+	}
+
+	It("should reject requests whose certificate and key belong to different organisations", func() {
+		code, _ := request(&principal{Organisation: organisation, Subject: "client-1"}, "key-2")
+		Ω(code).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("should accept requests whose certificate and key belong to the same organisation", func() {
+		code, result := request(&principal{Organisation: organisation, Subject: "client-1", Scopes: []string{scopePIIRead}}, "key-1")
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(result).Should(Equal(&principal{Organisation: organisation, KeyID: "key-1", Subject: "client-1", Scopes: []string{scopePIIRead}}))
+	})
+
+	It("should grant only scopes of both the certificate and the key", func() {
+		code, result := request(&principal{Organisation: organisation, Subject: "client-1"}, "key-1")
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(result.Scopes).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPContextKey is the gin context key of the IP address resolved by `clientIPResolution`
+const clientIPContextKey = "client_ip"
+
+// clientIPResolution resolves the IP address of the client, it is the remote address of the connection,
+// unless the connection comes from one of `trustedProxies`, then `X-Forwarded-For` header is read from right to left,
+// and the first address which is not a trusted proxy is the client.
+// Without trusted proxies the header is ignored, so clients cannot choose their address, e.g. to get a new rate limit bucket.
+func clientIPResolution(trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(clientIPContextKey, resolveClientIP(c.Request.RemoteAddr, c.Request.Header.Values("X-Forwarded-For"), trustedProxies))
+		c.Next()
+	}
+}
+
+func resolveClientIP(remoteAddr string, forwardedFor []string, trustedProxies []*net.IPNet) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if !isTrustedProxy(ip, trustedProxies) {
+		return ip
+	}
+	hops := []string{}
+	for _, header := range forwardedFor {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// addresses before a malformed one cannot be trusted
+			return ip
+		}
+		ip = hops[i]
+		if !isTrustedProxy(ip, trustedProxies) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client resolved by `clientIPResolution`, or the remote address of the connection
+func clientIP(c *gin.Context) string {
+	if ip, ok := c.Get(clientIPContextKey); ok {
+		return ip.(string)
+	}
+	return resolveClientIP(c.Request.RemoteAddr, nil, nil)
+}
+
+// getTrustedProxies parses TRUSTED_PROXIES setting, comma separated IP addresses or CIDR networks of proxies setting `X-Forwarded-For` header
+func getTrustedProxies(cfg *Config) ([]*net.IPNet, error) {
+	value, ok := cfg.lookup("TRUSTED_PROXIES")
+	if !ok || value == "" {
+		return nil, nil
+	}
+	proxies := []*net.IPNet{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		cidr := entry
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Error: TRUSTED_PROXIES entry %v is not an IP address or a CIDR network", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
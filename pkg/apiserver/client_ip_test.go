@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client IP", func() {
+	var (
+		resolve = func(trustedProxies string, remoteAddr string, forwardedFor ...string) string {
+			proxies, err := getTrustedProxies(&Config{values: map[string]string{"TRUSTED_PROXIES": trustedProxies}})
+			Ω(err).ShouldNot(HaveOccurred())
+			router := gin.New()
+			router.Use(clientIPResolution(proxies))
+			resolved := ""
+			router.GET("/", func(c *gin.Context) { resolved = clientIP(c) })
+			request := httptest.NewRequest("GET", "/", nil)
+			request.RemoteAddr = remoteAddr
+			for _, header := range forwardedFor {
+				request.Header.Add("X-Forwarded-For", header)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), request)
+			return resolved
+		}
+	)
+
+	It("should ignore X-Forwarded-For header without trusted proxies", func() {
+		Ω(resolve("", "203.0.113.7:5000", "198.51.100.1")).Should(Equal("203.0.113.7"))
+	})
+
+	It("should ignore X-Forwarded-For header sent by a client which is not a trusted proxy", func() {
+		Ω(resolve("10.0.0.0/8", "203.0.113.7:5000", "198.51.100.1")).Should(Equal("203.0.113.7"))
+	})
+
+	It("should take the first address which is not a trusted proxy, from the right", func() {
+		Ω(resolve("10.0.0.0/8,192.0.2.1", "10.0.0.2:5000", "198.51.100.1, 203.0.113.7", "192.0.2.1")).Should(Equal("203.0.113.7"))
+	})
+
+	It("should take the leftmost address when all of them are trusted proxies", func() {
+		Ω(resolve("10.0.0.0/8", "10.0.0.2:5000", "10.0.0.3")).Should(Equal("10.0.0.3"))
+	})
+
+	It("should stop at a malformed address", func() {
+		Ω(resolve("10.0.0.0/8", "10.0.0.2:5000", "198.51.100.1, unknown, 10.0.0.3")).Should(Equal("10.0.0.3"))
+	})
+
+	It("should use IPv6 addresses", func() {
+		Ω(resolve("::1", "[::1]:5000", "2001:db8::1")).Should(Equal("2001:db8::1"))
+	})
+
+	It("should use the remote address without the resolution middleware", func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		c.Request.RemoteAddr = "203.0.113.7:5000"
+		c.Request.Header.Set("X-Forwarded-For", "198.51.100.1")
+		Ω(clientIP(c)).Should(Equal("203.0.113.7"))
+	})
+
+	Context("when requests are rate limited", func() {
+
+		It("should not give clients a new bucket for every X-Forwarded-For value", func() {
+			router := gin.New()
+			router.Use(clientIPResolution(nil))
+			limiter := &rateLimiter{
+				limits: map[string]rateLimit{"read": {requests: 1, period: time.Minute}},
+				store:  newMemoryRateLimitStore(),
+				logger: NewLogger(GinkgoWriter, levelError),
+			}
+			router.GET("/", limiter.middleware("read"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			codes := []int{}
+			for _, forwardedFor := range []string{"198.51.100.1", "198.51.100.2"} {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest("GET", "/", nil)
+				request.Header.Set("X-Forwarded-For", forwardedFor)
+				router.ServeHTTP(recorder, request)
+				codes = append(codes, recorder.Code)
+			}
+			Ω(codes).Should(Equal([]int{http.StatusOK, http.StatusTooManyRequests}))
+		})
+	})
+
+	DescribeTable("invalid trusted proxies",
+		func(value string) {
+			_, err := getTrustedProxies(&Config{values: map[string]string{"TRUSTED_PROXIES": value}})
+			Ω(err).Should(MatchError("Error: TRUSTED_PROXIES entry " + value + " is not an IP address or a CIDR network"))
+		},
+		Entry("host name", "proxy.local"),
+		Entry("network", "10.0.0.0/33"),
+	)
+})
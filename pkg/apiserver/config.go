@@ -0,0 +1,353 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"gopkg.in/yaml.v2"
+)
+
+// setting is a configuration value of the server.
+// It can be set with a command line flag, an env variable or a key in the configuration file, in this order of precedence.
+type setting struct {
+	env    string // env variable, also used to refer to the setting in error messages, e.g. `DB_HOST`
+	key    string // key in the configuration file, e.g. `db.host`, nested keys are joined with dots
+	usage  string
+	secret bool // redacted when the configuration is printed
+}
+
+// flagName returns name of the command line flag, e.g. `db-host`
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+// configFileEnv is the env variable with path of the configuration file, it can be also given with `--config` flag
+const configFileEnv = "APISERVER_CONFIG"
+
+var settings = []setting{
+	{env: "LISTEN_ADDRESS", key: "listen_address", usage: "address the server listens on, e.g. `127.0.0.1:8080`, overrides port"},
+	{env: "PORT", key: "port", usage: "port the server listens on, on all interfaces (default 8080)"},
+	{env: "LOG_LEVEL", key: "log_level", usage: "level of logged entries: debug, info, warn or error (default info)"},
+	{env: "SHUTDOWN_GRACE_PERIOD", key: "shutdown_grace_period", usage: "how long in-flight requests are waited for on shutdown (default 30s)"},
+	{env: "SHUTDOWN_DELAY", key: "shutdown_delay", usage: "how long the server keeps serving after readiness fails on shutdown, so load balancers stop sending requests (default 0s)"},
+
+	{env: "HTTP_READ_HEADER_TIMEOUT", key: "http.read_header_timeout", usage: "maximum time to read request headers (default 10s)"},
+	{env: "HTTP_READ_TIMEOUT", key: "http.read_timeout", usage: "maximum time to read the whole request (default 30s)"},
+	{env: "HTTP_WRITE_TIMEOUT", key: "http.write_timeout", usage: "maximum time to write the response (default 30s)"},
+	{env: "HTTP_IDLE_TIMEOUT", key: "http.idle_timeout", usage: "how long idle keep-alive connections are kept open (default 2m)"},
+	{env: "HTTP_STREAM_TIMEOUT", key: "http.stream_timeout", usage: "maximum time to upload imports and download exports, instead of read and write timeouts. 0 disables it, then a slow client keeps an import transaction and its connection open without limit (default 1h)"},
+
+	{env: "DB_URL", key: "db.url", usage: "Postgres connection string, other db settings override its parts", secret: true},
+	{env: "DB_HOST", key: "db.host", usage: "Postgres host, required without db.url"},
+	{env: "DB_PORT", key: "db.port", usage: "Postgres port, required without db.url"},
+	{env: "DB_USER", key: "db.user", usage: "Postgres user, required without db.url"},
+	{env: "DB_PASSWORD", key: "db.password", usage: "Postgres password, required without db.url", secret: true},
+	{env: "DB_DATABASE", key: "db.database", usage: "Postgres database, required without db.url"},
+	{env: "DB_MAX_CONNS", key: "db.max_conns", usage: "maximum size of the connection pool (default greater of 4 and number of CPUs)"},
+	{env: "DB_MIN_CONNS", key: "db.min_conns", usage: "minimum number of connections kept open (default 0)"},
+	{env: "DB_MAX_CONN_LIFETIME", key: "db.max_conn_lifetime", usage: "connections older than this are closed (default 1h)"},
+	{env: "DB_MAX_CONN_IDLE_TIME", key: "db.max_conn_idle_time", usage: "connections idle longer than this are closed (default 30m)"},
+	{env: "DB_HEALTH_CHECK_PERIOD", key: "db.health_check_period", usage: "how often idle connections are checked (default 1m)"},
+	{env: "DB_CONNECT_TIMEOUT", key: "db.connect_timeout", usage: "maximum time to establish a connection (default no limit)"},
+	{env: "DB_STATEMENT_TIMEOUT", key: "db.statement_timeout", usage: "queries of a request are cancelled after this time, 0 disables it (default 5s)"},
+	{env: "DB_STATEMENT_TIMEOUTS", key: "db.statement_timeouts", usage: "comma separated `route=duration` entries overriding the statement timeout of routes: list, fetch, create, batch, delete, import, export or job (default import=0,export=0)"},
+	{env: "BATCH_MAX_SIZE", key: "batch.max_size", usage: "maximum number of accounts created with one batch request (default 1000)"},
+	{env: "JOB_WORKERS", key: "job.workers", usage: "number of asynchronous imports and exports run at the same time by this instance, 0 disables running jobs (default 2)"},
+	{env: "JOB_POLL_INTERVAL", key: "job.poll_interval", usage: "how often workers check for queued jobs (default 1s)"},
+
+	{env: "METRICS_ENABLED", key: "metrics.enabled", usage: "expose Prometheus metrics on /metrics (default true)"},
+	{env: "METRICS_REFRESH_INTERVAL", key: "metrics.refresh_interval", usage: "how often account gauges are recounted (default 1m)"},
+	{env: "OTEL_TRACES_EXPORTER", key: "tracing.exporter", usage: "trace exporter: none, stdout or otlp (default none)"},
+	{env: "OTEL_EXPORTER_OTLP_ENDPOINT", key: "tracing.otlp_endpoint", usage: "host:port of the OpenTelemetry collector receiving OTLP over gRPC (default localhost:4317)"},
+	{env: "OTEL_EXPORTER_OTLP_INSECURE", key: "tracing.otlp_insecure", usage: "connect the OpenTelemetry collector without TLS (default false)"},
+
+	{env: "PII_KEKS", key: "pii.keks", usage: "comma separated `keyID:base64Key` key-encryption keys, personal data is stored in plaintext when not set", secret: true},
+	{env: "PII_ACTIVE_KEK", key: "pii.active_kek", usage: "ID of the key used to encrypt new data, can be omitted with one key"},
+	{env: "PII_ROTATION_ENABLED", key: "pii.rotation_enabled", usage: "re-encrypt data encrypted with inactive keys in background (default true)"},
+	{env: "PII_ROTATION_INTERVAL", key: "pii.rotation_interval", usage: "how often data encrypted with inactive keys is looked for (default 1m)"},
+	{env: "PII_ROTATION_BATCH_SIZE", key: "pii.rotation_batch_size", usage: "number of accounts re-encrypted in one transaction (default 100)"},
+
+	{env: "RATE_LIMITS", key: "rate_limit.limits", usage: "comma separated `class=requests/period` limits of read, write and list routes, e.g. `read=100/1m`, requests are not limited when not set"},
+	{env: "RATE_LIMIT_STORE", key: "rate_limit.store", usage: "where limits are kept: memory, per server instance, or postgres, shared between instances (default memory)"},
+	{env: "RATE_LIMIT_KEY", key: "rate_limit.key", usage: "who is limited: organisation or principal, i.e. HMAC key or client certificate (default organisation)"},
+	{env: "TRUSTED_PROXIES", key: "trusted_proxies", usage: "comma separated IP addresses or CIDR networks of proxies whose `X-Forwarded-For` header gives the client IP, the header is ignored when not set"},
+
+	{env: "HMAC_KEYS", key: "hmac.keys", usage: "comma separated `keyID:organisationID:secret` request signing keys, signatures are not required when not set. With client certificates, the key and the certificate have to belong to the same organisation", secret: true},
+	{env: "HMAC_KEY_SCOPES", key: "hmac.key_scopes", usage: "comma separated `keyID=scope scope` entries granting scopes to keys"},
+	{env: "HMAC_MAX_SKEW", key: "hmac.max_skew", usage: "maximum age of a request signature (default 5m)"},
+
+	{env: "TLS_CERT_FILE", key: "tls.cert_file", usage: "server certificate, the server uses plain HTTP when not set"},
+	{env: "TLS_KEY_FILE", key: "tls.key_file", usage: "server private key"},
+	{env: "TLS_CLIENT_CA_FILE", key: "tls.client_ca_file", usage: "CA bundle, when set clients of account routes have to present a certificate signed by it"},
+	{env: "TLS_CLIENT_ORGANISATIONS", key: "tls.client_organisations", usage: "comma separated `commonName=organisationID` entries assigning client certificates to organisations"},
+	{env: "TLS_CLIENT_SCOPES", key: "tls.client_scopes", usage: "comma separated `commonName=scope scope` entries granting scopes to client certificates"},
+	{env: "TLS_MIN_VERSION", key: "tls.min_version", usage: "minimum TLS version: 1.2 or 1.3 (default 1.2)"},
+}
+
+// Config holds values of settings resolved from all sources
+type Config struct {
+	values  map[string]string // by env name
+	sources map[string]string // where values come from, e.g. `flag --db-host`
+}
+
+// lookup returns value of the setting, `ok` is false when it is not set in any source
+func (c *Config) lookup(env string) (value string, ok bool) {
+	value, ok = c.values[env]
+	return value, ok
+}
+
+// get returns value of the setting, or an empty string when it is not set
+func (c *Config) get(env string) string {
+	return c.values[env]
+}
+
+// configFlags are command line flags of all settings
+type configFlags struct {
+	flagSet *flag.FlagSet
+	file    *string
+	values  map[string]*string // by env name
+}
+
+// registerConfigFlags defines flags of all settings, and `--config` flag with path of the configuration file
+func registerConfigFlags(flagSet *flag.FlagSet) *configFlags {
+	flags := &configFlags{
+		flagSet: flagSet,
+		file:    flagSet.String("config", "", "YAML or JSON configuration file, also read from "+configFileEnv+" env variable"),
+		values:  map[string]*string{},
+	}
+	for _, s := range settings {
+		flags.values[s.env] = flagSet.String(s.flagName(), "", s.usage+", env "+s.env)
+	}
+	return flags
+}
+
+// load resolves settings, flags have to be parsed before
+func (f *configFlags) load() (*Config, error) {
+	config := &Config{
+		values:  map[string]string{},
+		sources: map[string]string{},
+	}
+
+	path := *f.file
+	if path == "" {
+		path = os.Getenv(configFileEnv)
+	}
+	if path != "" {
+		fileValues, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range settings {
+			if value, ok := fileValues[s.key]; ok {
+				config.values[s.env] = value
+				config.sources[s.env] = "file " + path
+				delete(fileValues, s.key)
+			}
+		}
+		if len(fileValues) > 0 {
+			keys := []string{}
+			for key := range fileValues {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			return nil, fmt.Errorf("Error: unknown keys in configuration file %v: %v", path, strings.Join(keys, ", "))
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			config.values[s.env] = value
+			config.sources[s.env] = "env " + s.env
+		}
+	}
+
+	set := map[string]bool{}
+	f.flagSet.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	for _, s := range settings {
+		if set[s.flagName()] {
+			config.values[s.env] = *f.values[s.env]
+			config.sources[s.env] = "flag --" + s.flagName()
+		}
+	}
+	return config, nil
+}
+
+// readConfigFile reads a YAML (or JSON, which is a subset of YAML) file and flattens nested keys, e.g. `db: {host: x}` becomes `db.host: x`.
+// Lists are joined with commas, e.g. `hmac.keys: [a, b]` is the same as `hmac.keys: a,b`.
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error: cannot read configuration file: %v", err)
+	}
+	document := map[interface{}]interface{}{}
+	if err = yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("Error: cannot parse configuration file %v: %v", path, err)
+	}
+	values := map[string]string{}
+	if err = flattenConfig("", document, values); err != nil {
+		return nil, fmt.Errorf("Error: configuration file %v: %v", path, err)
+	}
+	return values, nil
+}
+
+func flattenConfig(prefix string, document map[interface{}]interface{}, values map[string]string) error {
+	for key, value := range document {
+		name := fmt.Sprint(key)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		switch value := value.(type) {
+		case map[interface{}]interface{}:
+			if err := flattenConfig(name, value, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				if _, ok := item.(map[interface{}]interface{}); ok {
+					return fmt.Errorf("%v list should contain only scalar values", name)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// print writes settings which are set as a configuration file, with sources of values in comments.
+// When `redacted` is true values of secret settings are replaced with `apiclient.RedactedValue`.
+func (c *Config) print(out io.Writer, redacted bool) error {
+	for _, s := range settings {
+		value, ok := c.lookup(s.env)
+		if !ok {
+			continue
+		}
+		if redacted && s.secret {
+			value = apiclient.RedactedValue
+		}
+		encoded, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(out, "%v: %v # %v\n", s.key, strings.TrimSpace(string(encoded)), c.sources[s.env]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configCommand runs `apiserver config validate` and `apiserver config print [--redacted]` commands, it returns the exit code
+func configCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	usage := "Usage: apiserver config validate|print [--redacted] [flags]"
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+	command := args[0]
+	flagSet := flag.NewFlagSet("apiserver config "+command, flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+	flags := registerConfigFlags(flagSet)
+	redacted := false
+	switch command {
+	case "validate":
+	case "print":
+		flagSet.BoolVar(&redacted, "redacted", false, "replace secret values with "+apiclient.RedactedValue)
+	default:
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+	if err := flagSet.Parse(args[1:]); err != nil {
+		return 2
+	}
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if command == "print" {
+		if err = config.print(stdout, redacted); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+	server, err := parseServerConfig(config)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if server.TLS != nil {
+		if _, err = newCertificateReloader(server.TLS, NewLogger(ioutil.Discard, levelError)); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	fmt.Fprintln(stdout, "Configuration is valid")
+	return 0
+}
+
+// getDuration parses a non-negative duration setting, e.g. `30s`
+func getDuration(cfg *Config, env string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := cfg.lookup(env)
+	if !ok {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("Error: %v setting is not a duration %v", env, value)
+	}
+	return duration, nil
+}
+
+// getPositiveDuration parses a duration setting greater than zero
+func getPositiveDuration(cfg *Config, env string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := cfg.lookup(env)
+	if !ok {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("Error: %v setting is not a positive duration %v", env, value)
+	}
+	return duration, nil
+}
+
+// getInt parses a non-negative number setting
+func getInt(cfg *Config, env string, defaultValue int) (int, error) {
+	value, ok := cfg.lookup(env)
+	if !ok {
+		return defaultValue, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("Error: %v setting is not a number %v", env, value)
+	}
+	return number, nil
+}
+
+// getBool parses a boolean setting, e.g. `true` or `false`
+func getBool(cfg *Config, env string, defaultValue bool) (bool, error) {
+	value, ok := cfg.lookup(env)
+	if !ok {
+		return defaultValue, nil
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Error: %v setting should be true or false, got %v", env, value)
+	}
+	return result, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// keyring holds key-encryption keys (KEK) used to wrap data keys of encrypted personal data.
+// New data is encrypted with the active key, the other keys are kept to decrypt data until it is rotated.
+type keyring struct {
+	active string
+	keys   map[string][]byte // AES-256 keys by ID
+}
+
+// envelope is personal data encrypted with a random data key, the data key is encrypted (wrapped) with a KEK
+type envelope struct {
+	KEKID      string `json:"kek_id"`
+	WrappedKey []byte `json:"wrapped_key"` // nonce followed by AES-GCM sealed data key
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"` // AES-GCM sealed data, authenticated together with the account ID
+}
+
+// seal encrypts the plaintext with a new data key, `associatedData` has to be the same when the envelope is opened
+func (k *keyring) seal(plaintext []byte, associatedData []byte) (*envelope, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := k.wrap(k.active, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{
+		KEKID:      k.active,
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, associatedData),
+	}, nil
+}
+
+// open decrypts the envelope
+func (k *keyring) open(env *envelope, associatedData []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(env.KEKID, env.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("wrong nonce size %v", len(env.Nonce))
+	}
+	return aead.Open(nil, env.Nonce, env.Ciphertext, associatedData)
+}
+
+// rewrap wraps the data key of the envelope with the active KEK, encrypted data is not changed
+func (k *keyring) rewrap(env *envelope) (*envelope, error) {
+	dataKey, err := k.unwrap(env.KEKID, env.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := k.wrap(k.active, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{
+		KEKID:      k.active,
+		WrappedKey: wrappedKey,
+		Nonce:      env.Nonce,
+		Ciphertext: env.Ciphertext,
+	}, nil
+}
+
+func (k *keyring) wrap(kekID string, dataKey []byte) ([]byte, error) {
+	aead, err := k.kek(kekID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(kekID)), nil
+}
+
+func (k *keyring) unwrap(kekID string, wrappedKey []byte) ([]byte, error) {
+	aead, err := k.kek(kekID)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key of %v is too short", kekID)
+	}
+	nonce, sealed := wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(kekID))
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap data key with %v: %v", kekID, err)
+	}
+	return dataKey, nil
+}
+
+func (k *keyring) kek(kekID string) (cipher.AEAD, error) {
+	key, ok := k.keys[kekID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key-encryption key %v", kekID)
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealJSON encrypts JSON encoding of the value
+func (k *keyring) sealJSON(v interface{}, associatedData []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	env, err := k.seal(plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// openJSON decrypts the encoded envelope into the value
+func (k *keyring) openJSON(encoded []byte, associatedData []byte, v interface{}) error {
+	env := envelope{}
+	if err := json.Unmarshal(encoded, &env); err != nil {
+		return err
+	}
+	plaintext, err := k.open(&env, associatedData)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+// EncryptionConfig configures encryption of personal data at rest
+type EncryptionConfig struct {
+	Keyring           *keyring
+	RotationEnabled   bool          // whether this instance re-encrypts data encrypted with inactive keys
+	RotationInterval  time.Duration // how often accounts encrypted with inactive keys are looked for
+	RotationBatchSize int           // number of accounts re-encrypted in one transaction
+}
+
+// getEncryptionConfig reads key-encryption keys, when PII_KEKS is not set personal data is stored in plaintext.
+//   - PII_KEKS - comma separated `keyID:base64Key` entries with 32 bytes AES keys,
+//   - PII_ACTIVE_KEK - ID of the key used to encrypt new data, it can be omitted when there is only one key,
+//   - PII_ROTATION_ENABLED - whether data encrypted with other keys is re-encrypted by this instance, `true` by default,
+//   - PII_ROTATION_INTERVAL - how often data encrypted with other keys is re-encrypted, `1m` by default,
+//   - PII_ROTATION_BATCH_SIZE - number of accounts re-encrypted in one transaction, 100 by default.
+func getEncryptionConfig(cfg *Config) (*EncryptionConfig, error) {
+	value, ok := cfg.lookup("PII_KEKS")
+	if !ok || value == "" {
+		return nil, nil
+	}
+	config := EncryptionConfig{
+		Keyring: &keyring{keys: map[string][]byte{}},
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Error: PII_KEKS entry is not in keyID:base64Key format")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("Error: PII_KEKS entry %v is not a base64 encoded 32 bytes key", parts[0])
+		}
+		config.Keyring.keys[parts[0]] = key
+		config.Keyring.active = parts[0]
+	}
+	if active, ok := cfg.lookup("PII_ACTIVE_KEK"); ok {
+		if _, known := config.Keyring.keys[active]; !known {
+			return nil, fmt.Errorf("Error: PII_ACTIVE_KEK setting %v is not in PII_KEKS", active)
+		}
+		config.Keyring.active = active
+	} else if len(config.Keyring.keys) > 1 {
+		return nil, fmt.Errorf("Error: PII_ACTIVE_KEK setting not set")
+	}
+	var err error
+	if config.RotationEnabled, err = getBool(cfg, "PII_ROTATION_ENABLED", true); err != nil {
+		return nil, err
+	}
+	if config.RotationInterval, err = getPositiveDuration(cfg, "PII_ROTATION_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if config.RotationBatchSize, err = getInt(cfg, "PII_ROTATION_BATCH_SIZE", 100); err != nil {
+		return nil, err
+	}
+	if config.RotationBatchSize <= 0 {
+		return nil, fmt.Errorf("Error: PII_ROTATION_BATCH_SIZE setting is not a positive number %v", config.RotationBatchSize)
+	}
+	return &config, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("keyring", func() {
+	var (
+		oldKey = bytes.Repeat([]byte{1}, 32)
+		newKey = bytes.Repeat([]byte{2}, 32)
+
+		accountID = []byte("ad27e265-9605-4b4b-a0e5-3003ea9cc4dc")
+		plaintext = []byte(`{"identification":"AB123456"}`)
+	)
+
+	It("should open sealed data with the same associated data", func() {
+		k := &keyring{active: "old", keys: map[string][]byte{"old": oldKey}}
+		env, err := k.seal(plaintext, accountID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(env.KEKID).Should(Equal("old"))
+		Ω(env.Ciphertext).ShouldNot(ContainSubstring("AB123456"))
+
+		opened, err := k.open(env, accountID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(opened).Should(Equal(plaintext))
+	})
+
+	It("should not open data sealed for another account", func() {
+		k := &keyring{active: "old", keys: map[string][]byte{"old": oldKey}}
+		env, err := k.seal(plaintext, accountID)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = k.open(env, []byte("0d209d7f-d07a-4542-947f-5885fddddae2"))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should not open data wrapped with an unknown key", func() {
+		env, err := (&keyring{active: "old", keys: map[string][]byte{"old": oldKey}}).seal(plaintext, accountID)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = (&keyring{active: "new", keys: map[string][]byte{"new": newKey}}).open(env, accountID)
+		Ω(err).Should(MatchError("unknown key-encryption key old"))
+	})
+
+	It("should not open data key wrapped with another key of the same ID", func() {
+		env, err := (&keyring{active: "old", keys: map[string][]byte{"old": oldKey}}).seal(plaintext, accountID)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = (&keyring{active: "old", keys: map[string][]byte{"old": newKey}}).open(env, accountID)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	Context("when the active key is rotated", func() {
+
+		It("should rewrap the data key without changing encrypted data", func() {
+			env, err := (&keyring{active: "old", keys: map[string][]byte{"old": oldKey}}).seal(plaintext, accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			rotating := &keyring{active: "new", keys: map[string][]byte{"old": oldKey, "new": newKey}}
+			rotated, err := rotating.rewrap(env)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(rotated.KEKID).Should(Equal("new"))
+			Ω(rotated.Nonce).Should(Equal(env.Nonce))
+			Ω(rotated.Ciphertext).Should(Equal(env.Ciphertext))
+			Ω(rotated.WrappedKey).ShouldNot(Equal(env.WrappedKey))
+
+			// the old key can be removed after rotation
+			opened, err := (&keyring{active: "new", keys: map[string][]byte{"new": newKey}}).open(rotated, accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(opened).Should(Equal(plaintext))
+		})
+
+		It("should fail to rewrap data wrapped with a key missing in the keyring", func() {
+			env, err := (&keyring{active: "old", keys: map[string][]byte{"old": oldKey}}).seal(plaintext, accountID)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = (&keyring{active: "new", keys: map[string][]byte{"new": newKey}}).rewrap(env)
+			Ω(err).Should(MatchError("unknown key-encryption key old"))
+		})
+	})
+
+	It("should seal and open JSON values", func() {
+		k := &keyring{active: "old", keys: map[string][]byte{"old": oldKey}}
+		encoded, err := k.sealJSON(map[string]string{"identification": "AB123456"}, accountID)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		value := map[string]string{}
+		Ω(k.openJSON(encoded, accountID, &value)).Should(Succeed())
+		Ω(value).Should(Equal(map[string]string{"identification": "AB123456"}))
+	})
+})
+
+var _ = Describe("getEncryptionConfig", func() {
+	var (
+		oldKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+		newKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	)
+
+	It("should store personal data in plaintext without keys", func() {
+		config, err := getEncryptionConfig(&Config{values: map[string]string{}})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config).Should(BeNil())
+	})
+
+	It("should use the only key as the active key", func() {
+		config, err := getEncryptionConfig(&Config{values: map[string]string{"PII_KEKS": "old:" + oldKey}})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.Keyring.active).Should(Equal("old"))
+		Ω(config.RotationEnabled).Should(BeTrue())
+		Ω(config.RotationInterval).Should(Equal(time.Minute))
+		Ω(config.RotationBatchSize).Should(Equal(100))
+	})
+
+	It("should require the active key when there are many keys", func() {
+		_, err := getEncryptionConfig(&Config{values: map[string]string{"PII_KEKS": "old:" + oldKey + ",new:" + newKey}})
+		Ω(err).Should(MatchError("Error: PII_ACTIVE_KEK setting not set"))
+
+		config, err := getEncryptionConfig(&Config{values: map[string]string{"PII_KEKS": "old:" + oldKey + ",new:" + newKey, "PII_ACTIVE_KEK": "new"}})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.Keyring.active).Should(Equal("new"))
+		Ω(config.Keyring.keys).Should(HaveLen(2))
+	})
+
+	It("should reject keys which are not 32 bytes long", func() {
+		_, err := getEncryptionConfig(&Config{values: map[string]string{"PII_KEKS": "old:" + base64.StdEncoding.EncodeToString([]byte("short"))}})
+		Ω(err).Should(MatchError("Error: PII_KEKS entry old is not a base64 encoded 32 bytes key"))
+	})
+
+	It("should reject an active key missing in the keys", func() {
+		_, err := getEncryptionConfig(&Config{values: map[string]string{"PII_KEKS": "old:" + oldKey, "PII_ACTIVE_KEK": "new"}})
+		Ω(err).Should(MatchError("Error: PII_ACTIVE_KEK setting new is not in PII_KEKS"))
+	})
+})
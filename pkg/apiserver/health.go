@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// readinessTimeout limits time spent on readiness checks, so checks fail instead of hanging when Postgres is unreachable
+	readinessTimeout = 2 * time.Second
+	// readinessInterval is how often readiness is checked in background
+	readinessInterval = 5 * time.Second
+)
+
+var (
+	errNotChecked = errors.New("readiness not checked yet")
+	errDraining   = errors.New("server is shutting down")
+)
+
+// livez reports that the process is running and serving requests
+func livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// readiness holds the result of the last readiness check, probes read it, so they neither wait for nor take connections of the pool
+type readiness struct {
+	mu       *sync.Mutex
+	err      error // nil when the server can handle account requests
+	draining bool  // set on shutdown, the server is not ready from then on
+}
+
+func newReadiness() *readiness {
+	return &readiness{
+		mu:  &sync.Mutex{},
+		err: errNotChecked,
+	}
+}
+
+// check returns nil when the server is ready
+func (r *readiness) check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return errDraining
+	}
+	return r.err
+}
+
+// set records the result of a readiness check, it returns true when readiness changed
+func (r *readiness) set(err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := (r.err == nil) != (err == nil)
+	r.err = err
+	return changed
+}
+
+// drain makes the server not ready, so load balancers stop sending requests before connections are drained
+func (r *readiness) drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.draining = true
+}
+
+// watch checks readiness every `interval` until the context is cancelled.
+// Checks use their own connection, so they pass when all connections of the pool are busy.
+func (r *readiness) watch(ctx context.Context, accountService *AccountService, interval time.Duration, logger *Logger) {
+	var conn *pgx.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close(context.Background())
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		var err error
+		conn, err = accountService.ready(checkCtx, conn)
+		cancel()
+		if r.set(err) {
+			if err != nil {
+				logger.Warn("Readiness check failed", "error", err)
+			} else {
+				logger.Info("Server is ready")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readyz reports if the server can handle account requests: Postgres is reachable, its schema is migrated and the server is not shutting down
+func readyz(r *readiness) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := r.check(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "unavailable",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Readiness", func() {
+	var (
+		ready  *readiness
+		router *gin.Engine
+
+		probe = func(path string) int {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+			return recorder.Code
+		}
+	)
+
+	BeforeEach(func() {
+		ready = newReadiness()
+		router = gin.New()
+		router.GET("/v1/readyz", readyz(ready))
+		router.GET("/v1/health", readyz(ready))
+	})
+
+	It("should not be ready before the first check", func() {
+		Ω(probe("/v1/readyz")).Should(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should report the result of the last check", func() {
+		Ω(ready.set(nil)).Should(BeTrue())
+		Ω(probe("/v1/readyz")).Should(Equal(http.StatusOK))
+		Ω(probe("/v1/health")).Should(Equal(http.StatusOK))
+
+		Ω(ready.set(errors.New("connecting to Postgres"))).Should(BeTrue())
+		Ω(ready.set(errors.New("connecting to Postgres"))).Should(BeFalse())
+		Ω(probe("/v1/readyz")).Should(Equal(http.StatusServiceUnavailable))
+		Ω(probe("/v1/health")).Should(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should not be ready when draining, whatever the checks report", func() {
+		ready.set(nil)
+		ready.drain()
+		ready.set(nil)
+		Ω(ready.check()).Should(Equal(errDraining))
+		Ω(probe("/v1/readyz")).Should(Equal(http.StatusServiceUnavailable))
+	})
+
+	Context("when the server is shutting down", func() {
+
+		It("should stop being ready before draining connections", func() {
+			ready.set(nil)
+			server := &http.Server{Addr: "127.0.0.1:0", Handler: router}
+			signals := make(chan os.Signal, 1)
+			done := make(chan bool, 1)
+			go func() {
+				done <- serveAndDrain(server, signals, ready, 300*time.Millisecond, time.Second, NewLogger(GinkgoWriter, levelError))
+			}()
+
+			signals <- syscall.SIGTERM
+			Eventually(ready.check).Should(Equal(errDraining))
+			Consistently(done, 200*time.Millisecond).ShouldNot(Receive())
+			Eventually(done).Should(Receive(BeTrue()))
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+)
+
+// importHMACSecretEnv is the env variable with the request signing secret of `apiserver import`, it is not a flag so it is not visible in the process list
+const importHMACSecretEnv = "IMPORT_HMAC_SECRET"
+
+// importCommand runs `apiserver import [-url URL] [-conflict POLICY] [-report FILE] [auth flags] FILE` command, it returns the exit code.
+// It streams the newline-delimited accounts file to a running server, and saves lines which were not imported to the report file.
+func importCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	usage := "Usage: apiserver import [-url URL] [-conflict skip|fail|overwrite-if-newer] [-report FILE] [-hmac-key-id ID] [-tls-ca-file FILE] [-tls-cert-file FILE -tls-key-file FILE] FILE"
+	flagSet := flag.NewFlagSet("apiserver import", flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+	serverURL := flagSet.String("url", "http://localhost:8080/v1/account", "account API url address")
+	conflict := flagSet.String("conflict", "skip", "policy of accounts which already exist: skip, fail or overwrite-if-newer")
+	reportFile := flagSet.String("report", "", "file saving lines which were not imported (default import-<id>-rejections.ndjson)")
+	hmacKeyID := flagSet.String("hmac-key-id", "", "ID of the request signing key, the secret is read from "+importHMACSecretEnv+" env variable")
+	tlsConfig := apiclient.TLSConfig{}
+	flagSet.StringVar(&tlsConfig.CAFile, "tls-ca-file", "", "CA bundle verifying the server certificate (default system roots)")
+	flagSet.StringVar(&tlsConfig.CertFile, "tls-cert-file", "", "client certificate, when the server requires one")
+	flagSet.StringVar(&tlsConfig.KeyFile, "tls-key-file", "", "private key of the client certificate")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if flagSet.NArg() != 1 || !importConflicts[*conflict] {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+	clientConfig, err := importClientConfig(*serverURL, *hmacKeyID, tlsConfig)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	file, err := os.Open(flagSet.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	client := apiclient.NewAccountClient(clientConfig)
+	ctx := context.Background()
+	body := &progressReader{reader: file}
+	stopProgress := body.report(stderr, time.Second)
+	summary, err := client.Import(ctx, body, apiclient.ImportConflict(*conflict))
+	stopProgress()
+	if summary != nil {
+		encoded, _ := json.MarshalIndent(summary, "", "  ")
+		fmt.Fprintln(stdout, string(encoded))
+	}
+	if err != nil && (summary == nil || !errors.Is(err, apiclient.ErrImportFailed)) {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if summary.Skipped+summary.Rejected > 0 && summary.Report != "" {
+		if *reportFile == "" {
+			*reportFile = fmt.Sprintf("import-%v-rejections.ndjson", summary.ID)
+		}
+		if reportErr := saveImportReport(ctx, client, summary, *reportFile); reportErr != nil {
+			fmt.Fprintln(stderr, reportErr)
+			return 1
+		}
+		fmt.Fprintf(stderr, "Lines which were not imported saved to %v\n", *reportFile)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// importClientConfig configures the client of `apiserver import`, requests are signed when `hmacKeyID` is set,
+// and TLS options are used when any of them is set
+func importClientConfig(serverURL string, hmacKeyID string, tlsConfig apiclient.TLSConfig) (*apiclient.AccountClientConfig, error) {
+	config := &apiclient.AccountClientConfig{URL: serverURL, Timeout: time.Minute}
+	if hmacKeyID != "" {
+		secret := os.Getenv(importHMACSecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("Error: %v env variable not set, it is required with -hmac-key-id", importHMACSecretEnv)
+		}
+		config.RequestSigning = &apiclient.RequestSigningConfig{KeyID: hmacKeyID, Secret: []byte(secret)}
+	}
+	if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		return nil, fmt.Errorf("Error: -tls-cert-file and -tls-key-file have to be set together")
+	}
+	if tlsConfig != (apiclient.TLSConfig{}) {
+		config.TLS = &tlsConfig
+	}
+	return config, nil
+}
+
+func saveImportReport(ctx context.Context, client *apiclient.AccountClient, summary *apiclient.ImportSummary, path string) error {
+	report, err := client.ImportReport(ctx, summary)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// progressReader counts lines and bytes read from the import file
+type progressReader struct {
+	reader io.Reader
+	bytes  int64 // accessed atomically
+	lines  int64 // accessed atomically
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	lines := 0
+	for _, b := range p[:n] {
+		if b == '\n' {
+			lines++
+		}
+	}
+	atomic.AddInt64(&r.bytes, int64(n))
+	atomic.AddInt64(&r.lines, int64(lines))
+	return n, err
+}
+
+// report prints progress every interval until the returned function is called
+func (r *progressReader) report(w io.Writer, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(w, "Sent %v lines, %v bytes\n", atomic.LoadInt64(&r.lines), atomic.LoadInt64(&r.bytes))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		fmt.Fprintf(w, "Sent %v lines, %v bytes\n", atomic.LoadInt64(&r.lines), atomic.LoadInt64(&r.bytes))
+	}
+}
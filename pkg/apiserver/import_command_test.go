@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("apiserver import command", func() {
+	var (
+		accountsFile string
+		restoreEnv   func()
+	)
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "apiserver-import")
+		Ω(err).ShouldNot(HaveOccurred())
+		accountsFile = filepath.Join(dir, "accounts.ndjson")
+		Ω(ioutil.WriteFile(accountsFile, []byte("{}\n"), 0600)).Should(Succeed())
+		secret, ok := os.LookupEnv(importHMACSecretEnv)
+		restoreEnv = func() {
+			if ok {
+				os.Setenv(importHMACSecretEnv, secret)
+			} else {
+				os.Unsetenv(importHMACSecretEnv)
+			}
+			os.RemoveAll(dir)
+		}
+	})
+
+	AfterEach(func() {
+		restoreEnv()
+	})
+
+	It("should sign requests with the key given by flag and the secret given by env variable", func() {
+		headers := make(chan http.Header, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers <- r.Header.Clone()
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+		os.Setenv(importHMACSecretEnv, "secret")
+
+		stderr := &bytes.Buffer{}
+		code := importCommand([]string{"-url", server.URL + "/v1/account", "-hmac-key-id", "importer", accountsFile}, ioutil.Discard, stderr)
+		Ω(code).Should(Equal(1))
+		received := <-headers
+		Ω(received.Get("Signature")).ShouldNot(BeEmpty())
+		Ω(received.Get("Signature-Input")).Should(ContainSubstring(`keyid="importer"`))
+	})
+
+	It("should fail when the signing secret is not set", func() {
+		os.Unsetenv(importHMACSecretEnv)
+		stderr := &bytes.Buffer{}
+		code := importCommand([]string{"-hmac-key-id", "importer", accountsFile}, ioutil.Discard, stderr)
+		Ω(code).Should(Equal(2))
+		Ω(stderr.String()).Should(ContainSubstring(importHMACSecretEnv))
+	})
+
+	It("should require the client certificate together with its key", func() {
+		_, err := importClientConfig("https://localhost/v1/account", "", apiclient.TLSConfig{CertFile: "client.pem"})
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should use TLS options only when they are set", func() {
+		config, err := importClientConfig("https://localhost/v1/account", "", apiclient.TLSConfig{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.TLS).Should(BeNil())
+		config, err = importClientConfig("https://localhost/v1/account", "", apiclient.TLSConfig{CAFile: "ca.pem"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.TLS).Should(Equal(&apiclient.TLSConfig{CAFile: "ca.pem"}))
+	})
+})
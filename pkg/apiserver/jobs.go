@@ -0,0 +1,730 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// jobChunkSize is the size of import files and export results stored in one "JobChunk" row
+	jobChunkSize = 1 << 20
+	// jobHeartbeatInterval is how often running jobs save progress and check if they were cancelled
+	jobHeartbeatInterval = 5 * time.Second
+	// jobStaleAfter is how long a running job can go without heartbeat, e.g. after a crash, before other workers take it again
+	jobStaleAfter = time.Minute
+	// jobMaxAttempts is number of times a job is taken again after it was interrupted
+	jobMaxAttempts = 3
+	// jobFinishTimeout limits time of saving the job result, it is saved with a new context, so it is saved during shutdown
+	jobFinishTimeout = 5 * time.Second
+	// jobRetention is how long finished jobs and their results are kept
+	jobRetention = 7 * 24 * time.Hour
+)
+
+// jobParams are parameters of the job given when it was submitted
+type jobParams struct {
+	Conflict   string                      `json:"conflict,omitempty"` // import conflict policy, see importConflicts
+	Format     string                      `json:"format,omitempty"`   // export format, ndjson or csv
+	Filter     apiclient.AccountListFilter `json:"filter"`             // exported accounts
+	Sort       []apiclient.Sort            `json:"sort,omitempty"`     // order of exported accounts
+	Fields     string                      `json:"fields,omitempty"`   // exported attributes, see parseFieldSelection
+	IncludePII bool                        `json:"include_pii"`        // whether the caller had `pii:read` scope
+}
+
+// jobRecord is the job with information not returned to callers
+type jobRecord struct {
+	apiclient.Job
+	owner    string
+	params   jobParams
+	attempts int
+}
+
+//
+// routes
+//
+
+type jobRouter struct {
+	accountService *AccountService
+	jobsPath       string // path of the routes group, used in links to results
+	logger         *Logger
+}
+
+// SetupJobRouting registers routes of asynchronous imports and exports, `limiter` can be nil when requests are not limited
+func SetupJobRouting(router *gin.RouterGroup, accountService *AccountService, timeouts statementTimeouts, streams streamTimeout, limiter *rateLimiter, logger *Logger) {
+	jr := jobRouter{
+		accountService: accountService,
+		jobsPath:       router.BasePath(),
+		logger:         logger,
+	}
+	// import files are uploaded with the request, like with the import route, so their chunks are saved as long as the upload takes
+	router.POST("", limiter.middleware("write"), timeouts.middlewareBy(func(c *gin.Context) string {
+		if apiclient.JobType(c.Query("type")) == apiclient.JobImport {
+			return "import"
+		}
+		return "job"
+	}), streams.middleware(), jr.submitJob)
+	router.GET("/:jobId", limiter.middleware("read"), timeouts.middleware("job"), jr.getJob)
+	router.DELETE("/:jobId", limiter.middleware("write"), timeouts.middleware("job"), jr.cancelJob)
+	// results are streamed chunk by chunk, like exports
+	router.GET("/:jobId/result", limiter.middleware("read"), timeouts.middleware("export"), streams.middleware(), jr.getJobResult)
+}
+
+// submitJob queues an import of newline-delimited accounts sent in the request body (`type=import&conflict=...`),
+// or an export of accounts matching the same query parameters as the export route (`type=export&format=...`)
+func (jr *jobRouter) submitJob(c *gin.Context) {
+	params := jobParams{}
+	var input io.Reader
+	jobType := apiclient.JobType(c.Query("type"))
+	switch jobType {
+	case apiclient.JobImport:
+		params.Conflict = c.DefaultQuery("conflict", "skip")
+		if !importConflicts[params.Conflict] {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in conflict query parameter, should be skip, fail or overwrite-if-newer"})
+			return
+		}
+		input = c.Request.Body
+	case apiclient.JobExport:
+		params.Format = c.DefaultQuery("format", "ndjson")
+		if params.Format != "ndjson" && params.Format != "csv" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in format query parameter, should be ndjson or csv"})
+			return
+		}
+		var err error
+		if params.Filter, err = parseAccountFilter(c); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+			return
+		}
+		// the job runs without the caller, the filter is saved with its organisation
+		if !scopeFilter(c, &params.Filter) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "Not allowed to export accounts of other organisations"})
+			return
+		}
+		if params.Sort, err = parseAccountSort(c); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+			return
+		}
+		params.Fields = c.Query("fields[accounts]")
+		params.IncludePII = hasScope(c, scopePIIRead)
+		_, err = parseFieldSelection(params.Fields, params.IncludePII)
+		if err == errPIINotAllowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("%v", err)})
+			return
+		}
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Wrong value in type query parameter, should be import or export"})
+		return
+	}
+	job, err := jr.accountService.submitJob(c.Request.Context(), callerOrganisation(c), jobType, params, input)
+	if errors.Is(err, httpsig.ErrDigestMismatch) {
+		abortWithDigestMismatch(c, jr.logger, err)
+		return
+	}
+	if err != nil {
+		abortWithStoreError(c, err, "Problem submitting job")
+		return
+	}
+	c.Header("Location", fmt.Sprintf("%v/%v", jr.jobsPath, job.ID))
+	c.JSON(http.StatusAccepted, gin.H{"data": jr.view(job)})
+}
+
+func (jr *jobRouter) getJob(c *gin.Context) {
+	job, err := jr.accountService.getJob(c.Request.Context(), c.Param("jobId"), callerOrganisation(c))
+	if err != nil {
+		abortWithStoreError(c, err, "Problem reading job from storage")
+		return
+	}
+	if job == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "error", "message": "There is no job"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": jr.view(job)})
+}
+
+// cancelJob cancels a queued job at once, running jobs are cancelled by their workers with the next heartbeat
+func (jr *jobRouter) cancelJob(c *gin.Context) {
+	job, err := jr.accountService.cancelJob(c.Request.Context(), c.Param("jobId"), callerOrganisation(c))
+	if err != nil {
+		abortWithStoreError(c, err, "Problem cancelling job")
+		return
+	}
+	if job == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "error", "message": "There is no job"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"data": jr.view(job)})
+}
+
+// getJobResult responds with the import summary, or the exported accounts
+func (jr *jobRouter) getJobResult(c *gin.Context) {
+	job, err := jr.accountService.getJob(c.Request.Context(), c.Param("jobId"), callerOrganisation(c))
+	if err != nil {
+		abortWithStoreError(c, err, "Problem reading job from storage")
+		return
+	}
+	if job == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "error", "message": "There is no job"})
+		return
+	}
+	if job.Status != apiclient.JobSucceeded {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"status": "error", "code": "job_not_succeeded", "message": fmt.Sprintf("Job is %v, the result is available when it succeeded", job.Status)})
+		return
+	}
+	if job.Type == apiclient.JobImport {
+		c.JSON(http.StatusOK, gin.H{"data": job.Summary})
+		return
+	}
+	started := false
+	begin := func() {
+		started = true
+		contentType, extension := exportContentType(job.params.Format)
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="accounts-%v.%v"`, job.ID, extension))
+		c.Header("Trailer", exportErrorTrailer)
+		c.Status(http.StatusOK)
+	}
+	err = jr.accountService.readJobChunks(c.Request.Context(), job.ID, "result", func(data []byte) error {
+		if !started {
+			begin()
+		}
+		_, err := c.Writer.Write(data)
+		return err
+	})
+	if err == nil && !started {
+		// nothing was exported
+		begin()
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	if err != nil && !started {
+		abortWithStoreError(c, err, "Problem reading job result from storage")
+		return
+	}
+	if err != nil {
+		loggerFrom(c.Request.Context(), jr.logger).Error("Sending job result failed", "job_id", job.ID, "error", err)
+		c.Writer.Header().Set(exportErrorTrailer, "Sending job result failed, accounts are missing")
+	}
+}
+
+// view is the job returned to callers, with the link to its result
+func (jr *jobRouter) view(job *jobRecord) apiclient.Job {
+	view := job.Job
+	if view.Status == apiclient.JobSucceeded {
+		view.Result = fmt.Sprintf("%v/%v/result", jr.jobsPath, view.ID)
+	}
+	return view
+}
+
+//
+// store
+//
+
+// observeJobQuery runs the query of the job, or of the queue when `jobID` is empty, in a query span, and records it in query metrics.
+// pgx.ErrNoRows is returned, but it is not recorded as a failure.
+func (s *AccountService) observeJobQuery(ctx context.Context, operation string, jobID string, query func(ctx context.Context) error) error {
+	attributes := []label.KeyValue{}
+	if jobID != "" {
+		attributes = append(attributes, label.String("job.id", jobID))
+	}
+	queryCtx, span := startQuerySpan(ctx, operation, attributes...)
+	start := time.Now()
+	err := query(queryCtx)
+	failure := err
+	if err == pgx.ErrNoRows {
+		failure = nil
+	}
+	s.metrics.observeQuery(operation, start, failure)
+	endQuerySpan(span, failure)
+	return err
+}
+
+// jobColumns are columns read into jobRecord by scanJob
+const jobColumns = `id, owner, type, params, status, processed, error, result, attempts, created_on, started_on, finished_on`
+
+func scanJob(row pgx.Row) (*jobRecord, error) {
+	job := jobRecord{}
+	var (
+		id           uuid.UUID
+		errorMessage *string
+	)
+	err := row.Scan(&id, &job.owner, &job.Type, &job.params, &job.Status, &job.Processed, &errorMessage, &job.Summary,
+		&job.attempts, &job.CreatedOn, &job.StartedOn, &job.FinishedOn)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = id.String()
+	if errorMessage != nil {
+		job.Error = *errorMessage
+	}
+	return &job, nil
+}
+
+// submitJob saves the queued job, and the import file in chunks, in one transaction so workers never see a partial file
+func (s *AccountService) submitJob(ctx context.Context, owner string, jobType apiclient.JobType, params jobParams, input io.Reader) (*jobRecord, error) {
+	jobID := uuid.New()
+	logger := loggerFrom(ctx, s.logger).With("job_id", jobID.String(), "type", string(jobType))
+
+	var job *jobRecord
+	err := s.observeJobQuery(ctx, "submitJob", jobID.String(), func(ctx context.Context) error {
+		tx, err := s.dbConnPool.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+		job, err = scanJob(tx.QueryRow(ctx,
+			`INSERT INTO "Job" (id, owner, type, params, status) VALUES ($1, $2, $3, $4, 'queued') RETURNING `+jobColumns,
+			jobID, owner, jobType, params,
+		))
+		if err != nil {
+			return err
+		}
+		if input != nil {
+			if err = writeJobChunks(ctx, tx, jobID, "input", input); err != nil {
+				return fmt.Errorf("saving input failed: %w", err)
+			}
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		logger.Error("Submit job failed", "error", err)
+		if errors.Is(err, httpsig.ErrDigestMismatch) {
+			return nil, httpsig.ErrDigestMismatch
+		}
+		return nil, queryError(ctx, err, "Failed to submit job")
+	}
+	logger.Info("Submitted job")
+	return job, nil
+}
+
+// writeJobChunks saves the input in jobChunkSize chunks
+func writeJobChunks(ctx context.Context, tx pgx.Tx, jobID uuid.UUID, stream string, input io.Reader) error {
+	buffer := make([]byte, jobChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(input, buffer)
+		if n > 0 {
+			if _, execErr := tx.Exec(ctx, `INSERT INTO "JobChunk" (job_id, stream, seq, data) VALUES ($1, $2, $3, $4)`, jobID, stream, seq, buffer[:n]); execErr != nil {
+				return execErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// getJob returns the job, or nil when there is no job of the owner
+func (s *AccountService) getJob(ctx context.Context, jobID string, owner string) (*jobRecord, error) {
+	id, err := uuid.Parse(jobID)
+	if err != nil {
+		return nil, nil
+	}
+	var job *jobRecord
+	err = s.observeJobQuery(ctx, "getJob", jobID, func(ctx context.Context) error {
+		job, err = scanJob(s.dbConnPool.QueryRow(ctx,
+			`SELECT `+jobColumns+` FROM "Job" WHERE id = $1 AND ($2 = '' OR lower(owner) = lower($2))`, id, owner))
+		return err
+	})
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("Get job failed", "job_id", jobID, "error", err)
+		return nil, queryError(ctx, err, "Failed to fetch job from store")
+	}
+	return job, nil
+}
+
+// cancelJob cancels the queued job, or requests cancellation of the running job. Finished jobs are not changed.
+func (s *AccountService) cancelJob(ctx context.Context, jobID string, owner string) (*jobRecord, error) {
+	id, err := uuid.Parse(jobID)
+	if err != nil {
+		return nil, nil
+	}
+	var job *jobRecord
+	err = s.observeJobQuery(ctx, "cancelJob", jobID, func(ctx context.Context) error {
+		job, err = scanJob(s.dbConnPool.QueryRow(ctx,
+			`UPDATE "Job" SET
+				status = CASE WHEN status = 'queued' THEN 'cancelled' ELSE status END,
+				finished_on = CASE WHEN status = 'queued' THEN current_timestamp ELSE finished_on END,
+				cancel_requested = cancel_requested OR status = 'running'
+			WHERE id = $1 AND ($2 = '' OR lower(owner) = lower($2))
+			RETURNING `+jobColumns, id, owner))
+		return err
+	})
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		loggerFrom(ctx, s.logger).Error("Cancel job failed", "job_id", jobID, "error", err)
+		return nil, queryError(ctx, err, "Failed to cancel job")
+	}
+	loggerFrom(ctx, s.logger).Info("Cancelled job", "job_id", jobID, "status", string(job.Status))
+	return job, nil
+}
+
+// readJobChunks calls `fn` with every chunk of the stream in order, one chunk is loaded at the time
+func (s *AccountService) readJobChunks(ctx context.Context, jobID string, stream string, fn func(data []byte) error) error {
+	for seq := 0; ; seq++ {
+		var data []byte
+		err := s.observeJobQuery(ctx, "readJobChunk", jobID, func(ctx context.Context) error {
+			return s.dbConnPool.QueryRow(ctx, `SELECT data FROM "JobChunk" WHERE job_id = $1 AND stream = $2 AND seq = $3`, jobID, stream, seq).Scan(&data)
+		})
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+}
+
+//
+// workers
+//
+
+// runJobs runs queued jobs with `workers` goroutines until the context is cancelled.
+// Workers take jobs with `FOR UPDATE SKIP LOCKED`, so many server instances share one queue.
+func (s *AccountService) runJobs(ctx context.Context, workers int, pollInterval time.Duration) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runJobWorker(ctx, pollInterval)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *AccountService) runJobWorker(ctx context.Context, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		// jobs are taken one after another until the queue is empty
+		for ctx.Err() == nil {
+			job, err := s.claimJob(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Error("Taking job failed", "error", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			s.runJob(ctx, job)
+		}
+		if time.Since(lastCleanup) > time.Hour {
+			err := s.observeJobQuery(ctx, "removeOldJobs", "", func(ctx context.Context) error {
+				_, err := s.dbConnPool.Exec(ctx, `DELETE FROM "Job" WHERE finished_on < $1`, time.Now().Add(-jobRetention))
+				return err
+			})
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("Removing old jobs failed", "error", err)
+			}
+			lastCleanup = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimJob takes the oldest queued job, or a running job which stopped sending heartbeats. It returns nil when there is no job.
+func (s *AccountService) claimJob(ctx context.Context) (*jobRecord, error) {
+	var job *jobRecord
+	err := s.observeJobQuery(ctx, "claimJob", "", func(ctx context.Context) error {
+		var err error
+		job, err = scanJob(s.dbConnPool.QueryRow(ctx,
+			`UPDATE "Job" SET status = 'running', started_on = COALESCE(started_on, current_timestamp), heartbeat_on = current_timestamp, attempts = attempts + 1
+			WHERE id = (
+				SELECT id FROM "Job"
+				WHERE status = 'queued' OR (status = 'running' AND heartbeat_on < $1)
+				ORDER BY created_on
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+jobColumns, time.Now().Add(-jobStaleAfter)))
+		return err
+	})
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// runJob runs the claimed job and saves its result. Jobs interrupted by shutdown are queued again.
+// Updates of the job are fenced by its attempt, so a worker whose job was taken again by another worker, e.g. after a long pause, changes nothing.
+func (s *AccountService) runJob(ctx context.Context, job *jobRecord) {
+	logger := s.logger.With("job_id", job.ID, "type", string(job.Type), "attempt", job.attempts)
+	if job.attempts > jobMaxAttempts {
+		logger.Error("Job was interrupted too many times")
+		s.finishJob(job, apiclient.JobFailed, 0, "Job was interrupted too many times", nil, logger)
+		return
+	}
+	logger.Info("Running job")
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		processed int64 // accessed atomically
+		cancelled int32 // accessed atomically, 1 when the job was cancelled by the caller
+		lost      int32 // accessed atomically, 1 when the job was taken again by another worker
+	)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		s.jobHeartbeat(jobCtx, job, &processed, func(taken bool) {
+			if taken {
+				atomic.StoreInt32(&lost, 1)
+			} else {
+				atomic.StoreInt32(&cancelled, 1)
+			}
+			cancel()
+		}, logger)
+	}()
+
+	var (
+		summary *apiclient.ImportSummary
+		err     error
+	)
+	switch job.Type {
+	case apiclient.JobImport:
+		summary, err = s.runImportJob(jobCtx, job, &processed)
+	case apiclient.JobExport:
+		err = s.runExportJob(jobCtx, job, &processed)
+	default:
+		err = fmt.Errorf("unknown job type %v", job.Type)
+	}
+	cancel()
+	<-heartbeatDone
+
+	// the job which completed is saved even when it was cancelled, or the server is shutting down meanwhile
+	switch {
+	case err == nil:
+		logger.Info("Job succeeded", "processed", processed)
+		s.finishJob(job, apiclient.JobSucceeded, processed, "", summary, logger)
+	case atomic.LoadInt32(&lost) == 1:
+		logger.Warn("Job was taken again by another worker", "processed", processed)
+	case atomic.LoadInt32(&cancelled) == 1:
+		logger.Info("Job cancelled", "processed", processed)
+		s.finishJob(job, apiclient.JobCancelled, processed, "Job was cancelled", summary, logger)
+	case ctx.Err() != nil:
+		// the server is shutting down, another worker runs the job again
+		requeueCtx, cancelRequeue := context.WithTimeout(context.Background(), jobFinishTimeout)
+		defer cancelRequeue()
+		err = s.observeJobQuery(requeueCtx, "requeueJob", job.ID, func(ctx context.Context) error {
+			_, err := s.dbConnPool.Exec(ctx,
+				`UPDATE "Job" SET status = 'queued', attempts = attempts - 1 WHERE id = $1 AND status = 'running' AND attempts = $2`,
+				job.ID, job.attempts)
+			return err
+		})
+		if err != nil {
+			logger.Error("Queueing interrupted job failed", "error", err)
+		}
+	default:
+		logger.Error("Job failed", "processed", processed, "error", err)
+		s.finishJob(job, apiclient.JobFailed, processed, err.Error(), summary, logger)
+	}
+}
+
+// jobHeartbeat saves progress of the running job every jobHeartbeatInterval. It calls `stop` when the caller cancelled the job,
+// or with `taken` set when the job is no longer the attempt of this worker.
+func (s *AccountService) jobHeartbeat(ctx context.Context, job *jobRecord, processed *int64, stop func(taken bool), logger *Logger) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var cancelRequested bool
+		err := s.observeJobQuery(ctx, "jobHeartbeat", job.ID, func(ctx context.Context) error {
+			return s.dbConnPool.QueryRow(ctx,
+				`UPDATE "Job" SET heartbeat_on = current_timestamp, processed = $2 WHERE id = $1 AND status = 'running' AND attempts = $3 RETURNING cancel_requested`,
+				job.ID, atomic.LoadInt64(processed), job.attempts,
+			).Scan(&cancelRequested)
+		})
+		if err == pgx.ErrNoRows {
+			stop(true)
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Saving job progress failed", "error", err)
+		}
+		if cancelRequested {
+			stop(false)
+			return
+		}
+	}
+}
+
+// finishJob saves the result of the job, unless it was taken again by another worker
+func (s *AccountService) finishJob(job *jobRecord, status apiclient.JobStatus, processed int64, message string, summary *apiclient.ImportSummary, logger *Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobFinishTimeout)
+	defer cancel()
+	var errorMessage *string
+	if message != "" {
+		errorMessage = &message
+	}
+	var saved int64
+	err := s.observeJobQuery(ctx, "finishJob", job.ID, func(ctx context.Context) error {
+		tag, err := s.dbConnPool.Exec(ctx,
+			`UPDATE "Job" SET status = $2, processed = $3, error = $4, result = $5, finished_on = current_timestamp WHERE id = $1 AND status = 'running' AND attempts = $6`,
+			job.ID, status, processed, errorMessage, summary, job.attempts,
+		)
+		saved = tag.RowsAffected()
+		return err
+	})
+	if err != nil {
+		logger.Error("Saving job result failed", "error", err)
+		return
+	}
+	if saved == 0 {
+		logger.Warn("Job result not saved, the job was taken again by another worker")
+	}
+}
+
+// runImportJob imports the uploaded file like the import route, accounts are authorized with the organisation which submitted the job
+func (s *AccountService) runImportJob(ctx context.Context, job *jobRecord, processed *int64) (*apiclient.ImportSummary, error) {
+	authorize := func(organisationID string) bool {
+		return job.owner == "" || strings.EqualFold(job.owner, organisationID)
+	}
+	input := &jobChunkReader{ctx: ctx, s: s, jobID: job.ID, stream: "input", lines: processed}
+	summary, err := s.importAccounts(ctx, input, job.params.Conflict, job.owner, authorize)
+	if errors.Is(err, errImportConflict) {
+		return summary, errors.New("Nothing was imported because some accounts already exist")
+	}
+	return summary, err
+}
+
+// runExportJob exports accounts like the export route, the result is saved in chunks
+func (s *AccountService) runExportJob(ctx context.Context, job *jobRecord, processed *int64) error {
+	fields, err := parseFieldSelection(job.params.Fields, job.params.IncludePII)
+	if err != nil {
+		return err
+	}
+	// a job taken again after an interruption starts from the beginning
+	err = s.observeJobQuery(ctx, "removeJobResult", job.ID, func(ctx context.Context) error {
+		_, err := s.dbConnPool.Exec(ctx, `DELETE FROM "JobChunk" WHERE job_id = $1 AND stream = 'result'`, job.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	output := &jobChunkWriter{ctx: ctx, s: s, jobID: job.ID, stream: "result"}
+	writer := newExportWriter(output, job.params.Format, fields, nil)
+	_, err = s.exportAccounts(ctx, job.params.Filter, job.params.Sort, func(account apiclient.AccountResource) error {
+		atomic.AddInt64(processed, 1)
+		return writer.write(account)
+	})
+	if err != nil {
+		return err
+	}
+	writer.start()
+	if err = writer.flush(); err != nil {
+		return err
+	}
+	return output.Close()
+}
+
+// jobChunkReader reads chunks of the stream in order, and counts lines read
+type jobChunkReader struct {
+	ctx    context.Context
+	s      *AccountService
+	jobID  string
+	stream string
+	lines  *int64 // accessed atomically
+
+	seq   int
+	chunk []byte
+	done  bool
+}
+
+func (r *jobChunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		err := r.s.observeJobQuery(r.ctx, "readJobChunk", r.jobID, func(ctx context.Context) error {
+			return r.s.dbConnPool.QueryRow(ctx, `SELECT data FROM "JobChunk" WHERE job_id = $1 AND stream = $2 AND seq = $3`, r.jobID, r.stream, r.seq).Scan(&r.chunk)
+		})
+		if err == pgx.ErrNoRows {
+			r.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.seq++
+	}
+	n := copy(p, r.chunk)
+	atomic.AddInt64(r.lines, int64(strings.Count(string(r.chunk[:n]), "\n")))
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// jobChunkWriter saves written data in jobChunkSize chunks of the stream
+type jobChunkWriter struct {
+	ctx    context.Context
+	s      *AccountService
+	jobID  string
+	stream string
+
+	seq    int
+	buffer []byte
+}
+
+func (w *jobChunkWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for len(w.buffer) >= jobChunkSize {
+		if err := w.save(w.buffer[:jobChunkSize]); err != nil {
+			return 0, err
+		}
+		w.buffer = append(w.buffer[:0], w.buffer[jobChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close saves the last chunk
+func (w *jobChunkWriter) Close() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	return w.save(w.buffer)
+}
+
+func (w *jobChunkWriter) save(data []byte) error {
+	err := w.s.observeJobQuery(w.ctx, "saveJobChunk", w.jobID, func(ctx context.Context) error {
+		_, err := w.s.dbConnPool.Exec(ctx, `INSERT INTO "JobChunk" (job_id, stream, seq, data) VALUES ($1, $2, $3, $4)`, w.jobID, w.stream, w.seq, data)
+		return err
+	})
+	w.seq++
+	return err
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/label"
+)
+
+// rotateKeys periodically re-encrypts private identification encrypted with inactive key-encryption keys,
+// and encrypts private identification stored in plaintext before encryption was configured, until the context is cancelled
+func (s *AccountService) rotateKeys(ctx context.Context, interval time.Duration, batchSize int) {
+	if s.keyring == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.rotateAllKeys(ctx, batchSize); err != nil {
+			s.logger.Error("Key rotation failed", "kek_id", s.keyring.active, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// notRotatedCondition selects accounts encrypted with other key than the active key ($1), or not encrypted at all
+const notRotatedCondition = `((private_identification IS NOT NULL AND private_identification->>'kek_id' <> $1)
+	OR (private_identification IS NULL AND record ? 'private_identification'))`
+
+// rotateAllKeys re-encrypts batches of accounts until all of them use the active key.
+// Accounts are visited in ID order once per run, accounts which cannot be re-encrypted, e.g. encrypted with a key missing in PII_KEKS,
+// are skipped and counted as failed, they are tried again by the next run.
+// Progress is stored in "KeyRotation" table, one row per active key, and exposed as `apiserver_key_rotation_accounts` metric.
+func (s *AccountService) rotateAllKeys(ctx context.Context, batchSize int) error {
+	queryCtx, span := startQuerySpan(ctx, "countKeyRotation", label.String("kek.id", s.keyring.active))
+	start := time.Now()
+	var remaining int64
+	err := s.dbConnPool.QueryRow(queryCtx,
+		`SELECT COUNT(*) FROM "Account" WHERE `+notRotatedCondition,
+		s.keyring.active,
+	).Scan(&remaining)
+	s.metrics.observeQuery("countKeyRotation", start, err)
+	endQuerySpan(span, err)
+	if err != nil {
+		return fmt.Errorf("counting accounts to rotate: %v", err)
+	}
+	if remaining == 0 {
+		err = s.saveKeyRotation(ctx,
+			`UPDATE "KeyRotation" SET finished_on = current_timestamp, modified_on = current_timestamp, remaining = 0, failed = 0 WHERE kek_id = $1 AND finished_on IS NULL`,
+			s.keyring.active,
+		)
+		if err == nil {
+			s.metrics.observeKeyRotation(s.keyring.active, 0, 0)
+		}
+		return err
+	}
+	err = s.saveKeyRotation(ctx,
+		`INSERT INTO "KeyRotation" (kek_id, remaining) VALUES ($1, $2)
+			ON CONFLICT (kek_id) DO UPDATE SET remaining = $2, failed = 0, finished_on = NULL, modified_on = current_timestamp`,
+		s.keyring.active, remaining,
+	)
+	if err != nil {
+		return fmt.Errorf("saving key rotation progress: %v", err)
+	}
+	s.metrics.observeKeyRotation(s.keyring.active, remaining, 0)
+	s.logger.Info("Key rotation started", "kek_id", s.keyring.active, "remaining", remaining)
+
+	var failed int64
+	after := uuid.Nil
+	for {
+		progress, err := s.rotateKeysBatch(ctx, batchSize, after)
+		if err != nil {
+			return err
+		}
+		if progress.selected == 0 {
+			break
+		}
+		after = progress.last
+		failed += int64(progress.failed)
+		remaining -= int64(progress.selected)
+		s.metrics.observeKeyRotation(s.keyring.active, remaining, failed)
+	}
+	// accounts which failed remain to be rotated
+	err = s.saveKeyRotation(ctx,
+		`UPDATE "KeyRotation" SET finished_on = current_timestamp, modified_on = current_timestamp, remaining = $2, failed = $2 WHERE kek_id = $1`,
+		s.keyring.active, failed,
+	)
+	if err != nil {
+		return fmt.Errorf("saving key rotation progress: %v", err)
+	}
+	s.metrics.observeKeyRotation(s.keyring.active, failed, failed)
+	if failed > 0 {
+		s.logger.Warn("Key rotation finished with failed accounts", "kek_id", s.keyring.active, "failed", failed)
+		return nil
+	}
+	s.logger.Info("Key rotation finished", "kek_id", s.keyring.active)
+	return nil
+}
+
+// saveKeyRotation runs the query saving progress of the key rotation in "KeyRotation" table
+func (s *AccountService) saveKeyRotation(ctx context.Context, query string, args ...interface{}) error {
+	ctx, span := startQuerySpan(ctx, "saveKeyRotation", label.String("kek.id", s.keyring.active))
+	start := time.Now()
+	_, err := s.dbConnPool.Exec(ctx, query, args...)
+	s.metrics.observeQuery("saveKeyRotation", start, err)
+	endQuerySpan(span, err)
+	return err
+}
+
+// rotationProgress describes one batch of rotated accounts
+type rotationProgress struct {
+	selected int       // number of accounts selected for rotation
+	failed   int       // number of selected accounts which could not be re-encrypted
+	last     uuid.UUID // ID of the last selected account, the next batch starts after it
+}
+
+// rotateKeysBatch re-wraps data keys of up to `batchSize` accounts with ID greater than `after` in one transaction.
+// Accounts locked by other server instances are skipped, so many instances can rotate keys at the same time.
+func (s *AccountService) rotateKeysBatch(ctx context.Context, batchSize int, after uuid.UUID) (rotationProgress, error) {
+	queryCtx, span := startQuerySpan(ctx, "rotateKeysBatch", label.String("kek.id", s.keyring.active), label.Int("key_rotation.batch_size", batchSize))
+	start := time.Now()
+	progress, err := s.rewrapKeysBatch(queryCtx, batchSize, after)
+	s.metrics.observeQuery("rotateKeysBatch", start, err)
+	span.SetAttributes(label.Int("key_rotation.selected", progress.selected), label.Int("key_rotation.failed", progress.failed))
+	endQuerySpan(span, err)
+	return progress, err
+}
+
+func (s *AccountService) rewrapKeysBatch(ctx context.Context, batchSize int, after uuid.UUID) (rotationProgress, error) {
+	progress := rotationProgress{}
+	tx, err := s.dbConnPool.Begin(ctx)
+	if err != nil {
+		return progress, fmt.Errorf("starting transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT id, private_identification, record->'private_identification' FROM "Account"
+			WHERE `+notRotatedCondition+` AND id > $3
+			ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED`,
+		s.keyring.active, batchSize, after,
+	)
+	if err != nil {
+		return progress, fmt.Errorf("selecting accounts to rotate: %v", err)
+	}
+	type rotatedAccount struct {
+		id       uuid.UUID
+		envelope []byte
+	}
+	accounts := []rotatedAccount{}
+	for rows.Next() {
+		account := rotatedAccount{}
+		var encrypted, plaintext []byte
+		if err = rows.Scan(&account.id, &encrypted, &plaintext); err != nil {
+			rows.Close()
+			return progress, fmt.Errorf("reading account to rotate: %v", err)
+		}
+		progress.selected++
+		progress.last = account.id
+		if encrypted == nil {
+			account.envelope, err = s.keyring.sealJSON(json.RawMessage(plaintext), account.id[:])
+		} else {
+			account.envelope, err = s.rewrapJSON(encrypted)
+		}
+		if err != nil {
+			progress.failed++
+			s.logger.Warn("Rotating key of account failed", "kek_id", s.keyring.active, "account_id", account.id.String(), "error", err)
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return progress, fmt.Errorf("selecting accounts to rotate: %v", err)
+	}
+	if progress.selected == 0 {
+		return progress, nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, account := range accounts {
+		batch.Queue(`UPDATE "Account" SET private_identification = $2, record = record - 'private_identification' WHERE id = $1`, account.id, account.envelope)
+	}
+	batch.Queue(
+		`UPDATE "KeyRotation" SET rotated = rotated + $2, failed = failed + $3, remaining = GREATEST(remaining - $2 - $3, 0), modified_on = current_timestamp WHERE kek_id = $1`,
+		s.keyring.active, len(accounts), progress.failed,
+	)
+	if err = tx.SendBatch(ctx, batch).Close(); err != nil {
+		return progress, fmt.Errorf("saving rotated accounts: %v", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return progress, fmt.Errorf("committing rotated accounts: %v", err)
+	}
+	s.logger.Debug("Rotated keys of accounts", "kek_id", s.keyring.active, "rotated", len(accounts), "failed", progress.failed)
+	return progress, nil
+}
+
+// rewrapJSON wraps the data key of the encoded envelope with the active key
+func (s *AccountService) rewrapJSON(encoded []byte) ([]byte, error) {
+	env := envelope{}
+	if err := json.Unmarshal(encoded, &env); err != nil {
+		return nil, err
+	}
+	rotated, err := s.keyring.rewrap(&env)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rotated)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// logLevel is a severity of a log entry, entries below the logger level are dropped
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// parseLogLevel converts a level name, e.g. `info`, to the level
+func parseLogLevel(name string) (logLevel, error) {
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %v", name)
+}
+
+// Logger writes one JSON object per line, e.g.
+//
+//	{"time":"2021-01-02T15:04:05.000Z","level":"info","msg":"Request","request_id":"...","status":200}
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	level  logLevel
+	fields []interface{} // key, value pairs added to every entry
+}
+
+// NewLogger creates a logger writing entries at `level` and above
+func NewLogger(out io.Writer, level logLevel) *Logger {
+	return &Logger{
+		out:   out,
+		mu:    &sync.Mutex{},
+		level: level,
+	}
+}
+
+// With returns a logger adding key, value pairs to every entry
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append([]interface{}(nil), l.fields...), keysAndValues...)
+	return &child
+}
+
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(levelDebug, msg, keysAndValues)
+}
+
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(levelInfo, msg, keysAndValues)
+}
+
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(levelWarn, msg, keysAndValues)
+}
+
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(levelError, msg, keysAndValues)
+}
+
+func (l *Logger) log(level logLevel, msg string, keysAndValues []interface{}) {
+	if level < l.level {
+		return
+	}
+	entry := bytes.Buffer{}
+	entry.WriteString("{")
+	writeField(&entry, "time", time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	entry.WriteString(",")
+	writeField(&entry, "level", levelNames[level])
+	entry.WriteString(",")
+	writeField(&entry, "msg", msg)
+	for _, fields := range [][]interface{}{l.fields, keysAndValues} {
+		for i := 0; i < len(fields); i += 2 {
+			key := fmt.Sprint(fields[i])
+			var value interface{} = "(MISSING)"
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			entry.WriteString(",")
+			writeField(&entry, key, value)
+		}
+	}
+	entry.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(entry.Bytes())
+}
+
+// writeField writes `"key":value`, errors and values that cannot be encoded are written as strings
+func writeField(entry *bytes.Buffer, key string, value interface{}) {
+	encodedKey, _ := json.Marshal(key)
+	entry.Write(encodedKey)
+	entry.WriteString(":")
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	default:
+		// personal data never reaches logs
+		value = apiclient.Redact(value)
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+	entry.Write(encodedValue)
+}
+
+// getLogLevel returns level of logged entries, taken from LOG_LEVEL setting: debug, info (default), warn or error
+func getLogLevel(cfg *Config) (logLevel, error) {
+	value, ok := cfg.lookup("LOG_LEVEL")
+	if !ok {
+		return levelInfo, nil
+	}
+	level, err := parseLogLevel(value)
+	if err != nil {
+		return 0, fmt.Errorf("Error: LOG_LEVEL setting should be debug, info, warn or error, got %v", value)
+	}
+	return level, nil
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying the logger
+func withLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger of the request, or `fallback` when the context has none
+func loggerFrom(ctx context.Context, fallback *Logger) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+const (
+	// requestIDHeader correlates requests between clients and the server
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength limits request IDs sent by clients
+	maxRequestIDLength = 128
+)
+
+// validRequestID checks that the request ID sent by a client is short, and has only letters, digits and `-_.:` characters,
+// so it is safe to echo in the response header and to write to logs
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogging assigns a request ID, taken from `X-Request-ID` header or generated when the header is missing or not valid,
+// and writes an access log entry when the request is done.
+// Handlers get a logger with the request ID and route from the request context.
+func requestLogging(logger *Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		requestLogger := logger.With("request_id", requestID, "route", c.FullPath())
+		c.Request = c.Request.WithContext(withLogger(c.Request.Context(), requestLogger))
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []interface{}{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", clientIP(c),
+		}
+		if p := getPrincipal(c); p != nil {
+			fields = append(fields, "organisation_id", p.Organisation)
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, "errors", c.Errors.String())
+		}
+		switch {
+		case status >= 500:
+			requestLogger.Error("Request", fields...)
+		case status >= 400:
+			requestLogger.Warn("Request", fields...)
+		default:
+			requestLogger.Info("Request", fields...)
+		}
+	}
+}
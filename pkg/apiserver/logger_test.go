@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/apiclient"
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logger", func() {
+	var (
+		out    *bytes.Buffer
+		logger *Logger
+
+		// entries parses logged JSON lines
+		entries = func() []map[string]interface{} {
+			result := []map[string]interface{}{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if line == "" {
+					continue
+				}
+				entry := map[string]interface{}{}
+				Ω(json.Unmarshal([]byte(line), &entry)).Should(Succeed())
+				result = append(result, entry)
+			}
+			return result
+		}
+	)
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		logger = NewLogger(out, levelInfo)
+	})
+
+	Context("when entries are logged", func() {
+
+		It("should write one JSON object per line with fields of the logger and the entry", func() {
+			logger.With("request_id", "req-1").Info("Fetched", "account_id", "acc-1", "latency", 1500*time.Millisecond, "error", errors.New("failed"))
+
+			Ω(entries()).Should(HaveLen(1))
+			entry := entries()[0]
+			Ω(entry).Should(HaveKeyWithValue("level", "info"))
+			Ω(entry).Should(HaveKeyWithValue("msg", "Fetched"))
+			Ω(entry).Should(HaveKeyWithValue("request_id", "req-1"))
+			Ω(entry).Should(HaveKeyWithValue("account_id", "acc-1"))
+			Ω(entry).Should(HaveKeyWithValue("latency", "1.5s"))
+			Ω(entry).Should(HaveKeyWithValue("error", "failed"))
+			Ω(entry).Should(HaveKey("time"))
+		})
+
+		It("should drop entries below the logger level", func() {
+			logger.Debug("Debug")
+			logger.Warn("Warn")
+			logger.Error("Error")
+
+			Ω(entries()).Should(HaveLen(2))
+			Ω(entries()[0]).Should(HaveKeyWithValue("level", "warn"))
+			Ω(entries()[1]).Should(HaveKeyWithValue("level", "error"))
+		})
+
+		It("should mark a key without value", func() {
+			logger.Info("Odd", "key")
+			Ω(entries()[0]).Should(HaveKeyWithValue("key", "(MISSING)"))
+		})
+
+		It("should redact personal data", func() {
+			logger.Info("Created", "attributes", &apiclient.AccountAttributes{
+				Country:               "GB",
+				PrivateIdentification: &apiclient.PrivateIdentification{Identification: "AB123456C"},
+			})
+			Ω(out.String()).ShouldNot(ContainSubstring("AB123456C"))
+			Ω(out.String()).Should(ContainSubstring(`"country":"GB"`))
+		})
+	})
+
+	Context("when the level is configured", func() {
+
+		It("should accept only known levels", func() {
+			level, err := getLogLevel(&Config{values: map[string]string{}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(level).Should(Equal(levelInfo))
+			level, err = getLogLevel(&Config{values: map[string]string{"LOG_LEVEL": "warn"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(level).Should(Equal(levelWarn))
+			_, err = getLogLevel(&Config{values: map[string]string{"LOG_LEVEL": "verbose"}})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("when requests are logged", func() {
+		var router *gin.Engine
+
+		// get sends the request with `X-Request-ID` header, when it is not empty
+		get := func(requestID string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "/v1/account/acc-1", nil)
+			if requestID != "" {
+				req.Header.Set("X-Request-ID", requestID)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		BeforeEach(func() {
+			router = gin.New()
+			router.Use(requestLogging(logger))
+			router.GET("/v1/account/:accountId", func(c *gin.Context) {
+				loggerFrom(c.Request.Context(), nil).Info("Handler")
+				c.Status(http.StatusNotFound)
+			})
+		})
+
+		It("should pass the request ID of the client to handler logs and the access log", func() {
+			recorder := get("client-req.1:a_b")
+
+			Ω(recorder.Header().Get("X-Request-ID")).Should(Equal("client-req.1:a_b"))
+			Ω(entries()).Should(HaveLen(2))
+			handler, access := entries()[0], entries()[1]
+			Ω(handler).Should(HaveKeyWithValue("request_id", "client-req.1:a_b"))
+			Ω(handler).Should(HaveKeyWithValue("route", "/v1/account/:accountId"))
+			Ω(access).Should(HaveKeyWithValue("request_id", "client-req.1:a_b"))
+			Ω(access).Should(HaveKeyWithValue("level", "warn"))
+			Ω(access).Should(HaveKeyWithValue("status", 404.0))
+			Ω(access).Should(HaveKeyWithValue("path", "/v1/account/acc-1"))
+		})
+
+		It("should generate the request ID when the client did not send one", func() {
+			recorder := get("")
+			Ω(recorder.Header().Get("X-Request-ID")).Should(MatchRegexp(`^[0-9a-f-]{36}$`))
+		})
+
+		It("should replace too long request IDs", func() {
+			recorder := get(strings.Repeat("a", maxRequestIDLength+1))
+			Ω(recorder.Header().Get("X-Request-ID")).Should(MatchRegexp(`^[0-9a-f-]{36}$`))
+			Ω(out.String()).ShouldNot(ContainSubstring(strings.Repeat("a", maxRequestIDLength+1)))
+		})
+
+		It("should replace request IDs with characters not allowed in logs", func() {
+			recorder := get(`req"}{"level":"error`)
+			Ω(recorder.Header().Get("X-Request-ID")).Should(MatchRegexp(`^[0-9a-f-]{36}$`))
+			Ω(entries()[1]).Should(HaveKeyWithValue("request_id", recorder.Header().Get("X-Request-ID")))
+		})
+	})
+})
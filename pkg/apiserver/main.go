@@ -1,70 +1,530 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-func health(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"status": "ok",
-	})
-}
-
 func main() {
-	logger := log.New(os.Stdout, "apiserver", log.LstdFlags)
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "config" {
+		os.Exit(configCommand(args[1:], os.Stdout, os.Stderr))
+	}
+	if len(args) > 0 && args[0] == "import" {
+		os.Exit(importCommand(args[1:], os.Stdout, os.Stderr))
+	}
+	flagSet := flag.NewFlagSet("apiserver", flag.ExitOnError)
+	flags := registerConfigFlags(flagSet)
+	flagSet.Parse(args)
 
-	dbConfig, err := getDBConnConfig()
+	logger := NewLogger(os.Stdout, levelInfo)
+	cfg, err := flags.load()
 	if err != nil {
-		logger.Printf("Error getting db connection information: %v", err)
-		return
+		logger.Error("Loading configuration failed", "error", err)
+		os.Exit(1)
 	}
-	accountService, err := NewAccountService(dbConfig, logger)
+	config, err := parseServerConfig(cfg)
 	if err != nil {
-		logger.Printf("Error connecting to account service: %v", err)
-		return
+		logger.Error("Parsing configuration failed", "error", err)
+		os.Exit(1)
+	}
+	if !serve(config, NewLogger(os.Stdout, config.LogLevel).With("service", "apiserver")) {
+		os.Exit(1)
+	}
+}
+
+// serverConfig is configuration of all server components
+type serverConfig struct {
+	ListenAddress       string
+	LogLevel            logLevel
+	ShutdownGracePeriod time.Duration
+	ShutdownDelay       time.Duration // time between failing readiness and draining connections on shutdown
+	HTTPTimeouts        httpTimeouts
+	DB                  *DBConfig
+	StatementTimeouts   statementTimeouts
+	RateLimits          *RateLimitConfig // nil when requests are not limited
+	TrustedProxies      []*net.IPNet     // proxies setting `X-Forwarded-For` header, it is ignored when empty
+	BatchMaxSize        int              // maximum number of accounts in one batch create request
+	JobWorkers          int              // number of goroutines running asynchronous jobs, 0 when jobs are run by other instances
+	JobPollInterval     time.Duration
+	MetricsEnabled      bool
+	MetricsRefresh      time.Duration
+	Tracing             *TracingConfig
+	Encryption          *EncryptionConfig // nil when personal data is stored in plaintext
+	HMACKeys            map[string]hmacKey
+	HMACMaxSkew         time.Duration
+	TLS                 *TLSConfig // nil when the server uses plain HTTP
+}
+
+// httpTimeouts limit time spent on reading requests and writing responses, see `http.Server`
+type httpTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Stream     time.Duration // replaces Read and Write of imports and exports, 0 when they are not limited
+}
+
+// parseServerConfig parses and validates all settings, it does not connect to Postgres
+func parseServerConfig(cfg *Config) (*serverConfig, error) {
+	config := serverConfig{}
+	var err error
+	if config.ListenAddress, err = getListenAddress(cfg); err != nil {
+		return nil, err
+	}
+	if config.LogLevel, err = getLogLevel(cfg); err != nil {
+		return nil, err
+	}
+	if config.ShutdownGracePeriod, err = getDuration(cfg, "SHUTDOWN_GRACE_PERIOD", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.ShutdownDelay, err = getDuration(cfg, "SHUTDOWN_DELAY", 0); err != nil {
+		return nil, err
+	}
+	if config.HTTPTimeouts, err = getHTTPTimeouts(cfg); err != nil {
+		return nil, err
+	}
+	if config.DB, err = getDBConnConfig(cfg); err != nil {
+		return nil, err
+	}
+	if config.StatementTimeouts, err = getStatementTimeouts(cfg); err != nil {
+		return nil, err
+	}
+	if config.RateLimits, err = getRateLimitConfig(cfg); err != nil {
+		return nil, err
+	}
+	if config.TrustedProxies, err = getTrustedProxies(cfg); err != nil {
+		return nil, err
+	}
+	if config.BatchMaxSize, err = getInt(cfg, "BATCH_MAX_SIZE", 1000); err != nil {
+		return nil, err
+	}
+	if config.BatchMaxSize == 0 {
+		return nil, fmt.Errorf("Error: BATCH_MAX_SIZE setting should be greater than 0")
+	}
+	if config.JobWorkers, err = getInt(cfg, "JOB_WORKERS", 2); err != nil {
+		return nil, err
+	}
+	if config.JobPollInterval, err = getPositiveDuration(cfg, "JOB_POLL_INTERVAL", time.Second); err != nil {
+		return nil, err
+	}
+	if config.MetricsEnabled, err = getBool(cfg, "METRICS_ENABLED", true); err != nil {
+		return nil, err
+	}
+	if config.MetricsRefresh, err = getPositiveDuration(cfg, "METRICS_REFRESH_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if config.Tracing, err = getTracingConfig(cfg); err != nil {
+		return nil, err
+	}
+	if config.Encryption, err = getEncryptionConfig(cfg); err != nil {
+		return nil, err
+	}
+	if config.HMACKeys, config.HMACMaxSkew, err = getHMACConfig(cfg); err != nil {
+		return nil, err
+	}
+	if config.TLS, err = getTLSConfig(cfg); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// serve runs the server until it is stopped with a signal, it returns false when the server failed
+func serve(config *serverConfig, logger *Logger) bool {
+	metrics := newServerMetrics()
+	accountService, err := NewAccountService(config.DB, logger, metrics, config.Encryption)
+	if err != nil {
+		logger.Error("Connecting to account service failed", "error", err)
+		return false
 	}
 	defer accountService.Close()
 
-	router := gin.Default()
+	// background jobs are stopped before the connection pool is closed
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	background := sync.WaitGroup{}
+	defer background.Wait()
+	defer stopBackground()
+	ready := newReadiness()
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		ready.watch(backgroundCtx, accountService, readinessInterval, logger)
+	}()
+	if config.MetricsEnabled {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			metrics.refreshAccounts(backgroundCtx, accountService, config.MetricsRefresh, logger)
+		}()
+	}
+	if config.Encryption != nil && config.Encryption.RotationEnabled {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			accountService.rotateKeys(backgroundCtx, config.Encryption.RotationInterval, config.Encryption.RotationBatchSize)
+		}()
+	}
+	if config.JobWorkers > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			accountService.runJobs(backgroundCtx, config.JobWorkers, config.JobPollInterval)
+		}()
+	}
+
+	shutdownTracing, err := setupTracing(config.Tracing, logger)
+	if err != nil {
+		logger.Error("Setting up tracing failed", "error", err)
+		return false
+	}
+	defer shutdownTracing()
+
+	var limiter *rateLimiter
+	if config.RateLimits != nil {
+		limiter = &rateLimiter{
+			limits: config.RateLimits.Limits,
+			store:  newMemoryRateLimitStore(),
+			keyBy:  config.RateLimits.KeyBy,
+			logger: logger,
+		}
+		if config.RateLimits.Store == "postgres" {
+			limiter.store = newPostgresRateLimitStore(accountService.dbConnPool)
+		}
+	}
+
+	router := gin.New()
+	router.Use(clientIPResolution(config.TrustedProxies), requestLogging(logger), gin.Recovery(), tracingMiddleware("apiserver"))
+	if config.MetricsEnabled {
+		router.Use(metrics.middleware())
+		router.GET("/metrics", metrics.handler())
+	}
 	v1 := router.Group("v1")
 	{
-		v1.GET("/health", health)
-		SetupAccountRouting(v1.Group("account"), accountService, logger)
+		v1.GET("/livez", livez)
+		v1.GET("/readyz", readyz(ready))
+		// health route of older clients and probes
+		v1.GET("/health", readyz(ready))
+		accountGroup := v1.Group("account")
+		importGroup := v1.Group("account-imports")
+		jobGroup := v1.Group("jobs")
+		hmacAuth := newHMACAuthenticator(config.HMACKeys, config.HMACMaxSkew, logger)
+		for _, group := range []*gin.RouterGroup{accountGroup, importGroup, jobGroup} {
+			if config.TLS != nil && config.TLS.ClientCAFile != "" {
+				group.Use(certificateAuthentication(config.TLS.ClientOrganisations, config.TLS.ClientScopes, logger))
+			}
+			if len(config.HMACKeys) == 0 {
+				continue
+			}
+			if group == accountGroup {
+				group.Use(hmacAuth.middleware())
+			} else {
+				// import files are not buffered, their digest is checked while they are read
+				group.Use(hmacAuth.streamingMiddleware())
+			}
+		}
+		streams := streamTimeout(config.HTTPTimeouts.Stream)
+		SetupAccountRouting(accountGroup, accountService, config.StatementTimeouts, streams, limiter, config.BatchMaxSize, logger)
+		SetupImportRouting(importGroup, accountService, config.StatementTimeouts, streams, limiter, logger)
+		SetupJobRouting(jobGroup, accountService, config.StatementTimeouts, streams, limiter, logger)
+	}
+
+	server := &http.Server{
+		Addr:              config.ListenAddress,
+		Handler:           router,
+		ReadHeaderTimeout: config.HTTPTimeouts.ReadHeader,
+		ReadTimeout:       config.HTTPTimeouts.Read,
+		WriteTimeout:      config.HTTPTimeouts.Write,
+		IdleTimeout:       config.HTTPTimeouts.Idle,
+		// routes streaming files change deadlines of their connection, see streamTimeout
+		ConnContext: withConnection,
+		// HTTP/2 is disabled, its streams share deadlines of the connection
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
 	}
-	router.Run()
+	if config.TLS != nil {
+		reloader, err := newCertificateReloader(config.TLS, logger)
+		if err != nil {
+			logger.Error("Loading TLS certificates failed", "error", err)
+			return false
+		}
+		reloader.watchSIGHUP()
+		server.TLSConfig = reloader.tlsConfig()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	return serveAndDrain(server, signals, ready, config.ShutdownDelay, config.ShutdownGracePeriod, logger)
 }
 
-func getDBConnConfig() (*DBConfig, error) {
-	config := DBConfig{}
-	var ok bool
+// serveAndDrain serves requests until a signal is received, then the server stops being ready,
+// and after `delay` it stops accepting new connections and waits up to `gracePeriod` for in-flight requests to finish.
+// A second signal stops the process immediately. It returns false when the server failed.
+func serveAndDrain(server *http.Server, signals chan os.Signal, ready *readiness, delay, gracePeriod time.Duration, logger *Logger) bool {
+	serveErrors := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			logger.Info("Listening and serving HTTPS", "address", server.Addr)
+			serveErrors <- server.ListenAndServeTLS("", "")
+		} else {
+			logger.Info("Listening and serving HTTP", "address", server.Addr)
+			serveErrors <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErrors:
+		logger.Error("Serving requests failed", "error", err)
+		return false
+	case received := <-signals:
+		signal.Stop(signals)
+		ready.drain()
+		logger.Info("Shutting down", "signal", received.String(), "delay", delay.String(), "grace_period", gracePeriod.String())
+		time.Sleep(delay)
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("Draining connections failed", "error", err)
+			return false
+		}
+		logger.Info("Drained connections")
+	}
+	return true
+}
 
-	if config.Host, ok = os.LookupEnv("DB_HOST"); !ok {
-		return nil, fmt.Errorf("Error: DB_HOST env variable not set")
+// getListenAddress returns address the server listens on, LISTEN_ADDRESS, or all interfaces and PORT (8080 by default)
+func getListenAddress(cfg *Config) (string, error) {
+	if address, ok := cfg.lookup("LISTEN_ADDRESS"); ok && address != "" {
+		return address, nil
+	}
+	port, ok := cfg.lookup("PORT")
+	if !ok {
+		return ":8080", nil
 	}
+	// an empty port would make the server listen on a random port
+	if number, err := strconv.Atoi(port); err != nil || number <= 0 || number > 65535 {
+		return "", fmt.Errorf("Error: PORT setting is not a port number %v", port)
+	}
+	return ":" + port, nil
+}
 
-	if port, ok := os.LookupEnv("DB_PORT"); ok {
+func getHTTPTimeouts(cfg *Config) (httpTimeouts, error) {
+	timeouts := httpTimeouts{}
+	var err error
+	if timeouts.ReadHeader, err = getDuration(cfg, "HTTP_READ_HEADER_TIMEOUT", 10*time.Second); err != nil {
+		return timeouts, err
+	}
+	if timeouts.Read, err = getDuration(cfg, "HTTP_READ_TIMEOUT", 30*time.Second); err != nil {
+		return timeouts, err
+	}
+	if timeouts.Write, err = getDuration(cfg, "HTTP_WRITE_TIMEOUT", 30*time.Second); err != nil {
+		return timeouts, err
+	}
+	if timeouts.Idle, err = getDuration(cfg, "HTTP_IDLE_TIMEOUT", 2*time.Minute); err != nil {
+		return timeouts, err
+	}
+	if timeouts.Stream, err = getDuration(cfg, "HTTP_STREAM_TIMEOUT", time.Hour); err != nil {
+		return timeouts, err
+	}
+	return timeouts, nil
+}
+
+// getDBConnConfig reads Postgres connection settings, DB_HOST, DB_PORT, DB_USER, DB_PASSWORD and DB_DATABASE are required without DB_URL
+func getDBConnConfig(cfg *Config) (*DBConfig, error) {
+	config := DBConfig{URL: cfg.get("DB_URL")}
+	var ok bool
+
+	if config.Host, ok = cfg.lookup("DB_HOST"); !ok && config.URL == "" {
+		return nil, fmt.Errorf("Error: DB_HOST setting not set")
+	}
+	if port, ok := cfg.lookup("DB_PORT"); ok {
 		var err error
 		if config.Port, err = strconv.Atoi(port); err != nil {
-			return nil, fmt.Errorf("Error: DB_PORT env variable is not a number %v", port)
+			return nil, fmt.Errorf("Error: DB_PORT setting is not a number %v", port)
 		}
-	} else {
-		return nil, fmt.Errorf("Error: DB_PORT env variable not set")
+	} else if config.URL == "" {
+		return nil, fmt.Errorf("Error: DB_PORT setting not set")
+	}
+	if config.User, ok = cfg.lookup("DB_USER"); !ok && config.URL == "" {
+		return nil, fmt.Errorf("Error: DB_USER setting not set")
+	}
+	if config.Password, ok = cfg.lookup("DB_PASSWORD"); !ok && config.URL == "" {
+		return nil, fmt.Errorf("Error: DB_PASSWORD setting not set")
+	}
+	if config.Database, ok = cfg.lookup("DB_DATABASE"); !ok && config.URL == "" {
+		return nil, fmt.Errorf("Error: DB_DATABASE setting not set")
 	}
 
-	if config.User, ok = os.LookupEnv("DB_USER"); !ok {
-		return nil, fmt.Errorf("Error: DB_USER env variable not set")
+	var err error
+	if config.MaxConns, err = getInt(cfg, "DB_MAX_CONNS", 0); err != nil {
+		return nil, err
+	}
+	if config.MinConns, err = getInt(cfg, "DB_MIN_CONNS", 0); err != nil {
+		return nil, err
+	}
+	if config.MaxConns > 0 && config.MinConns > config.MaxConns {
+		return nil, fmt.Errorf("Error: DB_MIN_CONNS setting is greater than DB_MAX_CONNS")
 	}
-	if config.Password, ok = os.LookupEnv("DB_PASSWORD"); !ok {
-		return nil, fmt.Errorf("Error: DB_PASSWORD env variable not set")
+	if config.MaxConnLifetime, err = getDuration(cfg, "DB_MAX_CONN_LIFETIME", 0); err != nil {
+		return nil, err
 	}
-	if config.Database, ok = os.LookupEnv("DB_DATABASE"); !ok {
-		return nil, fmt.Errorf("Error: DB_DATABASE env variable not set")
+	if config.MaxConnIdleTime, err = getDuration(cfg, "DB_MAX_CONN_IDLE_TIME", 0); err != nil {
+		return nil, err
+	}
+	if config.HealthCheckPeriod, err = getDuration(cfg, "DB_HEALTH_CHECK_PERIOD", 0); err != nil {
+		return nil, err
+	}
+	if config.ConnectTimeout, err = getDuration(cfg, "DB_CONNECT_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// getStatementTimeouts reads DB_STATEMENT_TIMEOUT, and DB_STATEMENT_TIMEOUTS with comma separated `route=duration` overrides, e.g. `list=10s`
+func getStatementTimeouts(cfg *Config) (statementTimeouts, error) {
+	// imports and exports are limited by HTTP_STREAM_TIMEOUT instead, they can take much longer than other requests
+	timeouts := statementTimeouts{routes: map[string]time.Duration{"import": 0, "export": 0}}
+	var err error
+	if timeouts.fallback, err = getDuration(cfg, "DB_STATEMENT_TIMEOUT", 5*time.Second); err != nil {
+		return timeouts, err
+	}
+	value, ok := cfg.lookup("DB_STATEMENT_TIMEOUTS")
+	if !ok || value == "" {
+		return timeouts, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || !accountRoutes[parts[0]] {
+			return timeouts, fmt.Errorf("Error: DB_STATEMENT_TIMEOUTS entry %v is not in route=duration format with list, fetch, create, batch, delete, import, export or job route", entry)
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil || timeout < 0 {
+			return timeouts, fmt.Errorf("Error: DB_STATEMENT_TIMEOUTS entry %v has wrong duration %v", parts[0], parts[1])
+		}
+		timeouts.routes[parts[0]] = timeout
+	}
+	return timeouts, nil
+}
+
+// getHMACConfig reads shared secrets used to verify request signatures.
+// HMAC_KEYS setting contains comma separated `keyID:organisationID:secret` entries, when not set signatures are not required.
+// HMAC_MAX_SKEW setting is the maximum age of a signature, e.g. `5m`.
+// HMAC_KEY_SCOPES setting contains comma separated `keyID=scope scope` entries granting scopes to keys, e.g. `key-1=pii:read`.
+func getHMACConfig(cfg *Config) (map[string]hmacKey, time.Duration, error) {
+	keys := map[string]hmacKey{}
+	maxSkew := 5 * time.Minute
+
+	if value, ok := cfg.lookup("HMAC_KEYS"); ok && value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+			if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+				return nil, 0, fmt.Errorf("Error: HMAC_KEYS entry is not in keyID:organisationID:secret format")
+			}
+			if _, err := uuid.Parse(parts[1]); err != nil {
+				return nil, 0, fmt.Errorf("Error: HMAC_KEYS entry %v has wrong organisation ID %v", parts[0], parts[1])
+			}
+			keys[parts[0]] = hmacKey{
+				Organisation: parts[1],
+				Secret:       []byte(parts[2]),
+			}
+		}
+	}
+	scopes, err := getScopes(cfg, "HMAC_KEY_SCOPES")
+	if err != nil {
+		return nil, 0, err
+	}
+	for keyID, keyScopes := range scopes {
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, 0, fmt.Errorf("Error: HMAC_KEY_SCOPES entry %v is not in HMAC_KEYS", keyID)
+		}
+		key.Scopes = keyScopes
+		keys[keyID] = key
+	}
+	if value, ok := cfg.lookup("HMAC_MAX_SKEW"); ok {
+		var err error
+		if maxSkew, err = time.ParseDuration(value); err != nil {
+			return nil, 0, fmt.Errorf("Error: HMAC_MAX_SKEW setting is not a duration %v", value)
+		}
+	}
+
+	return keys, maxSkew, nil
+}
+
+// getTLSConfig reads TLS certificates configuration, when TLS_CERT_FILE setting is not set the server uses plain HTTP.
+//   - TLS_CERT_FILE, TLS_KEY_FILE - server certificate and private key,
+//   - TLS_CLIENT_CA_FILE - CA bundle, when set clients of account routes have to present a certificate signed by it,
+//   - TLS_CLIENT_ORGANISATIONS - comma separated `commonName=organisationID` entries assigning client certificates to organisations,
+//   - TLS_CLIENT_SCOPES - comma separated `commonName=scope scope` entries granting scopes to client certificates, e.g. `client-1=pii:read`,
+//   - TLS_MIN_VERSION - minimum TLS version, `1.2` (default) or `1.3`.
+func getTLSConfig(cfg *Config) (*TLSConfig, error) {
+	certFile, ok := cfg.lookup("TLS_CERT_FILE")
+	if !ok || certFile == "" {
+		return nil, nil
+	}
+	config := TLSConfig{
+		CertFile:            certFile,
+		MinVersion:          tls.VersionTLS12,
+		ClientOrganisations: map[string]string{},
+	}
+	if config.KeyFile, ok = cfg.lookup("TLS_KEY_FILE"); !ok {
+		return nil, fmt.Errorf("Error: TLS_KEY_FILE setting not set")
+	}
+	config.ClientCAFile = cfg.get("TLS_CLIENT_CA_FILE")
+	if value, ok := cfg.lookup("TLS_CLIENT_ORGANISATIONS"); ok && value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("Error: TLS_CLIENT_ORGANISATIONS entry is not in commonName=organisationID format")
+			}
+			if _, err := uuid.Parse(parts[1]); err != nil {
+				return nil, fmt.Errorf("Error: TLS_CLIENT_ORGANISATIONS entry %v has wrong organisation ID %v", parts[0], parts[1])
+			}
+			config.ClientOrganisations[parts[0]] = parts[1]
+		}
+	}
+	var err error
+	if config.ClientScopes, err = getScopes(cfg, "TLS_CLIENT_SCOPES"); err != nil {
+		return nil, err
+	}
+	if value, ok := cfg.lookup("TLS_MIN_VERSION"); ok {
+		switch value {
+		case "1.2":
+			config.MinVersion = tls.VersionTLS12
+		case "1.3":
+			config.MinVersion = tls.VersionTLS13
+		default:
+			return nil, fmt.Errorf("Error: TLS_MIN_VERSION setting should be 1.2 or 1.3, got %v", value)
+		}
 	}
 
 	return &config, nil
 }
+
+// getScopes reads comma separated `name=scope scope` entries from the setting
+func getScopes(cfg *Config, envName string) (map[string][]string, error) {
+	scopes := map[string][]string{}
+	value, ok := cfg.lookup(envName)
+	if !ok || value == "" {
+		return scopes, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Error: %v entry is not in name=scope format", envName)
+		}
+		scopes[parts[0]] = strings.Fields(parts[1])
+	}
+	return scopes, nil
+}
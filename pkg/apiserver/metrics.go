@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes names of all metrics exposed by the server
+const metricsNamespace = "apiserver"
+
+// serverMetrics are Prometheus metrics exposed on `/metrics` endpoint
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+	accounts        *prometheus.GaugeVec
+	keyRotation     *prometheus.GaugeVec
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method, route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "account_service",
+			Name:      "query_duration_seconds",
+			Help:      "Latency of AccountService queries by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "account_service",
+			Name:      "query_errors_total",
+			Help:      "Number of failed AccountService queries by operation.",
+		}, []string{"operation"}),
+		accounts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "accounts",
+			Help:      "Number of stored accounts by country and status, refreshed periodically.",
+		}, []string{"country", "status"}),
+		keyRotation: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "key_rotation_accounts",
+			Help:      "Number of accounts not yet re-encrypted with the active key, and of accounts which failed to be re-encrypted, by key ID.",
+		}, []string{"kek_id", "state"}),
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.queryDuration,
+		m.queryErrors,
+		m.accounts,
+		m.keyRotation,
+	)
+	return m
+}
+
+// handler serves metrics in Prometheus text format
+func (m *serverMetrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// middleware counts requests and measures their latency, requests not matching any route are labelled with an empty route
+func (m *serverMetrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := strconv.Itoa(c.Writer.Status())
+		m.requests.WithLabelValues(c.Request.Method, c.FullPath(), status).Inc()
+		m.requestDuration.WithLabelValues(c.Request.Method, c.FullPath(), status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeQuery records latency of an AccountService query, and counts it as failed when `err` is not nil
+func (m *serverMetrics) observeQuery(operation string, start time.Time, err error) {
+	m.queryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.queryErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// observeKeyRotation records progress of the key rotation to the active key
+func (m *serverMetrics) observeKeyRotation(kekID string, remaining, failed int64) {
+	m.keyRotation.WithLabelValues(kekID, "remaining").Set(float64(remaining))
+	m.keyRotation.WithLabelValues(kekID, "failed").Set(float64(failed))
+}
+
+// registerPool exposes statistics of the database connection pool
+func (m *serverMetrics) registerPool(pool *pgxpool.Pool) {
+	m.registry.MustRegister(&poolCollector{pool: pool})
+}
+
+// refreshAccounts periodically recounts stored accounts until the context is cancelled
+func (m *serverMetrics) refreshAccounts(ctx context.Context, accountService *AccountService, interval time.Duration, logger *Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		counts, err := accountService.countAccounts(ctx)
+		if err != nil {
+			logger.Error("Refreshing account metrics failed", "error", err)
+		} else {
+			m.accounts.Reset()
+			for _, count := range counts {
+				m.accounts.WithLabelValues(count.Country, count.Status).Set(float64(count.Count))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+var (
+	poolAcquiredConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db_pool", "acquired_connections"),
+		"Number of connections currently acquired from the pool.", nil, nil)
+	poolIdleConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db_pool", "idle_connections"),
+		"Number of idle connections in the pool.", nil, nil)
+	poolTotalConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db_pool", "connections"),
+		"Number of connections in the pool.", nil, nil)
+	poolMaxConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db_pool", "max_connections"),
+		"Maximum size of the pool.", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db_pool", "acquires_total"),
+		"Number of successful connection acquires.", nil, nil)
+	poolEmptyAcquiresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db_pool", "empty_acquires_total"),
+		"Number of acquires that had to wait for a connection.", nil, nil)
+	poolAcquireWaitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db_pool", "acquire_wait_seconds_total"),
+		"Total time spent waiting for a connection.", nil, nil)
+)
+
+// poolCollector reads statistics from the pool every time metrics are scraped
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (pc *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConnsDesc
+	ch <- poolIdleConnsDesc
+	ch <- poolTotalConnsDesc
+	ch <- poolMaxConnsDesc
+	ch <- poolAcquiresDesc
+	ch <- poolEmptyAcquiresDesc
+	ch <- poolAcquireWaitDesc
+}
+
+func (pc *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := pc.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConnsDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquiresDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWaitDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		metrics *serverMetrics
+		router  *gin.Engine
+	)
+
+	BeforeEach(func() {
+		metrics = newServerMetrics()
+		router = gin.New()
+		router.Use(metrics.middleware())
+		router.GET("/metrics", metrics.handler())
+		router.GET("/v1/account/:accountId", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	})
+
+	Context("when requests are handled", func() {
+
+		BeforeEach(func() {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/account/ad27e265-9605-4b4b-a0e5-3003ea9cc4dc", nil))
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/account/0d209d7f-d07a-4542-947f-5885fddddae2", nil))
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+		})
+
+		It("should count them by route template, not by path", func() {
+			Ω(testutil.ToFloat64(metrics.requests.WithLabelValues("GET", "/v1/account/:accountId", "404"))).Should(Equal(2.0))
+			Ω(testutil.ToFloat64(metrics.requests.WithLabelValues("GET", "", "404"))).Should(Equal(1.0))
+			Ω(testutil.CollectAndCount(metrics.requestDuration)).Should(Equal(2))
+		})
+
+		It("should expose them in Prometheus text format", func() {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			Ω(recorder.Code).Should(Equal(http.StatusOK))
+			Ω(recorder.Body.String()).Should(ContainSubstring(`apiserver_http_requests_total{method="GET",route="/v1/account/:accountId",status="404"} 2`))
+			Ω(recorder.Body.String()).Should(ContainSubstring("go_goroutines"))
+		})
+	})
+
+	Context("when queries are observed", func() {
+
+		It("should measure their latency and count errors by operation", func() {
+			metrics.observeQuery("getAccount", time.Now(), nil)
+			metrics.observeQuery("getAccount", time.Now(), errors.New("timeout"))
+
+			Ω(testutil.CollectAndCount(metrics.queryDuration)).Should(Equal(1))
+			Ω(testutil.ToFloat64(metrics.queryErrors.WithLabelValues("getAccount"))).Should(Equal(1.0))
+		})
+	})
+
+	Context("when key rotation progresses", func() {
+
+		It("should expose remaining and failed accounts by key ID", func() {
+			metrics.observeKeyRotation("new", 10, 0)
+			metrics.observeKeyRotation("new", 2, 2)
+
+			Ω(testutil.ToFloat64(metrics.keyRotation.WithLabelValues("new", "remaining"))).Should(Equal(2.0))
+			Ω(testutil.ToFloat64(metrics.keyRotation.WithLabelValues("new", "failed"))).Should(Equal(2.0))
+		})
+	})
+})
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// migration is a schema change, statements have to be idempotent because every server instance runs all of them on start
+type migration struct {
+	name      string
+	statement string
+}
+
+// migrations are applied in order, new migrations have to be appended at the end.
+// Number of applied migrations is stored in "SchemaVersion" table and checked by the readiness probe.
+var migrations = []migration{
+	// install postgres extension to generate uuid, i.e. use uuid_generate_v4()
+	{"Installing Postgres extension uuid-ossp", `CREATE EXTENSION IF NOT EXISTS "uuid-ossp";`},
+	{"Creating Account table", `CREATE TABLE IF NOT EXISTS "Account" (
+		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+		organisation_id UUID NOT NULL,
+		version INTEGER NOT NULL DEFAULT 0,
+		is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+		is_locked BOOLEAN NOT NULL DEFAULT FALSE,
+		created_on TIMESTAMP NOT NULL DEFAULT NOW(),
+		modified_on TIMESTAMP,
+		record jsonb
+	)`},
+	// private identification encrypted with a data key, see `envelope`
+	{"Adding private_identification column", `ALTER TABLE "Account" ADD COLUMN IF NOT EXISTS private_identification jsonb`},
+	{"Creating KeyRotation table", `CREATE TABLE IF NOT EXISTS "KeyRotation" (
+		kek_id TEXT PRIMARY KEY,
+		started_on TIMESTAMP NOT NULL DEFAULT NOW(),
+		modified_on TIMESTAMP NOT NULL DEFAULT NOW(),
+		finished_on TIMESTAMP,
+		rotated BIGINT NOT NULL DEFAULT 0,
+		remaining BIGINT NOT NULL DEFAULT 0
+	)`},
+	// token buckets of `postgresRateLimitStore`
+	{"Creating RateLimit table", `CREATE TABLE IF NOT EXISTS "RateLimit" (
+		key TEXT PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		allowed BOOLEAN NOT NULL,
+		capacity DOUBLE PRECISION NOT NULL,
+		rate DOUBLE PRECISION NOT NULL,
+		updated_on TIMESTAMP WITH TIME ZONE NOT NULL
+	)`},
+	// summaries and rejection reports of account imports, see `importAccounts`
+	{"Creating AccountImport table", `CREATE TABLE IF NOT EXISTS "AccountImport" (
+		id UUID PRIMARY KEY,
+		owner TEXT NOT NULL,
+		conflict TEXT NOT NULL,
+		received INTEGER NOT NULL,
+		imported INTEGER NOT NULL,
+		skipped INTEGER NOT NULL,
+		rejected INTEGER NOT NULL,
+		created_on TIMESTAMP NOT NULL DEFAULT NOW()
+	)`},
+	{"Creating AccountImportRejection table", `CREATE TABLE IF NOT EXISTS "AccountImportRejection" (
+		import_id UUID NOT NULL REFERENCES "AccountImport" (id) ON DELETE CASCADE,
+		line INTEGER NOT NULL,
+		account_id TEXT NOT NULL,
+		code TEXT NOT NULL,
+		message TEXT NOT NULL,
+		PRIMARY KEY (import_id, line)
+	)`},
+	// asynchronous imports and exports, see `runJobs`
+	{"Creating Job table", `CREATE TABLE IF NOT EXISTS "Job" (
+		id UUID PRIMARY KEY,
+		owner TEXT NOT NULL,
+		type TEXT NOT NULL,
+		params jsonb NOT NULL,
+		status TEXT NOT NULL,
+		processed BIGINT NOT NULL DEFAULT 0,
+		error TEXT,
+		result jsonb,
+		cancel_requested BOOLEAN NOT NULL DEFAULT FALSE,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_on TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		started_on TIMESTAMP WITH TIME ZONE,
+		heartbeat_on TIMESTAMP WITH TIME ZONE,
+		finished_on TIMESTAMP WITH TIME ZONE
+	)`},
+	{"Creating Job queue index", `CREATE INDEX IF NOT EXISTS "Job_queue" ON "Job" (created_on) WHERE status IN ('queued', 'running')`},
+	// import files and export results of jobs, in chunks so they are never loaded into memory at once
+	{"Creating JobChunk table", `CREATE TABLE IF NOT EXISTS "JobChunk" (
+		job_id UUID NOT NULL REFERENCES "Job" (id) ON DELETE CASCADE,
+		stream TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		data BYTEA NOT NULL,
+		PRIMARY KEY (job_id, stream, seq)
+	)`},
+	// accounts skipped by key rotation, see `rotateAllKeys`
+	{"Adding KeyRotation failed column", `ALTER TABLE "KeyRotation" ADD COLUMN IF NOT EXISTS failed BIGINT NOT NULL DEFAULT 0`},
+}
+
+// migrate applies all migrations and records the schema version
+func migrate(ctx context.Context, pool *pgxpool.Pool, logger *Logger) error {
+	for _, m := range migrations {
+		if _, err := pool.Exec(ctx, m.statement); err != nil {
+			logger.Error(m.name+" failed", "error", err)
+			return err
+		}
+	}
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS "SchemaVersion" (
+		id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+		version INTEGER NOT NULL,
+		modified_on TIMESTAMP NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		logger.Error("Creating SchemaVersion table failed", "error", err)
+		return err
+	}
+	// the version never goes back, e.g. when an older instance starts during a rolling deploy
+	_, err = pool.Exec(ctx,
+		`INSERT INTO "SchemaVersion" (version) VALUES ($1)
+			ON CONFLICT (id) DO UPDATE SET version = GREATEST("SchemaVersion".version, $1), modified_on = current_timestamp`,
+		len(migrations),
+	)
+	if err != nil {
+		logger.Error("Saving schema version failed", "error", err)
+		return err
+	}
+	logger.Info("Applied database migrations", "version", len(migrations))
+	return nil
+}
+
+// checkMigrations returns an error when the database schema is older than the schema the server needs
+func checkMigrations(ctx context.Context, conn *pgx.Conn) error {
+	var version int
+	if err := conn.QueryRow(ctx, `SELECT version FROM "SchemaVersion"`).Scan(&version); err != nil {
+		return fmt.Errorf("reading schema version: %v", err)
+	}
+	if version < len(migrations) {
+		return fmt.Errorf("schema version %v, expected %v", version, len(migrations))
+	}
+	return nil
+}
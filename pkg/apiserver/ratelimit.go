@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// rateLimit is a token bucket: it holds up to `requests` tokens and is refilled with `requests` tokens every `period`
+type rateLimit struct {
+	requests int
+	period   time.Duration
+}
+
+// rate returns number of tokens added every second
+func (l rateLimit) rate() float64 {
+	return float64(l.requests) / l.period.Seconds()
+}
+
+// rateLimitClasses are names of route classes with separate limits
+var rateLimitClasses = map[string]bool{"read": true, "write": true, "list": true}
+
+// rateLimitStore keeps token buckets
+type rateLimitStore interface {
+	// take removes one token from the bucket, it returns tokens left after that.
+	// When the bucket has less than one token the request is not allowed and no token is removed.
+	take(ctx context.Context, key string, limit rateLimit) (tokens float64, allowed bool, err error)
+}
+
+// rateLimiter rejects requests of callers who used all tokens of the route class with 429 status code
+type rateLimiter struct {
+	limits map[string]rateLimit // by route class, classes without a limit are not limited
+	store  rateLimitStore
+	keyBy  string // `organisation` or `principal`
+	logger *Logger
+}
+
+// middleware limits requests of the route class, it sets `RateLimit-Limit`, `RateLimit-Remaining` and `RateLimit-Reset` headers,
+// and `Retry-After` header on rejected requests. When the store fails requests are allowed.
+func (rl *rateLimiter) middleware(class string) gin.HandlerFunc {
+	limit, ok := rateLimit{}, false
+	if rl != nil {
+		limit, ok = rl.limits[class]
+	}
+	if !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		tokens, allowed, err := rl.store.take(c.Request.Context(), class+":"+rl.key(c), limit)
+		if err != nil {
+			loggerFrom(c.Request.Context(), rl.logger).Warn("Rate limiting failed, allowing the request", "error", err)
+			c.Next()
+			return
+		}
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(limit.requests)-tokens)/limit.rate()))))
+		if !allowed {
+			retryAfter := int(math.Max(1, math.Ceil((1-tokens)/limit.rate())))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"status": "error", "code": "rate_limited", "message": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// key identifies the bucket of the caller: organisation, or HMAC key / certificate subject, and client IP without authentication
+func (rl *rateLimiter) key(c *gin.Context) string {
+	p := getPrincipal(c)
+	switch {
+	case p == nil:
+		return "ip:" + clientIP(c)
+	case rl.keyBy == "principal" && p.KeyID != "":
+		return "key:" + p.KeyID
+	case rl.keyBy == "principal" && p.Subject != "":
+		return "subject:" + p.Subject
+	default:
+		return "organisation:" + strings.ToLower(p.Organisation)
+	}
+}
+
+// rateLimitCleanupInterval is how often buckets which were not used for a long time are removed
+const rateLimitCleanupInterval = time.Minute
+
+//
+// in-memory store, limits are not shared between server instances
+//
+
+type memoryRateLimitStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	capacity float64
+	rate     float64
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+func (s *memoryRateLimitStore) take(ctx context.Context, key string, limit rateLimit) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastCleanup) > rateLimitCleanupInterval {
+		s.cleanup(now)
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.requests), updated: now}
+		s.buckets[key] = bucket
+	}
+	bucket.capacity, bucket.rate = float64(limit.requests), limit.rate()
+	bucket.tokens = math.Min(bucket.capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*bucket.rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return bucket.tokens, false, nil
+	}
+	bucket.tokens--
+	return bucket.tokens, true, nil
+}
+
+// cleanup removes full buckets, they are the same as buckets which do not exist
+func (s *memoryRateLimitStore) cleanup(now time.Time) {
+	for key, bucket := range s.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*bucket.rate >= bucket.capacity {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastCleanup = now
+}
+
+//
+// Postgres store, limits are shared between server instances using the same database
+//
+
+type postgresRateLimitStore struct {
+	pool *pgxpool.Pool
+
+	mu          sync.Mutex
+	lastCleanup time.Time
+}
+
+func newPostgresRateLimitStore(pool *pgxpool.Pool) *postgresRateLimitStore {
+	return &postgresRateLimitStore{pool: pool}
+}
+
+// take refills and takes a token in one statement, so concurrent requests of many server instances are counted correctly
+func (s *postgresRateLimitStore) take(ctx context.Context, key string, limit rateLimit) (float64, bool, error) {
+	s.cleanup(ctx)
+	var tokens float64
+	var allowed bool
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO "RateLimit" AS r (key, tokens, allowed, capacity, rate, updated_on) VALUES ($1, $2::float8 - 1, TRUE, $2, $3, statement_timestamp())
+			ON CONFLICT (key) DO UPDATE SET
+				tokens = LEAST($2::float8, r.tokens + EXTRACT(EPOCH FROM statement_timestamp() - r.updated_on)::float8 * $3::float8)
+					- CASE WHEN LEAST($2::float8, r.tokens + EXTRACT(EPOCH FROM statement_timestamp() - r.updated_on)::float8 * $3::float8) >= 1 THEN 1 ELSE 0 END,
+				allowed = LEAST($2::float8, r.tokens + EXTRACT(EPOCH FROM statement_timestamp() - r.updated_on)::float8 * $3::float8) >= 1,
+				capacity = $2,
+				rate = $3,
+				updated_on = statement_timestamp()
+			RETURNING tokens, allowed`,
+		key, float64(limit.requests), limit.rate(),
+	).Scan(&tokens, &allowed)
+	if err != nil {
+		return 0, false, fmt.Errorf("taking rate limit token: %v", err)
+	}
+	return tokens, allowed, nil
+}
+
+// cleanup removes full buckets at most once every rateLimitCleanupInterval
+func (s *postgresRateLimitStore) cleanup(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastCleanup) < rateLimitCleanupInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastCleanup = time.Now()
+	s.mu.Unlock()
+	// errors are ignored, full buckets are removed by the next cleanup
+	s.pool.Exec(ctx, `DELETE FROM "RateLimit" WHERE tokens + EXTRACT(EPOCH FROM statement_timestamp() - updated_on)::float8 * rate >= capacity`)
+}
+
+// RateLimitConfig configures per-caller limits of account routes
+type RateLimitConfig struct {
+	Limits map[string]rateLimit // by route class: read, write or list
+	Store  string               // `memory` or `postgres`
+	KeyBy  string               // `organisation` or `principal`
+}
+
+// getRateLimitConfig reads rate limits, requests are not limited when RATE_LIMITS is not set.
+//   - RATE_LIMITS - comma separated `class=requests/period` entries, e.g. `read=100/1m,write=20/1m,list=10/1m`,
+//   - RATE_LIMIT_STORE - `memory` (default) keeps limits of each server instance, `postgres` shares limits between instances,
+//   - RATE_LIMIT_KEY - `organisation` (default) limits all callers of an organisation together, `principal` limits every HMAC key or client certificate.
+func getRateLimitConfig(cfg *Config) (*RateLimitConfig, error) {
+	value, ok := cfg.lookup("RATE_LIMITS")
+	if !ok || value == "" {
+		return nil, nil
+	}
+	config := RateLimitConfig{
+		Limits: map[string]rateLimit{},
+		Store:  "memory",
+		KeyBy:  "organisation",
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || !rateLimitClasses[parts[0]] {
+			return nil, fmt.Errorf("Error: RATE_LIMITS entry %v is not in class=requests/period format with read, write or list class", entry)
+		}
+		limit := strings.SplitN(parts[1], "/", 2)
+		if len(limit) != 2 {
+			return nil, fmt.Errorf("Error: RATE_LIMITS entry %v is not in class=requests/period format", entry)
+		}
+		requests, err := strconv.Atoi(limit[0])
+		if err != nil || requests <= 0 {
+			return nil, fmt.Errorf("Error: RATE_LIMITS entry %v has wrong number of requests %v", parts[0], limit[0])
+		}
+		period, err := time.ParseDuration(limit[1])
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("Error: RATE_LIMITS entry %v has wrong period %v", parts[0], limit[1])
+		}
+		config.Limits[parts[0]] = rateLimit{requests: requests, period: period}
+	}
+	if value, ok := cfg.lookup("RATE_LIMIT_STORE"); ok {
+		if value != "memory" && value != "postgres" {
+			return nil, fmt.Errorf("Error: RATE_LIMIT_STORE setting should be memory or postgres, got %v", value)
+		}
+		config.Store = value
+	}
+	if value, ok := cfg.lookup("RATE_LIMIT_KEY"); ok {
+		if value != "organisation" && value != "principal" {
+			return nil, fmt.Errorf("Error: RATE_LIMIT_KEY setting should be organisation or principal, got %v", value)
+		}
+		config.KeyBy = value
+	}
+	return &config, nil
+}
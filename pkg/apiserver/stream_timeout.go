@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type connContextKey struct{}
+
+// withConnection is `http.Server.ConnContext`, it keeps the connection in the context of its requests,
+// so deadlines of the connection can be changed by `streamTimeout`
+func withConnection(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// streamTimeout limits time of routes streaming large files, e.g. imports and exports, instead of HTTP_READ_TIMEOUT and HTTP_WRITE_TIMEOUT,
+// which are too short for them. They are not limited when it is 0, so a slow client keeps an import transaction open as long as it sends data.
+type streamTimeout time.Duration
+
+// middleware replaces read and write deadlines of the connection set by the server, the server sets them again for the next request.
+// Connections are HTTP/1.1 only, HTTP/2 streams share the connection and its deadlines.
+func (t streamTimeout) middleware() gin.HandlerFunc {
+	return t.middlewareIf(func(*gin.Context) bool { return true })
+}
+
+// middlewareIf is like `middleware`, but it is decided for every request if it streams a file
+func (t streamTimeout) middlewareIf(streams func(c *gin.Context) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, ok := c.Request.Context().Value(connContextKey{}).(net.Conn)
+		if ok && streams(c) {
+			deadline := time.Time{}
+			if t > 0 {
+				deadline = time.Now().Add(time.Duration(t))
+			}
+			conn.SetReadDeadline(deadline)
+			conn.SetWriteDeadline(deadline)
+		}
+		c.Next()
+	}
+}
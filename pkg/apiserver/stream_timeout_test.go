@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream timeout", func() {
+	var (
+		server *httptest.Server
+
+		// slowUpload sends the body in parts for longer than the read timeout
+		slowUpload = func(path string) (*http.Response, error) {
+			reader, writer := io.Pipe()
+			go func() {
+				for i := 0; i < 4; i++ {
+					time.Sleep(50 * time.Millisecond)
+					writer.Write([]byte("{}\n"))
+				}
+				writer.Close()
+			}()
+			return http.Post(server.URL+path, "application/x-ndjson", reader)
+		}
+	)
+
+	BeforeEach(func() {
+		router := gin.New()
+		upload := func(c *gin.Context) {
+			body, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				c.Status(http.StatusBadRequest)
+				return
+			}
+			c.String(http.StatusOK, "%d", len(body))
+		}
+		download := func(c *gin.Context) {
+			time.Sleep(200 * time.Millisecond)
+			c.String(http.StatusOK, "exported")
+		}
+		router.POST("/upload", upload)
+		router.POST("/stream/upload", streamTimeout(0).middleware(), upload)
+		router.GET("/download", download)
+		router.GET("/stream/download", streamTimeout(0).middleware(), download)
+		router.GET("/limited/download", streamTimeout(50*time.Millisecond).middleware(), download)
+		router.GET("/accounts/:accountId", streamTimeout(0).middlewareIf(isExport), download)
+
+		server = httptest.NewUnstartedServer(router)
+		server.Config.ReadTimeout = 100 * time.Millisecond
+		server.Config.WriteTimeout = 100 * time.Millisecond
+		server.Config.ConnContext = withConnection
+		server.Start()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should let uploads take longer than the read timeout", func() {
+		resp, err := slowUpload("/stream/upload")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(string(body)).Should(Equal("12"))
+	})
+
+	It("should keep the read timeout of other routes", func() {
+		resp, err := slowUpload("/upload")
+		if err == nil {
+			defer resp.Body.Close()
+			Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		}
+	})
+
+	It("should let downloads take longer than the write timeout", func() {
+		resp, err := http.Get(server.URL + "/stream/download")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(body)).Should(Equal("exported"))
+	})
+
+	It("should keep the write timeout of other routes", func() {
+		_, err := http.Get(server.URL + "/download")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should let exports take longer than the write timeout", func() {
+		resp, err := http.Get(server.URL + "/accounts/" + exportPath)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(body)).Should(Equal("exported"))
+	})
+
+	It("should keep the write timeout of account fetches", func() {
+		_, err := http.Get(server.URL + "/accounts/ad27e265-9605-4b4b-a0e5-3003ea9cc4dc")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should limit downloads with the stream timeout", func() {
+		_, err := http.Get(server.URL + "/limited/download")
+		Ω(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TLSConfig holds certificates used to serve TLS
+type TLSConfig struct {
+	CertFile     string // PEM encoded server certificate
+	KeyFile      string // PEM encoded server private key
+	ClientCAFile string // PEM encoded CA bundle, when set clients of account routes have to present a certificate signed by it
+	MinVersion   uint16 // Minimum TLS version
+
+	// ClientOrganisations maps client certificate subject common name to organisation ID
+	ClientOrganisations map[string]string
+	// ClientScopes maps client certificate subject common name to scopes granted to the client, e.g. `pii:read`
+	ClientScopes map[string][]string
+}
+
+// certificateReloader keeps current server certificate and client CAs, and reloads them from files on SIGHUP
+type certificateReloader struct {
+	config *TLSConfig
+	logger *Logger
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+func newCertificateReloader(config *TLSConfig, logger *Logger) (*certificateReloader, error) {
+	r := &certificateReloader{
+		config: config,
+		logger: logger,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads certificates from files, on error the current certificates are kept
+func (r *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load server certificate: %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read client CA bundle: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("Failed to load client CA bundle: no certificates in %v", r.config.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	return nil
+}
+
+// watchSIGHUP reloads certificates every time the process receives SIGHUP
+func (r *certificateReloader) watchSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := r.reload(); err != nil {
+				r.logger.Error("Reloading TLS certificates failed", "error", err)
+				continue
+			}
+			r.logger.Info("Reloaded TLS certificates")
+		}
+	}()
+}
+
+// tlsConfig creates server `tls.Config`, every new connection uses the most recently loaded certificates.
+// Client certificates are verified when they are sent, but not required, so health checks and metrics work without them:
+// certificateAuthentication rejects requests of the account routes without a client certificate.
+func (r *certificateReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.config.MinVersion,
+		// `http.Server.ServeTLS` requires a certificate in the config itself, connections get it from GetConfigForClient
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.certificate, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   r.config.MinVersion,
+				Certificates: []tls.Certificate{*r.certificate},
+				NextProtos:   []string{"http/1.1"},
+			}
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
+
+// certificateAuthentication maps verified client certificate to the organisation, requests without a client certificate are rejected
+func certificateAuthentication(organisations map[string]string, scopes map[string][]string, logger *Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.PeerCertificates) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "Missing client certificate"})
+			return
+		}
+		subject := c.Request.TLS.PeerCertificates[0].Subject.CommonName
+		organisation, ok := organisations[subject]
+		if !ok {
+			loggerFrom(c.Request.Context(), logger).Warn("Client certificate authentication failed: unknown subject", "subject", subject)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "Client certificate not assigned to any organisation"})
+			return
+		}
+		setPrincipal(c, &principal{
+			Organisation: organisation,
+			Subject:      subject,
+			Scopes:       scopes[subject],
+		})
+		c.Next()
+	}
+}
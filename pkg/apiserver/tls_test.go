@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS with client certificates", func() {
+	var (
+		certificates *testCertificates
+		server       *http.Server
+		serverURL    string
+	)
+
+	// get sends the request with the client certificate when it is set
+	get := func(path string, clientCertificates ...tls.Certificate) (int, error) {
+		client := http.Client{
+			Timeout: time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: certificates.caPool, Certificates: clientCertificates},
+			},
+		}
+		resp, err := client.Get(serverURL + path)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		_, _ = ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, nil
+	}
+
+	BeforeEach(func() {
+		certificates = generateTestCertificates("client-1")
+		logger := NewLogger(GinkgoWriter, levelError)
+		reloader, err := newCertificateReloader(&TLSConfig{
+			CertFile:            filepath.Join(certificates.dir, "server.pem"),
+			KeyFile:             filepath.Join(certificates.dir, "server-key.pem"),
+			ClientCAFile:        filepath.Join(certificates.dir, "ca.pem"),
+			MinVersion:          tls.VersionTLS12,
+			ClientOrganisations: map[string]string{"client-1": "eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"},
+		}, logger)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		router := gin.New()
+		router.GET("/v1/livez", livez)
+		accounts := router.Group("/v1/account")
+		accounts.Use(certificateAuthentication(reloader.config.ClientOrganisations, nil, logger))
+		accounts.GET("/", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"organisation": callerOrganisation(c)}) })
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).ShouldNot(HaveOccurred())
+		serverURL = "https://" + listener.Addr().String()
+		server = &http.Server{Handler: router, TLSConfig: reloader.tlsConfig()}
+		go func() {
+			defer GinkgoRecover()
+			Ω(server.ServeTLS(listener, "", "")).Should(Equal(http.ErrServerClosed))
+		}()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(certificates.dir)
+	})
+
+	It("should serve health checks without a client certificate", func() {
+		Ω(get("/v1/livez")).Should(Equal(http.StatusOK))
+	})
+
+	It("should reject account routes without a client certificate", func() {
+		Ω(get("/v1/account/")).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("should accept account routes with a client certificate", func() {
+		clientCertificate, err := tls.LoadX509KeyPair(filepath.Join(certificates.dir, "client.pem"), filepath.Join(certificates.dir, "client-key.pem"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(get("/v1/account/", clientCertificate)).Should(Equal(http.StatusOK))
+	})
+})
+
+// testCertificates are files of a CA, a server certificate for 127.0.0.1 and a client certificate signed by the CA,
+// like `libtest.TestCertificates`, which cannot be used here because libtest specs require Postgres
+type testCertificates struct {
+	dir    string
+	caPool *x509.CertPool
+}
+
+func generateTestCertificates(clientCommonName string) *testCertificates {
+	dir, err := ioutil.TempDir("", "certificates")
+	Ω(err).ShouldNot(HaveOccurred())
+	result := &testCertificates{dir: dir, caPool: x509.NewCertPool()}
+
+	ca := testCertificateTemplate("Test CA")
+	ca.IsCA = true
+	ca.BasicConstraintsValid = true
+	ca.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caCert, caKey := writeTestCertificate(ca, nil, nil, filepath.Join(dir, "ca"))
+	result.caPool.AddCert(caCert)
+
+	server := testCertificateTemplate("localhost")
+	server.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	server.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	writeTestCertificate(server, caCert, caKey, filepath.Join(dir, "server"))
+
+	client := testCertificateTemplate(clientCommonName)
+	client.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	writeTestCertificate(client, caCert, caKey, filepath.Join(dir, "client"))
+	return result
+}
+
+func testCertificateTemplate(commonName string) *x509.Certificate {
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Ω(err).ShouldNot(HaveOccurred())
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+// writeTestCertificate writes `<name>.pem` and `<name>-key.pem` signed by `parent`, or self signed when `parent` is nil
+func writeTestCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Ω(err).ShouldNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Ω(err).ShouldNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(ioutil.WriteFile(name+".pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).Should(Succeed())
+	Ω(ioutil.WriteFile(name+"-key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)).Should(Succeed())
+	return cert, key
+}
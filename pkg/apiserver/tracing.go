@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// instrumentationName identifies spans created by the server
+const instrumentationName = "github.com/fkondej/go-showcase/v1/pkg/apiserver"
+
+// TracingConfig selects where spans are exported
+type TracingConfig struct {
+	Exporter     string // none, stdout or otlp
+	OTLPEndpoint string // `host:port` of the OTLP gRPC collector
+	OTLPInsecure bool   // whether the collector is connected without TLS
+}
+
+// setupTracing installs the global tracer provider, exporter is selected by OTEL_TRACES_EXPORTER setting:
+//   - none (default) - spans are not recorded,
+//   - stdout - spans are printed to standard output,
+//   - otlp - spans are sent to the OpenTelemetry collector at OTEL_EXPORTER_OTLP_ENDPOINT with OTLP over gRPC.
+//
+// The returned function flushes spans, it should be called before the server exits.
+func setupTracing(config *TracingConfig, logger *Logger) (func(), error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var (
+		exporter exporttrace.SpanExporter
+		err      error
+	)
+	switch config.Exporter {
+	case "", "none":
+		return func() {}, nil
+	case "stdout":
+		if exporter, err = stdout.NewExporter(stdout.WithPrettyPrint()); err != nil {
+			return nil, fmt.Errorf("Error: failed to create stdout trace exporter: %v", err)
+		}
+	case "otlp":
+		options := []otlpgrpc.Option{otlpgrpc.WithEndpoint(config.OTLPEndpoint)}
+		if config.OTLPInsecure {
+			options = append(options, otlpgrpc.WithInsecure())
+		} else {
+			options = append(options, otlpgrpc.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, "")))
+		}
+		// the collector is connected in background, spans are dropped until it is reachable
+		if exporter, err = otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(options...)); err != nil {
+			return nil, fmt.Errorf("Error: failed to create OTLP trace exporter: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("Error: unknown trace exporter %v", config.Exporter)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	logger.Info("Tracing enabled", "exporter", config.Exporter)
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			logger.Error("Flushing spans failed", "error", err)
+		}
+	}, nil
+}
+
+// getTracingConfig reads OTEL_TRACES_EXPORTER setting, and OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_EXPORTER_OTLP_INSECURE settings of the otlp exporter
+func getTracingConfig(cfg *Config) (*TracingConfig, error) {
+	config := &TracingConfig{Exporter: cfg.get("OTEL_TRACES_EXPORTER")}
+	switch config.Exporter {
+	case "", "none", "stdout":
+		return config, nil
+	case "otlp":
+	default:
+		return nil, fmt.Errorf("Error: OTEL_TRACES_EXPORTER setting should be none, stdout or otlp, got %v", config.Exporter)
+	}
+	config.OTLPEndpoint = "localhost:4317"
+	if value, ok := cfg.lookup("OTEL_EXPORTER_OTLP_ENDPOINT"); ok {
+		config.OTLPEndpoint = value
+	}
+	// the endpoint is not an URL, e.g. `http://collector:4317`
+	if _, _, err := net.SplitHostPort(config.OTLPEndpoint); err != nil || strings.Contains(config.OTLPEndpoint, "/") {
+		return nil, fmt.Errorf("Error: OTEL_EXPORTER_OTLP_ENDPOINT setting should be in host:port format, got %v", config.OTLPEndpoint)
+	}
+	var err error
+	if config.OTLPInsecure, err = getBool(cfg, "OTEL_EXPORTER_OTLP_INSECURE", false); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// tracingMiddleware starts a server span for every request, continuing the trace propagated by the client
+func tracingMiddleware(serverName string) gin.HandlerFunc {
+	tracer := otel.Tracer(instrumentationName)
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), c.Request.Header)
+		route := c.FullPath()
+		spanName := c.Request.Method + " " + route
+		if route == "" {
+			spanName = c.Request.Method
+		}
+		ctx, span := tracer.Start(ctx, spanName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(serverName, route, c.Request)...),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+	}
+}
+
+// startQuerySpan starts a span around a single Postgres query
+func startQuerySpan(ctx context.Context, operation string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	attributes = append([]label.KeyValue{semconv.DBSystemPostgres, semconv.DBOperationKey.String(operation)}, attributes...)
+	return otel.Tracer(instrumentationName).Start(ctx, "AccountService."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// endQuerySpan records the query error and ends the span
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		exporter *tracetest.InMemoryExporter
+		provider *sdktrace.TracerProvider
+		previous trace.TracerProvider
+
+		// spanAttributes returns attributes of a span as a map
+		spanAttributes = func(attributes []label.KeyValue) map[label.Key]string {
+			result := map[label.Key]string{}
+			for _, attribute := range attributes {
+				result[attribute.Key] = attribute.Value.Emit()
+			}
+			return result
+		}
+	)
+
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		previous = otel.GetTracerProvider()
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	Context("when a request is handled", func() {
+		var router *gin.Engine
+
+		BeforeEach(func() {
+			router = gin.New()
+			router.Use(tracingMiddleware("accounts"))
+			router.GET("/v1/account/:accountId", func(c *gin.Context) {
+				_, span := startQuerySpan(c.Request.Context(), "getAccount", label.String("account.id", c.Param("accountId")))
+				endQuerySpan(span, nil)
+				c.Status(http.StatusNotFound)
+			})
+		})
+
+		It("should record a server span named by the route, continuing the trace of the client", func() {
+			ctx, parent := provider.Tracer("test").Start(context.Background(), "client")
+			req := httptest.NewRequest("GET", "/v1/account/ad27e265-9605-4b4b-a0e5-3003ea9cc4dc", nil)
+			otel.GetTextMapPropagator().Inject(ctx, req.Header)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			parent.End()
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(3))
+			query, server := spans[0], spans[1]
+			Ω(server.Name).Should(Equal("GET /v1/account/:accountId"))
+			Ω(server.SpanKind).Should(Equal(trace.SpanKindServer))
+			Ω(server.SpanContext.TraceID).Should(Equal(parent.SpanContext().TraceID))
+			Ω(server.ParentSpanID).Should(Equal(parent.SpanContext().SpanID))
+			Ω(spanAttributes(server.Attributes)).Should(HaveKeyWithValue(label.Key("http.status_code"), "404"))
+			Ω(spanAttributes(server.Attributes)).Should(HaveKeyWithValue(label.Key("http.route"), "/v1/account/:accountId"))
+
+			Ω(query.Name).Should(Equal("AccountService.getAccount"))
+			Ω(query.SpanKind).Should(Equal(trace.SpanKindClient))
+			Ω(query.ParentSpanID).Should(Equal(server.SpanContext.SpanID))
+			Ω(query.StatusCode).Should(Equal(codes.Unset))
+			Ω(spanAttributes(query.Attributes)).Should(HaveKeyWithValue(label.Key("db.system"), "postgresql"))
+			Ω(spanAttributes(query.Attributes)).Should(HaveKeyWithValue(label.Key("db.operation"), "getAccount"))
+		})
+
+		It("should name spans of unknown routes by the method", func() {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/unknown", nil))
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(1))
+			Ω(spans[0].Name).Should(Equal("POST"))
+		})
+	})
+
+	Context("when a query fails", func() {
+
+		It("should mark the query span as failed", func() {
+			_, span := startQuerySpan(context.Background(), "deleteAccount")
+			endQuerySpan(span, errors.New("connection reset"))
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(1))
+			Ω(spans[0].StatusCode).Should(Equal(codes.Error))
+			Ω(spans[0].StatusMessage).Should(Equal("connection reset"))
+		})
+	})
+
+	Context("when the exporter is configured", func() {
+
+		It("should accept only known exporters", func() {
+			for _, name := range []string{"", "none", "stdout", "otlp"} {
+				config, err := getTracingConfig(&Config{values: map[string]string{"OTEL_TRACES_EXPORTER": name}})
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(config.Exporter).Should(Equal(name))
+			}
+			_, err := getTracingConfig(&Config{values: map[string]string{"OTEL_TRACES_EXPORTER": "jaeger"}})
+			Ω(err).Should(HaveOccurred())
+			_, err = setupTracing(&TracingConfig{Exporter: "jaeger"}, NewLogger(GinkgoWriter, levelError))
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should read the OTLP collector endpoint", func() {
+			config, err := getTracingConfig(&Config{values: map[string]string{"OTEL_TRACES_EXPORTER": "otlp"}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(config).Should(Equal(&TracingConfig{Exporter: "otlp", OTLPEndpoint: "localhost:4317"}))
+
+			config, err = getTracingConfig(&Config{values: map[string]string{
+				"OTEL_TRACES_EXPORTER":        "otlp",
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4317",
+				"OTEL_EXPORTER_OTLP_INSECURE": "true",
+			}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(config).Should(Equal(&TracingConfig{Exporter: "otlp", OTLPEndpoint: "collector:4317", OTLPInsecure: true}))
+
+			_, err = getTracingConfig(&Config{values: map[string]string{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector"}})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
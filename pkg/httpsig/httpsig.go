@@ -0,0 +1,377 @@
+// Package httpsig signs and verifies HTTP requests with a shared secret.
+//
+// Headers follow HTTP Message Signatures (RFC 9421) with the `hmac-sha256` algorithm.
+// Covered components are: method, path, query and the body digest. Example:
+//
+//	Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:
+//	Signature-Input: sig1=("@method" "@path" "@query" "content-digest");created=1618884473;keyid="key-1";alg="hmac-sha256";nonce="b3k2pp5k7z"
+//	Signature: sig1=:dMT/A/76ehrdBTD/2Xx8QuKV6FoyzEP/I9hdzKN8LQJLNgzU4W767HK05rx1i8meNQQgQPgQp8wq2ive3tV5Ag==:
+package httpsig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Algorithm is the only signature algorithm supported
+	Algorithm = "hmac-sha256"
+	// Label of the signature in `Signature` and `Signature-Input` headers
+	Label = "sig1"
+
+	HeaderContentDigest  = "Content-Digest"
+	HeaderSignature      = "Signature"
+	HeaderSignatureInput = "Signature-Input"
+)
+
+// Components covered by the signature, in the order they appear in the signature base
+var Components = []string{"@method", "@path", "@query", "content-digest"}
+
+var (
+	// ErrMissingSignature is returned when request has no signature headers
+	ErrMissingSignature = errors.New("Missing request signature")
+	// ErrMalformedSignature is returned when signature headers cannot be parsed or use not supported parameters
+	ErrMalformedSignature = errors.New("Malformed request signature")
+	// ErrUnknownKey is returned when the key used to sign the request is not known
+	ErrUnknownKey = errors.New("Unknown signature key")
+	// ErrDigestMismatch is returned when request body does not match its digest
+	ErrDigestMismatch = errors.New("Request body does not match its digest")
+	// ErrInvalidSignature is returned when signature does not match the request
+	ErrInvalidSignature = errors.New("Invalid request signature")
+	// ErrClockSkew is returned when the signature was created too long ago or in the future
+	ErrClockSkew = errors.New("Request signature creation time outside of allowed clock skew")
+	// ErrReplay is returned when the same signature nonce was already used
+	ErrReplay = errors.New("Request signature already used")
+)
+
+// Signer adds signature headers to requests
+type Signer struct {
+	KeyID  string           // Key identifier, sent along the signature so the receiver can find the secret
+	Secret []byte           // Shared secret
+	Now    func() time.Time // Clock used to set signature creation time, `time.Now` when not set
+}
+
+// Sign sets `Content-Digest`, `Signature-Input` and `Signature` headers on the request.
+// The body has to be the exact content sent with the request.
+func (s *Signer) Sign(req *http.Request, body []byte) error {
+	return s.SignDigest(req, Digest(body))
+}
+
+// SignDigest works like `Sign`, but takes the body digest, e.g. computed by `DigestReader`, so large bodies are not kept in memory
+func (s *Signer) SignDigest(req *http.Request, digest string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("Failed to generate nonce %v", err)
+	}
+	params := signatureParams{
+		created: now(s.Now).Unix(),
+		keyID:   s.KeyID,
+		alg:     Algorithm,
+		nonce:   hex.EncodeToString(nonce),
+	}
+	req.Header.Set(HeaderContentDigest, digest)
+	req.Header.Set(HeaderSignatureInput, Label+"="+params.String())
+	req.Header.Set(HeaderSignature, Label+"=:"+base64.StdEncoding.EncodeToString(sign(s.Secret, signatureBase(req, params)))+":")
+	return nil
+}
+
+// Verifier checks signatures created by `Signer`
+type Verifier struct {
+	Keys    func(keyID string) (secret []byte, ok bool) // Looks up the secret for the key
+	MaxSkew time.Duration                               // Maximum difference between signature creation time and now
+	Nonces  *NonceCache                                 // Remembers used nonces to reject replays, optional
+	Now     func() time.Time                            // Clock used to check creation time, `time.Now` when not set
+}
+
+// Verify checks the request signature and returns the key ID used to sign the request
+func (v *Verifier) Verify(req *http.Request, body []byte) (string, error) {
+	return v.verify(req, func() bool {
+		return req.Header.Get(HeaderContentDigest) == Digest(body)
+	})
+}
+
+// VerifyStream checks the request signature without reading the body, and returns the key ID used to sign the request.
+// The body is replaced with a reader which returns ErrDigestMismatch at the end of the body when it does not match its digest,
+// so the receiver has to read the whole body and discard its effects on error.
+func (v *Verifier) VerifyStream(req *http.Request) (string, error) {
+	keyID, err := v.verify(req, func() bool {
+		return strings.HasPrefix(req.Header.Get(HeaderContentDigest), digestPrefix)
+	})
+	if err != nil {
+		return "", err
+	}
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	req.Body = &digestReader{body: req.Body, hash: sha256.New(), digest: req.Header.Get(HeaderContentDigest)}
+	return keyID, nil
+}
+
+// verify checks the signature, then the body with `digestMatches`, then creation time and nonce
+func (v *Verifier) verify(req *http.Request, digestMatches func() bool) (string, error) {
+	signatureInput := req.Header.Get(HeaderSignatureInput)
+	signature := req.Header.Get(HeaderSignature)
+	if signatureInput == "" || signature == "" {
+		return "", ErrMissingSignature
+	}
+	params, err := parseSignatureInput(signatureInput)
+	if err != nil {
+		return "", err
+	}
+	mac, err := parseSignature(signature)
+	if err != nil {
+		return "", err
+	}
+	secret, ok := v.Keys(params.keyID)
+	if !ok {
+		return "", fmt.Errorf("key %q %w", params.keyID, ErrUnknownKey)
+	}
+	if !hmac.Equal(mac, sign(secret, signatureBase(req, params))) {
+		return "", ErrInvalidSignature
+	}
+	// body is checked after the signature, so the digest header itself is known to be authentic
+	if !digestMatches() {
+		return "", ErrDigestMismatch
+	}
+	currentTime := now(v.Now)
+	created := time.Unix(params.created, 0)
+	if created.Before(currentTime.Add(-v.MaxSkew)) || created.After(currentTime.Add(v.MaxSkew)) {
+		return "", fmt.Errorf("created %v %w", created.UTC(), ErrClockSkew)
+	}
+	if v.Nonces != nil && !v.Nonces.Add(params.keyID+":"+params.nonce, created.Add(v.MaxSkew), currentTime) {
+		return "", ErrReplay
+	}
+	return params.keyID, nil
+}
+
+const digestPrefix = "sha-256=:"
+
+// Digest returns `Content-Digest` header value of the body
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return encodeDigest(sum[:])
+}
+
+// DigestReader returns `Content-Digest` header value of everything read from `r`
+func DigestReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return encodeDigest(h.Sum(nil)), nil
+}
+
+func encodeDigest(sum []byte) string {
+	return digestPrefix + base64.StdEncoding.EncodeToString(sum) + ":"
+}
+
+// digestReader hashes the body while it is read, and compares the hash with the digest at the end of the body
+type digestReader struct {
+	body   io.ReadCloser
+	hash   hash.Hash
+	digest string
+	err    error
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && encodeDigest(r.hash.Sum(nil)) != r.digest {
+		// the mismatching bytes were already returned, the receiver has to discard them
+		err = ErrDigestMismatch
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *digestReader) Close() error {
+	return r.body.Close()
+}
+
+// nonceSweepInterval is how often expired nonces are removed from NonceCache
+const nonceSweepInterval = time.Minute
+
+// NonceCache remembers nonces until they expire
+type NonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewNonceCache creates an empty NonceCache
+func NewNonceCache() *NonceCache {
+	return &NonceCache{
+		nonces: map[string]time.Time{},
+	}
+}
+
+// Add remembers the nonce until `expiry`. It returns `false` when the nonce is already remembered at `currentTime`.
+func (c *NonceCache) Add(nonce string, expiry time.Time, currentTime time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if seenExpiry, ok := c.nonces[nonce]; ok && seenExpiry.After(currentTime) {
+		return false
+	}
+	if currentTime.Sub(c.lastSweep) > nonceSweepInterval {
+		c.sweep(currentTime)
+	}
+	c.nonces[nonce] = expiry
+	return true
+}
+
+// Len returns number of remembered nonces, including expired ones which were not removed yet
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.nonces)
+}
+
+// sweep forgets expired nonces, signatures using them are rejected because of clock skew anyway
+func (c *NonceCache) sweep(currentTime time.Time) {
+	for seen, seenExpiry := range c.nonces {
+		if !seenExpiry.After(currentTime) {
+			delete(c.nonces, seen)
+		}
+	}
+	c.lastSweep = currentTime
+}
+
+//
+// Implementation
+//
+
+type signatureParams struct {
+	created int64
+	keyID   string
+	alg     string
+	nonce   string
+}
+
+// String serializes covered components and parameters, e.g. `("@method" "@path");created=1618884473;keyid="key-1"`
+func (p signatureParams) String() string {
+	quoted := make([]string, len(Components))
+	for i, component := range Components {
+		quoted[i] = strconv.Quote(component)
+	}
+	return fmt.Sprintf("(%v);created=%v;keyid=%q;alg=%q;nonce=%q", strings.Join(quoted, " "), p.created, p.keyID, p.alg, p.nonce)
+}
+
+// signatureBase creates the string that is signed, see RFC 9421 section 2.5
+func signatureBase(req *http.Request, params signatureParams) []byte {
+	buf := bytes.Buffer{}
+	for _, component := range Components {
+		var value string
+		switch component {
+		case "@method":
+			value = strings.ToUpper(req.Method)
+		case "@path":
+			value = req.URL.EscapedPath()
+			if value == "" {
+				value = "/"
+			}
+		case "@query":
+			value = "?" + req.URL.RawQuery
+		default:
+			value = strings.TrimSpace(req.Header.Get(component))
+		}
+		fmt.Fprintf(&buf, "%q: %v\n", component, value)
+	}
+	fmt.Fprintf(&buf, "%q: %v", "@signature-params", params.String())
+	return buf.Bytes()
+}
+
+func sign(secret []byte, base []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(base)
+	return mac.Sum(nil)
+}
+
+func now(clock func() time.Time) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock()
+}
+
+// parseSignatureInput parses `Signature-Input` header created by `Signer`
+func parseSignatureInput(header string) (signatureParams, error) {
+	params := signatureParams{}
+	value := strings.TrimPrefix(header, Label+"=")
+	if value == header {
+		return params, fmt.Errorf("no %v label %w", Label, ErrMalformedSignature)
+	}
+	end := strings.Index(value, ")")
+	if !strings.HasPrefix(value, "(") || end < 0 {
+		return params, fmt.Errorf("no covered components %w", ErrMalformedSignature)
+	}
+	components := strings.Fields(value[1:end])
+	if len(components) != len(Components) {
+		return params, fmt.Errorf("wrong covered components %w", ErrMalformedSignature)
+	}
+	for i, component := range components {
+		if component != strconv.Quote(Components[i]) {
+			return params, fmt.Errorf("wrong covered component %v %w", component, ErrMalformedSignature)
+		}
+	}
+	for _, param := range strings.Split(value[end+1:], ";") {
+		if param == "" {
+			continue
+		}
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return params, fmt.Errorf("wrong parameter %v %w", param, ErrMalformedSignature)
+		}
+		var err error
+		switch kv[0] {
+		case "created":
+			params.created, err = strconv.ParseInt(kv[1], 10, 64)
+		case "keyid":
+			params.keyID, err = strconv.Unquote(kv[1])
+		case "alg":
+			params.alg, err = strconv.Unquote(kv[1])
+		case "nonce":
+			params.nonce, err = strconv.Unquote(kv[1])
+		default:
+			err = fmt.Errorf("not supported")
+		}
+		if err != nil {
+			return params, fmt.Errorf("wrong parameter %v: %v %w", kv[0], err, ErrMalformedSignature)
+		}
+	}
+	if params.alg != Algorithm || params.keyID == "" || params.nonce == "" || params.created == 0 {
+		return params, fmt.Errorf("missing parameters %w", ErrMalformedSignature)
+	}
+	return params, nil
+}
+
+// parseSignature parses `Signature` header, i.e. `sig1=:base64:`
+func parseSignature(header string) ([]byte, error) {
+	value := strings.TrimPrefix(header, Label+"=")
+	if value == header || len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+		return nil, fmt.Errorf("wrong signature %w", ErrMalformedSignature)
+	}
+	mac, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+	if err != nil {
+		return nil, fmt.Errorf("wrong signature encoding %v %w", err, ErrMalformedSignature)
+	}
+	return mac, nil
+}
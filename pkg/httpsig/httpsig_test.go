@@ -0,0 +1,185 @@
+package httpsig_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fkondej/go-showcase/v1/pkg/httpsig"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTP Message Signatures", func() {
+	var (
+		now      time.Time
+		signer   *httpsig.Signer
+		verifier *httpsig.Verifier
+		body     []byte
+		req      *http.Request
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2021, 1, 20, 10, 0, 0, 0, time.UTC)
+		clock := func() time.Time { return now }
+		signer = &httpsig.Signer{
+			KeyID:  "key-1",
+			Secret: []byte("secret-1"),
+			Now:    clock,
+		}
+		verifier = &httpsig.Verifier{
+			Keys: func(keyID string) ([]byte, bool) {
+				if keyID == "key-1" {
+					return []byte("secret-1"), true
+				}
+				return nil, false
+			},
+			MaxSkew: time.Minute,
+			Nonces:  httpsig.NewNonceCache(),
+			Now:     clock,
+		}
+		body = []byte(`{"data": {"id": "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"}}`)
+		var err error
+		req, err = http.NewRequest("POST", "http://localhost:8080/v1/account/?page%5Bnumber%5D=1", bytes.NewReader(body))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(signer.Sign(req, body)).Should(Succeed())
+	})
+
+	Context("when request is signed", func() {
+
+		It("should set signature headers", func() {
+			Ω(req.Header.Get("Content-Digest")).Should(Equal(httpsig.Digest(body)))
+			Ω(req.Header.Get("Signature-Input")).Should(HavePrefix(`sig1=("@method" "@path" "@query" "content-digest");created=1611136800;keyid="key-1";alg="hmac-sha256";nonce="`))
+			Ω(req.Header.Get("Signature")).Should(MatchRegexp(`^sig1=:[A-Za-z0-9+/]+=*:$`))
+		})
+
+		It("should be verified and return key ID", func() {
+			keyID, err := verifier.Verify(req, body)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(keyID).Should(Equal("key-1"))
+		})
+
+		It("should be verified within allowed clock skew", func() {
+			now = now.Add(59 * time.Second)
+			_, err := verifier.Verify(req, body)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	DescribeTable("when request is modified after signing",
+		func(modify func(req *http.Request) []byte, expectedErr error) {
+			body = modify(req)
+			keyID, err := verifier.Verify(req, body)
+			Ω(err).Should(MatchError(expectedErr))
+			Ω(keyID).Should(BeEmpty())
+		},
+		Entry("method", func(req *http.Request) []byte { req.Method = "DELETE"; return body }, httpsig.ErrInvalidSignature),
+		Entry("path", func(req *http.Request) []byte { req.URL.Path = "/v1/account/other"; return body }, httpsig.ErrInvalidSignature),
+		Entry("query", func(req *http.Request) []byte { req.URL.RawQuery = "page%5Bnumber%5D=2"; return body }, httpsig.ErrInvalidSignature),
+		Entry("body", func(req *http.Request) []byte { return []byte(`{"data": {}}`) }, httpsig.ErrDigestMismatch),
+		Entry("digest", func(req *http.Request) []byte {
+			body = []byte(`{"data": {}}`)
+			req.Header.Set("Content-Digest", httpsig.Digest(body))
+			return body
+		}, httpsig.ErrInvalidSignature),
+		Entry("key", func(req *http.Request) []byte {
+			req.Header.Set("Signature-Input", strings.Replace(req.Header.Get("Signature-Input"), `"key-1"`, `"key-2"`, 1))
+			return body
+		}, httpsig.ErrUnknownKey),
+		Entry("signature headers removed", func(req *http.Request) []byte {
+			req.Header.Del("Signature")
+			return body
+		}, httpsig.ErrMissingSignature),
+		Entry("signature params", func(req *http.Request) []byte {
+			req.Header.Set("Signature-Input", `sig1=("@method");created=1611136800;keyid="key-1";alg="hmac-sha256";nonce="abc"`)
+			return body
+		}, httpsig.ErrMalformedSignature),
+	)
+
+	Context("when signature is too old", func() {
+
+		It("should return ErrClockSkew", func() {
+			now = now.Add(2 * time.Minute)
+			_, err := verifier.Verify(req, body)
+			Ω(err).Should(MatchError(httpsig.ErrClockSkew))
+		})
+	})
+
+	Context("when signature is created in the future", func() {
+
+		It("should return ErrClockSkew", func() {
+			now = now.Add(-2 * time.Minute)
+			_, err := verifier.Verify(req, body)
+			Ω(err).Should(MatchError(httpsig.ErrClockSkew))
+		})
+	})
+
+	Context("when signed request is sent again", func() {
+
+		It("should return ErrReplay", func() {
+			_, err := verifier.Verify(req, body)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = verifier.Verify(req, body)
+			Ω(err).Should(MatchError(httpsig.ErrReplay))
+		})
+
+		It("should accept the same request signed again", func() {
+			_, err := verifier.Verify(req, body)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(signer.Sign(req, body)).Should(Succeed())
+			_, err = verifier.Verify(req, body)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	Context("when nonces expire", func() {
+
+		It("should forget them at most once a minute", func() {
+			cache := httpsig.NewNonceCache()
+			Ω(cache.Add("a", now.Add(time.Second), now)).Should(BeTrue())
+			Ω(cache.Add("b", now.Add(time.Hour), now.Add(2*time.Second))).Should(BeTrue())
+			// expired nonce is remembered until the next sweep
+			Ω(cache.Len()).Should(Equal(2))
+
+			Ω(cache.Add("c", now.Add(time.Hour), now.Add(2*time.Minute))).Should(BeTrue())
+			Ω(cache.Len()).Should(Equal(2))
+			Ω(cache.Add("b", now.Add(time.Hour), now.Add(3*time.Minute))).Should(BeFalse())
+		})
+	})
+
+	Context("when body is verified while streaming", func() {
+
+		It("should sign the digest of the reader", func() {
+			digest, err := httpsig.DigestReader(bytes.NewReader(body))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(digest).Should(Equal(httpsig.Digest(body)))
+			Ω(signer.SignDigest(req, digest)).Should(Succeed())
+			_, err = verifier.Verify(req, body)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should return the body and key ID", func() {
+			keyID, err := verifier.VerifyStream(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(keyID).Should(Equal("key-1"))
+			Ω(ioutil.ReadAll(req.Body)).Should(Equal(body))
+		})
+
+		It("should return ErrDigestMismatch at the end of modified body", func() {
+			req.Body = ioutil.NopCloser(strings.NewReader(`{"data": {}}`))
+			_, err := verifier.VerifyStream(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = ioutil.ReadAll(req.Body)
+			Ω(err).Should(MatchError(httpsig.ErrDigestMismatch))
+		})
+
+		It("should return ErrInvalidSignature when digest is modified", func() {
+			req.Header.Set("Content-Digest", httpsig.Digest([]byte(`{"data": {}}`)))
+			_, err := verifier.VerifyStream(req)
+			Ω(err).Should(MatchError(httpsig.ErrInvalidSignature))
+		})
+	})
+})
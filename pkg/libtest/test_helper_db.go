@@ -51,7 +51,7 @@ func getDBConnConfig() *pgxpool.Config {
 		connConfig.ConnConfig.Host = host
 	}
 	if port, ok := os.LookupEnv("DB_PORT"); ok {
-		if port, err := strconv.Atoi(port); err != nil {
+		if port, err := strconv.Atoi(port); err == nil {
 			connConfig.ConnConfig.Port = uint16(port)
 		}
 	}
@@ -108,6 +108,13 @@ func DBDeleteAccount(id string) {
 // Special functions
 //
 
+func DBSetModifiedOn(id string, modifiedOn time.Time) *DBAccount {
+	_, err := dbConnPool.Exec(context.Background(), "UPDATE \"Account\" SET modified_on = $2 WHERE id = $1", id, modifiedOn)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return DBGetAccount(id)
+}
+
 func DBGetOneRandomAccount() *DBAccount {
 
 	row := dbConnPool.QueryRow(context.Background(), "select id, organisation_id, version, is_deleted, is_locked, created_on, modified_on, record FROM \"Account\" ORDER BY random() LIMIT 1")
@@ -167,6 +174,43 @@ func DBCreateAccounts(num int) []*DBAccount {
 	return DBGetAccounts(ids)
 }
 
+//
+// Jobs
+//
+
+type DBJob struct {
+	ID              uuid.UUID
+	Status          string
+	Processed       int64
+	CancelRequested bool
+	Attempts        int
+}
+
+// DBCreateRunningJob inserts the export job as if it was claimed by a worker, with the last heartbeat `heartbeatAge` ago
+func DBCreateRunningJob(processed int64, heartbeatAge time.Duration) string {
+	id := GenerateID()
+	_, err := dbConnPool.Exec(context.Background(),
+		`INSERT INTO "Job" (id, owner, type, params, status, processed, attempts, started_on, heartbeat_on)
+		VALUES ($1, '', 'export', '{"format": "ndjson", "filter": {}, "include_pii": false}', 'running', $2, 1, $3, $3)`,
+		id, processed, time.Now().Add(-heartbeatAge))
+	Ω(err).ShouldNot(HaveOccurred())
+	return id
+}
+
+func DBGetJob(id string) *DBJob {
+	job := DBJob{}
+	err := dbConnPool.QueryRow(context.Background(),
+		`SELECT id, status, processed, cancel_requested, attempts FROM "Job" WHERE id = $1`, id,
+	).Scan(&job.ID, &job.Status, &job.Processed, &job.CancelRequested, &job.Attempts)
+	Ω(err).ShouldNot(HaveOccurred())
+	return &job
+}
+
+func DBDeleteJob(id string) {
+	_, err := dbConnPool.Exec(context.Background(), `DELETE FROM "Job" WHERE id = $1`, id)
+	Ω(err).ShouldNot(HaveOccurred())
+}
+
 //
 // Implementation functions
 //
@@ -0,0 +1,110 @@
+package libtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// TestCertificates are PEM files of a test CA and certificates signed by it
+type TestCertificates struct {
+	Dir            string // temporary directory with all files
+	CAFile         string
+	ServerCertFile string // valid for localhost and 127.0.0.1
+	ServerKeyFile  string
+	ClientCertFile string
+	ClientKeyFile  string
+
+	CAPool            *x509.CertPool
+	ServerCertificate tls.Certificate
+}
+
+// Remove deletes all certificate files
+func (tc *TestCertificates) Remove() {
+	os.RemoveAll(tc.Dir)
+}
+
+// GenerateTestCertificates creates a new CA, server certificate, and client certificate with `clientCommonName` subject
+func GenerateTestCertificates(clientCommonName string) *TestCertificates {
+	dir, err := ioutil.TempDir("", "certificates")
+	Ω(err).ShouldNot(HaveOccurred())
+
+	result := TestCertificates{
+		Dir:            dir,
+		CAFile:         filepath.Join(dir, "ca.pem"),
+		ServerCertFile: filepath.Join(dir, "server.pem"),
+		ServerKeyFile:  filepath.Join(dir, "server-key.pem"),
+		ClientCertFile: filepath.Join(dir, "client.pem"),
+		ClientKeyFile:  filepath.Join(dir, "client-key.pem"),
+		CAPool:         x509.NewCertPool(),
+	}
+
+	caTemplate := certificateTemplate("Test CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caCert, caKey := writeCertificate(caTemplate, nil, nil, result.CAFile, filepath.Join(dir, "ca-key.pem"))
+	result.CAPool.AddCert(caCert)
+
+	serverTemplate := certificateTemplate("localhost")
+	serverTemplate.DNSNames = []string{"localhost"}
+	serverTemplate.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	writeCertificate(serverTemplate, caCert, caKey, result.ServerCertFile, result.ServerKeyFile)
+
+	clientTemplate := certificateTemplate(clientCommonName)
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	writeCertificate(clientTemplate, caCert, caKey, result.ClientCertFile, result.ClientKeyFile)
+
+	result.ServerCertificate, err = tls.LoadX509KeyPair(result.ServerCertFile, result.ServerKeyFile)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return &result
+}
+
+func certificateTemplate(commonName string) *x509.Certificate {
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"go-showcase"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+// writeCertificate creates a new key and certificate signed by `parent`, or self signed when `parent` is nil
+func writeCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Ω(err).ShouldNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Ω(err).ShouldNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	Ω(err).ShouldNot(HaveOccurred())
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return cert, key
+}